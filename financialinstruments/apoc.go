@@ -0,0 +1,133 @@
+package financialinstruments
+
+import (
+	"fmt"
+	"github.com/Financial-Times/up-rw-app-api-go/rwapi"
+	"github.com/jmcvetta/neoism"
+	"strings"
+)
+
+//WithPeriodicCommit makes WriteBatch, when given more than batchSize financial instruments, create their identifiers with
+//APOC's apoc.periodic.iterate, committing batchSize identifiers at a time, so that huge batches don't exhaust the Neo4j heap.
+//If APOC isn't installed, the identifiers are created in plain transactions for batchSize financial instruments at a time instead.
+//Either way the identifiers aren't written in the same transaction as the rest of the financial instruments,
+//so a failure can leave some without their identifiers until they are written again.
+func WithPeriodicCommit(batchSize int) Option {
+	return func(s *service) {
+		s.periodicCommitSize = batchSize
+	}
+}
+
+//procedureNotFound is the code of the error Neo4j returns for a call to a procedure that isn't installed
+const procedureNotFound = "Neo.ClientError.Procedure.ProcedureNotFound"
+
+//isProcedureNotFound tells whether err, an error from running queries, is Neo4j's error for a procedure that isn't installed,
+//whether it came straight from neoism or from one of neoutils' runners, the same way classify tells errors apart
+func isProcedureNotFound(err error) bool {
+	switch err := err.(type) {
+	case neoism.TxErrorList:
+		for _, txErr := range err {
+			if txErr.Code == procedureNotFound {
+				return true
+			}
+		}
+	case rwapi.ConstraintOrTransactionError:
+		for _, detail := range err.Details {
+			if strings.Contains(detail, procedureNotFound) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//writeIdentifiersPeriodically runs queries, which write fis without their identifiers, then creates the identifiers
+//of those guard didn't skip as WithPeriodicCommit describes, then marks the deprecated ones and runs after
+func (s service) writeIdentifiersPeriodically(queries []*neoism.CypherQuery, fis []financialInstrument, opts WriteOptions, after []*neoism.CypherQuery, guard *writeGuard) error {
+	if err := s.cypherBatch(queries); err != nil {
+		return err
+	}
+	fis = guard.written(fis)
+
+	// The identifiers of each of fis, which are created by these plain queries if APOC isn't installed
+	identifierQueries := make([][]*neoism.CypherQuery, 0, len(fis))
+	deprecations := []*neoism.CypherQuery{}
+	for _, fi := range fis {
+		identifierQueries = append(identifierQueries, s.createIdentifierQueries(fi, identifierQueryFor(opts)))
+		deprecations = append(deprecations, deprecateIdentifiersQueries(fi)...)
+	}
+
+	if err := s.iterateIdentifiers(fis, opts); err != nil {
+		if !isProcedureNotFound(err) {
+			return err
+		}
+		for start := 0; start < len(fis); start += s.periodicCommitSize {
+			batch := []*neoism.CypherQuery{}
+			for i := start; i < start+s.periodicCommitSize && i < len(fis); i++ {
+				batch = append(batch, identifierQueries[i]...)
+			}
+			if err := s.cypherBatch(batch); err != nil {
+				return err
+			}
+		}
+	}
+
+	after = append(deprecations, after...)
+	if err := s.cypherBatch(after); err != nil {
+		return err
+	}
+
+	mirrored := append([]*neoism.CypherQuery{}, queries...)
+	for _, fiIdentifierQueries := range identifierQueries {
+		mirrored = append(mirrored, fiIdentifierQueries...)
+	}
+	s.mirror(append(mirrored, after...))
+	return nil
+}
+
+//iterateIdentifiers creates the identifiers of fis with apoc.periodic.iterate, one call per identifier type
+func (s service) iterateIdentifiers(fis []financialInstrument, opts WriteOptions) error {
+	create := "CREATE"
+	if opts.PreserveRelationships {
+		create = "MERGE"
+	}
+
+	for _, identifierType := range identifierTypes {
+		rows := []map[string]interface{}{}
+		for _, fi := range fis {
+			for _, value := range identifierValues(fi, identifierType) {
+				rows = append(rows, map[string]interface{}{"uuid": fi.UUID, "value": value})
+			}
+		}
+		if len(rows) == 0 {
+			continue
+		}
+
+		results := []struct {
+			FailedBatches int            `json:"failedBatches"`
+			ErrorMessages map[string]int `json:"errorMessages"`
+		}{}
+		query := &neoism.CypherQuery{
+			Statement: `CALL apoc.periodic.iterate({outer}, {inner}, {batchSize: {batchSize}, iterateList: false, params: {rows: {rows}}})
+					YIELD failedBatches, errorMessages
+					RETURN failedBatches, errorMessages`,
+			Parameters: map[string]interface{}{
+				"outer": `UNWIND {rows} AS row RETURN row`,
+				"inner": fmt.Sprintf(`MATCH (t:Thing {uuid:{row}.uuid})
+					%s (i:Identifier:%s {value:{row}.value})
+					MERGE (t)<-[:IDENTIFIES]-(i)`, create, s.label(identifierType)),
+				"batchSize": s.periodicCommitSize,
+				"rows":      rows,
+			},
+			Result: &results,
+		}
+
+		if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+			return err
+		}
+		if len(results) > 0 && results[0].FailedBatches > 0 {
+			return fmt.Errorf("%d batches of %s identifiers failed to be written: %v", results[0].FailedBatches, identifierType, results[0].ErrorMessages)
+		}
+	}
+	return nil
+}
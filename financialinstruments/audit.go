@@ -0,0 +1,84 @@
+package financialinstruments
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	auditWrite  = "write"
+	auditDelete = "delete"
+)
+
+//AuditRecord describes one successful Write or Delete, in a form that can be serialised and later given to Replay
+type AuditRecord struct {
+	Operation     string               `json:"operation"`
+	UUID          string               `json:"uuid"`
+	TransactionID string               `json:"transactionId,omitempty"`
+	Payload       *financialInstrument `json:"payload,omitempty"`
+	Options       *WriteOptions        `json:"options,omitempty"`
+}
+
+//AuditSink is given a record of every successful Write and Delete, in the order they complete
+type AuditSink interface {
+	Record(record AuditRecord) error
+}
+
+//WithAuditSink makes the service record each successful Write and Delete to sink.
+//A failure to record is logged but does not fail the Write or Delete, which have already been applied.
+func WithAuditSink(sink AuditSink) Option {
+	return func(s *service) {
+		s.auditSink = sink
+	}
+}
+
+func (s service) audit(record AuditRecord) {
+	if s.auditSink == nil {
+		return
+	}
+	if err := s.auditSink.Record(record); err != nil {
+		log.WithError(err).WithField("uuid", record.UUID).Errorf("Failed to record %s to the audit sink", record.Operation)
+	}
+}
+
+//Replay reads JSON encoded AuditRecords from r and re-applies them in order, returning how many were applied.
+//It stops at the first record that can't be decoded or applied. Replayed operations are not recorded to the audit sink again.
+func (s service) Replay(r io.Reader) (int, error) {
+	replayer := s
+	replayer.auditSink = nil
+
+	dec := json.NewDecoder(r)
+	applied := 0
+	for {
+		record := AuditRecord{}
+		if err := dec.Decode(&record); err == io.EOF {
+			return applied, nil
+		} else if err != nil {
+			return applied, err
+		}
+
+		switch record.Operation {
+		case auditWrite:
+			if record.Payload == nil {
+				return applied, fmt.Errorf("Audit record %d for %s has no payload to write", applied+1, record.UUID)
+			}
+			opts := WriteOptions{}
+			if record.Options != nil {
+				opts = *record.Options
+			}
+			if _, err := replayer.WriteWithOptions(*record.Payload, record.TransactionID, opts); err != nil {
+				return applied, err
+			}
+		case auditDelete:
+			if _, err := replayer.Delete(record.UUID, record.TransactionID); err != nil {
+				return applied, err
+			}
+		default:
+			return applied, fmt.Errorf("Audit record %d for %s has unknown operation %q", applied+1, record.UUID, record.Operation)
+		}
+		applied++
+	}
+}
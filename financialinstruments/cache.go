@@ -0,0 +1,84 @@
+package financialinstruments
+
+import (
+	"container/list"
+	"sync"
+)
+
+//Cache holds financial instruments as projected by Read, keyed by uuid, so popular financial instruments don't hit Neo4j on every Read.
+//Implementations must be safe for concurrent use.
+type Cache interface {
+	Get(uuid string) (interface{}, bool)
+	Set(uuid string, fi interface{})
+	Delete(uuid string)
+}
+
+//WithCache makes Read check cache before querying Neo4j, and store what it reads there.
+//Entries are invalidated by the service's own writes and deletes, but not by changes made to Neo4j by anything else.
+func WithCache(cache Cache) Option {
+	return func(s *service) {
+		s.cache = cache
+	}
+}
+
+//LRUCache is an in-memory Cache holding up to a fixed number of financial instruments, evicting the least recently used
+type LRUCache struct {
+	sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	uuid string
+	fi   interface{}
+}
+
+//NewLRUCache returns an empty LRUCache holding at most size financial instruments
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (c *LRUCache) Get(uuid string) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	element, ok := c.entries[uuid]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruEntry).fi, true
+}
+
+func (c *LRUCache) Set(uuid string, fi interface{}) {
+	c.Lock()
+	defer c.Unlock()
+
+	if element, ok := c.entries[uuid]; ok {
+		element.Value.(*lruEntry).fi = fi
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[uuid] = c.order.PushFront(&lruEntry{uuid: uuid, fi: fi})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).uuid)
+	}
+}
+
+func (c *LRUCache) Delete(uuid string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if element, ok := c.entries[uuid]; ok {
+		c.order.Remove(element)
+		delete(c.entries, uuid)
+	}
+}
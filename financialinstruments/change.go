@@ -0,0 +1,37 @@
+package financialinstruments
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//The kinds of change a ChangeHandler is told about
+const (
+	ChangeWrite  = auditWrite
+	ChangeDelete = auditDelete
+)
+
+//ChangeHandler is called with the uuid of each financial instrument that is successfully written or deleted,
+//and whether it was a ChangeWrite or a ChangeDelete
+type ChangeHandler func(uuid string, kind string)
+
+func noopChangeHandler(uuid string, kind string) {}
+
+//WithChangeHandler makes the service call handler after each successful Write and Delete, before the call returns,
+//so that handler sees the changes in the order they were made. A panic in handler is logged, and doesn't fail the
+//Write or Delete, which have already been applied. By default changes aren't handled.
+func WithChangeHandler(handler ChangeHandler) Option {
+	return func(s *service) {
+		s.changeHandler = handler
+	}
+}
+
+func (s service) notify(uuid string, kind string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.WithError(fmt.Errorf("%v", r)).WithField("uuid", uuid).Errorf("Change handler panicked handling %s", kind)
+		}
+	}()
+	s.changeHandler(uuid, kind)
+}
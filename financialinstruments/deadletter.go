@@ -0,0 +1,44 @@
+package financialinstruments
+
+//DeadLetterSink is given each financial instrument that WriteEach or WriteStream fails to validate or write, with the reason it failed,
+//so that it can be inspected and written again later
+type DeadLetterSink interface {
+	DeadLetter(fi financialInstrument, err error)
+}
+
+type noopDeadLetterSink struct{}
+
+func (noopDeadLetterSink) DeadLetter(fi financialInstrument, err error) {}
+
+//deadLettering returns whether the service was created WithDeadLetterSink
+func (s service) deadLettering() bool {
+	_, dropped := s.deadLetterSink.(noopDeadLetterSink)
+	return !dropped
+}
+
+//WithDeadLetterSink makes WriteEach and WriteStream give each financial instrument they fail to write to sink.
+//By default WriteEach drops them, and WriteStream stops at the first one.
+func WithDeadLetterSink(sink DeadLetterSink) Option {
+	return func(s *service) {
+		s.deadLetterSink = sink
+	}
+}
+
+//WriteEach writes each of fis in turn, in its own transaction, for ingesting a stream where one bad financial instrument
+//shouldn't stop the rest being written. Each one that fails validation or the write is given to the dead letter sink,
+//but those skipped because a more trusted version is stored, or already written with the same idempotency key, are not.
+//It returns how many were written.
+func (s service) WriteEach(fis []financialInstrument, transactionID string, opts WriteOptions) int {
+	written := 0
+	for _, fi := range fis {
+		result, err := s.WriteWithOptions(fi, transactionID, opts)
+		if err != nil {
+			s.deadLetterSink.DeadLetter(fi, err)
+			continue
+		}
+		if len(result.Skipped) == 0 && len(result.Duplicates) == 0 {
+			written++
+		}
+	}
+	return written
+}
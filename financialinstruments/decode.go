@@ -0,0 +1,322 @@
+package financialinstruments
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+//WithFastDecode makes reads of a single financial instrument decode the rows returned by financialInstrumentProjection
+//with a hand-written parser, rather than having encoding/json fill in financialInstrumentRow by reflection.
+//It cuts all the strings of the rows from one copy of them, so makes fewer allocations but holds on to a little more memory.
+//BenchmarkReadDecode compares the two.
+func WithFastDecode() Option {
+	return func(s *service) {
+		s.fastDecode = true
+	}
+}
+
+//fastRows are financialInstrumentRows that decode themselves from JSON without reflection.
+//A *[]financialInstrumentRow can be converted to a *fastRows to be used as the Result of a query.
+type fastRows []financialInstrumentRow
+
+func (rows *fastRows) UnmarshalJSON(data []byte) error {
+	d := rowDecoder{data: data, str: string(data), strs: make([]string, 0, 8)}
+	if d.null() {
+		*rows = nil
+		return nil
+	}
+	decoded := []financialInstrumentRow{}
+	if err := d.expect('['); err != nil {
+		return err
+	}
+	for !d.next(']') {
+		if len(decoded) > 0 {
+			if err := d.expect(','); err != nil {
+				return err
+			}
+		}
+		decoded = append(decoded, financialInstrumentRow{})
+		if err := d.row(&decoded[len(decoded)-1]); err != nil {
+			return err
+		}
+	}
+	*rows = decoded
+	return nil
+}
+
+//rowDecoder decodes financialInstrumentRows from data, which encoding/json has already checked is valid JSON
+type rowDecoder struct {
+	data []byte
+	// str is data as a string, which the strings decoded are cut from so that they don't need allocating one by one
+	str string
+	// strs is the array the lists of strings decoded are cut from, for the same reason
+	strs []string
+	off  int
+}
+
+func (d *rowDecoder) row(row *financialInstrumentRow) error {
+	if err := d.expect('{'); err != nil {
+		return err
+	}
+	for first := true; !d.next('}'); first = false {
+		if !first {
+			if err := d.expect(','); err != nil {
+				return err
+			}
+		}
+		column, err := d.stringBytes()
+		if err != nil {
+			return err
+		}
+		if err := d.expect(':'); err != nil {
+			return err
+		}
+
+		switch string(column) {
+		case "uuid":
+			row.UUID, err = d.string()
+		case "hash":
+			row.Hash, err = d.string()
+		case "prefLabel":
+			row.PrefLabel, err = d.string()
+		case "aliases":
+			row.Aliases, err = d.strings()
+		case "currency":
+			row.Currency, err = d.string()
+		case "micCode":
+			row.MICCode, err = d.string()
+		case "sector":
+			row.Sector, err = d.string()
+		case "countryOfRisk":
+			row.CountryOfRisk, err = d.string()
+		case "isTest":
+			row.IsTest, err = d.bool()
+		case "source":
+			row.Source, err = d.string()
+		case "trustLevel":
+			row.TrustLevel, err = d.int()
+		case "issueDate":
+			row.IssueDate, err = d.string()
+		case "status":
+			row.Status, err = d.string()
+		case "primaryIdentifierType":
+			row.PrimaryIdentifierType, err = d.string()
+		case "issuedBy":
+			row.IssuedBy, err = d.string()
+		case "tags":
+			row.Tags, err = d.strings()
+		case "uuids":
+			row.UUIDS, err = d.strings()
+		case "figiCode":
+			row.FIGICode, err = d.string()
+		case "factsetIdentifier":
+			row.FactsetIdentifier, err = d.string()
+		case "wsodIdentifier":
+			row.WSODIdentifier, err = d.string()
+		case "deprecatedIdentifiers":
+			row.DeprecatedIdentifiers, err = d.strings()
+		case "relationshipProperties":
+			// Only read WithRelationshipProperties, and arbitrarily nested, so left to encoding/json
+			start := d.off
+			if err = d.skip(); err == nil {
+				err = json.Unmarshal(d.data[start:d.off], &row.RelationshipProperties)
+			}
+		default:
+			err = d.skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//space moves past any whitespace and returns the next byte, or 0 at the end of data
+func (d *rowDecoder) space() byte {
+	for ; d.off < len(d.data); d.off++ {
+		switch c := d.data[d.off]; c {
+		case ' ', '\t', '\n', '\r':
+		default:
+			return c
+		}
+	}
+	return 0
+}
+
+//next moves past c if it is next, and tells whether it was
+func (d *rowDecoder) next(c byte) bool {
+	if d.space() != c {
+		return false
+	}
+	d.off++
+	return true
+}
+
+func (d *rowDecoder) expect(c byte) error {
+	if !d.next(c) {
+		return d.unexpected(fmt.Sprintf("%q", c))
+	}
+	return nil
+}
+
+func (d *rowDecoder) unexpected(expected string) error {
+	if d.off >= len(d.data) {
+		return fmt.Errorf("Unexpected end of financial instrument rows, expected %s", expected)
+	}
+	return fmt.Errorf("Unexpected %q at offset %d of financial instrument rows, expected %s", d.data[d.off], d.off, expected)
+}
+
+//null moves past a null if it is next, and tells whether it was
+func (d *rowDecoder) null() bool {
+	if d.space() != 'n' || len(d.data)-d.off < 4 || string(d.data[d.off:d.off+4]) != "null" {
+		return false
+	}
+	d.off += 4
+	return true
+}
+
+func (d *rowDecoder) string() (string, error) {
+	if d.null() {
+		return "", nil
+	}
+	start := d.off
+	str, err := d.stringBytes()
+	if err != nil || d.off-start-2 != len(str) {
+		// The string had escapes, so isn't in str as decoded
+		return string(str), err
+	}
+	return d.str[start+1 : d.off-1], nil
+}
+
+//stringBytes decodes a string, returning the bytes of data it is made of unless it has escapes,
+//so that columns can be told apart without allocating
+func (d *rowDecoder) stringBytes() ([]byte, error) {
+	if d.space() != '"' {
+		return nil, d.unexpected("a string")
+	}
+	start := d.off
+	escaped := false
+	for d.off++; d.off < len(d.data); d.off++ {
+		switch d.data[d.off] {
+		case '\\':
+			escaped = true
+			d.off++
+		case '"':
+			d.off++
+			if !escaped {
+				return d.data[start+1 : d.off-1], nil
+			}
+			// Escapes are rare in financial instruments, so they are left to encoding/json
+			var str string
+			err := json.Unmarshal(d.data[start:d.off], &str)
+			return []byte(str), err
+		}
+	}
+	return nil, d.unexpected(`'"'`)
+}
+
+//strings decodes an array of strings, which is nil if null and empty if []
+func (d *rowDecoder) strings() ([]string, error) {
+	if d.null() {
+		return nil, nil
+	}
+	if err := d.expect('['); err != nil {
+		return nil, err
+	}
+	start := len(d.strs)
+	for !d.next(']') {
+		if len(d.strs) > start {
+			if err := d.expect(','); err != nil {
+				return nil, err
+			}
+		}
+		str, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		d.strs = append(d.strs, str)
+	}
+	// Capped so that appending to one list can't overwrite the next
+	return d.strs[start:len(d.strs):len(d.strs)], nil
+}
+
+func (d *rowDecoder) bool() (bool, error) {
+	if d.null() {
+		return false, nil
+	}
+	for _, literal := range []string{"true", "false"} {
+		if len(d.data)-d.off >= len(literal) && string(d.data[d.off:d.off+len(literal)]) == literal {
+			d.off += len(literal)
+			return literal == "true", nil
+		}
+	}
+	return false, d.unexpected("a bool")
+}
+
+func (d *rowDecoder) int() (int, error) {
+	if d.null() {
+		return 0, nil
+	}
+	sign := 1
+	if d.space() == '-' {
+		sign = -1
+		d.off++
+	}
+	start := d.off
+	n := 0
+	for ; d.off < len(d.data) && d.data[d.off] >= '0' && d.data[d.off] <= '9'; d.off++ {
+		n = n*10 + int(d.data[d.off]-'0')
+	}
+	if d.off == start {
+		return 0, d.unexpected("an integer")
+	}
+	if d.off < len(d.data) {
+		switch d.data[d.off] {
+		case '.', 'e', 'E':
+			return 0, d.unexpected("an integer")
+		}
+	}
+	return sign * n, nil
+}
+
+//skip moves past the next value, whatever it is
+func (d *rowDecoder) skip() error {
+	switch d.space() {
+	case '"':
+		_, err := d.stringBytes()
+		return err
+	case '{', '[':
+		depth := 0
+		for ; d.off < len(d.data); d.off++ {
+			switch d.data[d.off] {
+			case '"':
+				if _, err := d.stringBytes(); err != nil {
+					return err
+				}
+				d.off--
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					d.off++
+					return nil
+				}
+			}
+		}
+		return d.unexpected("the end of an object or array")
+	default:
+		// A literal or a number, which ends at the next delimiter
+		start := d.off
+		for ; d.off < len(d.data); d.off++ {
+			switch d.data[d.off] {
+			case ',', '}', ']', ' ', '\t', '\n', '\r':
+				if d.off == start {
+					return d.unexpected("a value")
+				}
+				return nil
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,52 @@
+package financialinstruments
+
+import (
+	"context"
+	"sync"
+)
+
+//inFlight tracks the methods changing the graph that are running, so that Drain can wait for them.
+//It is shared by copies of the service.
+type inFlight struct {
+	sync.Mutex
+	draining   bool
+	operations sync.WaitGroup
+}
+
+//begin returns a ReadOnlyError or DrainingError for operation if it mustn't run,
+//otherwise it records operation as in flight until the returned end is called
+func (s service) begin(operation string) (end func(), err error) {
+	if s.readOnly {
+		return nil, ReadOnlyError{operation}
+	}
+
+	s.inFlight.Lock()
+	defer s.inFlight.Unlock()
+	if s.inFlight.draining {
+		return nil, DrainingError{operation}
+	}
+	s.inFlight.operations.Add(1)
+	return s.inFlight.operations.Done, nil
+}
+
+//Drain makes every method that would change the graph return a DrainingError from now on, then waits until those already running have finished,
+//so a deployment can be rolled without failing writes part way through. It returns the context's error if ctx is done before they finish.
+//Methods built on others, like DeleteBySource, may still fail part way through if Drain is called while they are running.
+func (s service) Drain(ctx context.Context) error {
+	s.inFlight.Lock()
+	s.inFlight.draining = true
+	s.inFlight.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		s.inFlight.operations.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,96 @@
+package financialinstruments
+
+import (
+	"github.com/Financial-Times/up-rw-app-api-go/rwapi"
+	"github.com/jmcvetta/neoism"
+	"net"
+	"strings"
+)
+
+//The errors returned by the service are classified so that direct callers can map them to responses:
+//  requestError      400, the request can never succeed as made
+//  ConflictError     409, the request clashes with another financial instrument
+//  ReadOnlyError     405, the service never changes the graph
+//  DrainingError     503, the service is shutting down
+//  UnavailableError  503, Neo4j couldn't be reached or failed transiently, so the request can be retried
+//Any other error is unexpected, and maps to 500.
+//baseftrwapp only maps requestError and rwapi.ConstraintOrTransactionError, so main.go returns the others it can map as those.
+
+//requestError is returned for requests that can never succeed as made; baseftrwapp maps it to a 400 using InvalidRequestDetails
+type requestError struct {
+	details string
+}
+
+func (re requestError) Error() string {
+	return "Invalid Request"
+}
+
+func (re requestError) InvalidRequestDetails() string {
+	return re.details
+}
+
+//ConflictError is returned when a write would clash with a different financial instrument
+type ConflictError struct {
+	Message string
+}
+
+func (ce ConflictError) Error() string {
+	return ce.Message
+}
+
+//ReadOnlyError is returned by every method that would change the graph when the service was created WithReadOnly
+type ReadOnlyError struct {
+	Operation string
+}
+
+func (roe ReadOnlyError) Error() string {
+	return "Cannot " + roe.Operation + " financial instruments, this service is read-only"
+}
+
+//DrainingError is returned by every method that would change the graph once Drain has been called
+type DrainingError struct {
+	Operation string
+}
+
+func (de DrainingError) Error() string {
+	return "Cannot " + de.Operation + " financial instruments, this service is draining"
+}
+
+//UnavailableError is returned when Neo4j couldn't be reached, or failed in a way that retrying may fix
+type UnavailableError struct {
+	Err error
+}
+
+func (ue UnavailableError) Error() string {
+	return "Neo4j is unavailable: " + ue.Err.Error()
+}
+
+//classify returns err, an error from running queries, as one of the errors handlers can tell apart if it is one of them
+func classify(err error) error {
+	switch err := err.(type) {
+	case nil:
+		return nil
+	case net.Error:
+		return UnavailableError{err}
+	case neoism.TxErrorList:
+		for _, txErr := range err {
+			if strings.HasPrefix(txErr.Code, "Neo.TransientError.") {
+				return UnavailableError{err}
+			}
+			if strings.HasPrefix(txErr.Code, "Neo.ClientError.Schema.Constraint") {
+				return ConflictError{txErr.Message}
+			}
+		}
+	case rwapi.ConstraintOrTransactionError:
+		// neoutils' runners return the errors of a failed transaction as this, with each error described in Details
+		for _, detail := range err.Details {
+			if strings.Contains(detail, "Neo.TransientError.") {
+				return UnavailableError{err}
+			}
+			if strings.Contains(detail, "Neo.ClientError.Schema.Constraint") || strings.Contains(detail, "already exists with label") {
+				return ConflictError{detail}
+			}
+		}
+	}
+	return err
+}
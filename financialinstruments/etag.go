@@ -0,0 +1,54 @@
+package financialinstruments
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmcvetta/neoism"
+)
+
+//ReadWithETag returns the financial instrument with the given uuid, as ReadTyped does, with an ETag for it made from the hash
+//stored when it was written, so an HTTP handler can answer conditional GETs. The ETag is empty if no hash was stored.
+//The financial instrument and its hash are read in one query, so the ETag always matches what is returned,
+//which is why this doesn't use the cache.
+func (s service) ReadWithETag(uuid string) (financialInstrument, string, bool, error) {
+	results := []financialInstrumentRow{}
+
+	query := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument {uuid:{uuid}})` + s.financialInstrumentProjection(),
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		Result: &results,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil || len(results) == 0 {
+		return financialInstrument{}, "", false, err
+	}
+
+	fi := results[0].decode()
+	if err := checkDecoded([]financialInstrument{fi}); err != nil {
+		return financialInstrument{}, "", false, err
+	}
+
+	etag := ""
+	if results[0].Hash != "" {
+		etag = fmt.Sprintf(`"%s"`, results[0].Hash)
+	}
+	return normalise(fi), etag, true, nil
+}
+
+//ETagMatches returns whether the value of an If-None-Match header matches etag, as returned by ReadWithETag,
+//so that the handler can respond 304 Not Modified instead of with the financial instrument
+func ETagMatches(ifNoneMatch string, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package financialinstruments
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jmcvetta/neoism"
+)
+
+const (
+	//streamBatchSize is how many financial instruments WriteStream writes in each batch
+	streamBatchSize = 256
+	//defaultCSVListSeparator joins the values of multi-valued fields in ExportCSV unless WithCSVListSeparator says otherwise
+	defaultCSVListSeparator = "|"
+)
+
+//WithCSVListSeparator sets the separator ExportCSV joins the values of multi-valued fields, e.g. uuids, with
+func WithCSVListSeparator(separator string) Option {
+	return func(s *service) {
+		s.csvListSeparator = separator
+	}
+}
+
+//ExportAll writes every financial instrument to w as newline delimited JSON, one per line in uuid order, as Read returns them.
+//If compressed is true the output is gzip compressed. Financial instruments are read a page at a time, so the export
+//is not a consistent snapshot if they are written to while it runs.
+func (s service) ExportAll(w io.Writer, compressed bool) (int, error) {
+	if compressed {
+		gz := gzip.NewWriter(w)
+		exported, err := s.exportAll(gz)
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+		return exported, err
+	}
+	return s.exportAll(w)
+}
+
+func (s service) exportAll(w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+	exported := 0
+	for skip := 0; ; skip += batchSize {
+		fis, err := s.readPage(`MATCH (fi:FinancialInstrument)`, nil, skip, batchSize)
+		if err != nil {
+			return exported, err
+		}
+		if len(fis) == 0 {
+			return exported, nil
+		}
+		for _, fi := range fis {
+			if err := enc.Encode(fi); err != nil {
+				return exported, err
+			}
+			exported++
+		}
+	}
+}
+
+//ExportCSV writes every financial instrument to w as CSV, with a header row of uuid and then fields, one of selectableFields each,
+//and a row for each financial instrument in uuid order. Values of multi-valued fields are joined with the separator set
+//WithCSVListSeparator, and fields without a value are left empty. Like ExportAll, it reads a page at a time,
+//so it is not a consistent snapshot, and it returns how many financial instruments were written.
+func (s service) ExportCSV(w io.Writer, fields []string) (int, error) {
+	selected, err := selectFields(fields)
+	if err != nil {
+		return 0, err
+	}
+
+	columns := append([]string{"uuid"}, fields...)
+	out := csv.NewWriter(w)
+	if err := out.Write(columns); err != nil {
+		return 0, err
+	}
+
+	statement := s.fieldsStatement(`MATCH (fi:FinancialInstrument)
+				WITH fi ORDER BY fi.uuid SKIP {skip} LIMIT {limit}`, selected) + `
+				ORDER BY uuid`
+
+	exported := 0
+	for skip := 0; ; skip += batchSize {
+		results := []map[string]interface{}{}
+		query := &neoism.CypherQuery{
+			Statement: statement,
+			Parameters: map[string]interface{}{
+				"skip":  skip,
+				"limit": batchSize,
+			},
+			Result: &results,
+		}
+
+		if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+			return exported, err
+		}
+		for _, result := range results {
+			record := make([]string, 0, len(columns))
+			for _, name := range columns {
+				record = append(record, s.csvValue(result[name]))
+			}
+			if err := out.Write(record); err != nil {
+				return exported, err
+			}
+			exported++
+		}
+		out.Flush()
+		if err := out.Error(); err != nil || len(results) < batchSize {
+			return exported, err
+		}
+	}
+}
+
+//csvValue formats a field value read from Neo4j for ExportCSV
+func (s service) csvValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			values = append(values, s.csvValue(v))
+		}
+		return strings.Join(values, s.csvListSeparator)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+//WriteStream writes the financial instruments read from r, newline delimited JSON as ExportAll writes it, gzip compressed
+//if compressed is true, and returns how many were written. They are decoded as they are read, and written streamBatchSize
+//at a time with WriteBatch, so the whole stream is never held in memory. It stops at the first one that can't be decoded or written,
+//unless the service was created WithDeadLetterSink, when those that fail validation or the write are given to the sink instead.
+func (s service) WriteStream(r io.Reader, transactionID string, compressed bool) (int, error) {
+	if compressed {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return 0, requestError{"Invalid gzip stream: " + err.Error()}
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	dec := json.NewDecoder(r)
+	written := 0
+	batch := make([]financialInstrument, 0, streamBatchSize)
+	for {
+		fi, err := decodeFinancialInstrument(dec)
+		if err != nil && err != io.EOF {
+			return written, requestError{"Invalid financial instrument in stream: " + err.Error()}
+		}
+		if err == nil {
+			batch = append(batch, fi)
+		}
+
+		if len(batch) == streamBatchSize || err == io.EOF && len(batch) > 0 {
+			batchWritten, err := s.writeStreamBatch(batch, transactionID)
+			written += batchWritten
+			if err != nil {
+				return written, err
+			}
+			batch = batch[:0]
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+	}
+}
+
+//writeStreamBatch writes a batch of WriteStream's financial instruments, returning how many were written. If there is a DeadLetterSink,
+//those that fail validation are given to it and the rest written together, or one at a time, as WriteEach does, if that fails.
+func (s service) writeStreamBatch(batch []financialInstrument, transactionID string) (int, error) {
+	if !s.deadLettering() {
+		if _, err := s.WriteBatch(batch, transactionID, WriteOptions{}); err != nil {
+			return 0, err
+		}
+		return len(batch), nil
+	}
+
+	valid := make([]financialInstrument, 0, len(batch))
+	for _, fi := range batch {
+		if err := s.validate(fi); err != nil {
+			s.deadLetterSink.DeadLetter(fi, err)
+			continue
+		}
+		valid = append(valid, fi)
+	}
+	if len(valid) == 0 {
+		return 0, nil
+	}
+
+	_, err := s.WriteBatch(valid, transactionID, WriteOptions{})
+	switch err.(type) {
+	case nil:
+		return len(valid), nil
+	case ReadOnlyError, DrainingError:
+		// Nothing can be written, so there is nothing to give the sink
+		return 0, err
+	}
+	return s.WriteEach(valid, transactionID, WriteOptions{}), nil
+}
@@ -0,0 +1,123 @@
+package financialinstruments
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmcvetta/neoism"
+)
+
+//selectableField is a field ReadFields can read, either a property of the node or, if match or identifierType is set,
+//an aggregate of what match, or an identifier of identifierType, binds to v
+type selectableField struct {
+	name           string
+	match          string
+	identifierType string
+	value          string
+}
+
+//selectableFields are the fields ReadFields can read, in the order they are projected
+var selectableFields = []selectableField{
+	{name: "prefLabel", value: "fi.prefLabel"},
+	{name: "aliases", value: "fi.aliases"},
+	{name: "currency", value: "fi.currency"},
+	{name: "micCode", value: "fi.micCode"},
+	{name: "sector", value: "fi.sector"},
+	{name: "countryOfRisk", value: "fi.countryOfRisk"},
+	{name: "isTest", value: "fi.isTest"},
+	{name: "source", value: "fi.source"},
+	{name: "trustLevel", value: "fi.trustLevel"},
+	{name: "issueDate", value: "fi.issueDate"},
+	{name: "status", value: "fi.status"},
+	{name: "primaryIdentifierType", value: "fi.primaryIdentifierType"},
+	{name: "issuedBy", match: "(fi)-[:ISSUED_BY]->(v:Thing)", value: "head(collect(DISTINCT v.uuid))"},
+	{name: "tags", match: "(fi)-[:TAGGED_WITH]->(v:Thing)", value: "collect(DISTINCT v.uuid)"},
+	{name: "uuids", identifierType: uppIdentifierLabel, value: "collect(DISTINCT v.value)"},
+	{name: "factsetIdentifier", identifierType: factsetIdentifierLabel, value: "head(collect(DISTINCT v.value))"},
+	{name: "figiCode", identifierType: figiIdentifierLabel, value: "head(collect(DISTINCT v.value))"},
+	{name: "wsodIdentifier", identifierType: wsodIdentifierLabel, value: "head(collect(DISTINCT v.value))"},
+	{name: "deprecatedIdentifiers", match: "(v)-[:IDENTIFIES]->(fi) WHERE v.deprecated = true", value: "collect(DISTINCT v.value)"},
+}
+
+//ReadFields returns only the given fields of the financial instrument with the given uuid, keyed by their JSON names, plus its uuid,
+//reading nothing else from Neo4j. The alternative identifiers are named as in alternativeIdentifiers, e.g. figiCode, but aren't nested.
+//Like Read, fields without a value are left out. It returns a requestError if any of fields isn't one of selectableFields.
+func (s service) ReadFields(uuid string, fields []string) (map[string]interface{}, bool, error) {
+	selected, err := selectFields(fields)
+	if err != nil {
+		return nil, false, err
+	}
+
+	results := []map[string]interface{}{}
+	query := &neoism.CypherQuery{
+		Statement: s.fieldsStatement(`MATCH (fi:FinancialInstrument {uuid:{uuid}})`, selected),
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		Result: &results,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return nil, false, err
+	}
+	if len(results) == 0 {
+		return nil, false, nil
+	}
+
+	fi := map[string]interface{}{}
+	for name, value := range results[0] {
+		if values, isList := value.([]interface{}); value == nil || isList && len(values) == 0 {
+			continue
+		}
+		fi[name] = value
+	}
+	return fi, true, nil
+}
+
+//selectFields returns the set of fields, or a requestError if any of them isn't one of selectableFields
+func selectFields(fields []string) (map[string]bool, error) {
+	selected := map[string]bool{}
+	for _, name := range fields {
+		selectable := false
+		for _, field := range selectableFields {
+			if field.name == name {
+				selectable = true
+				break
+			}
+		}
+		if !selectable {
+			return nil, requestError{fmt.Sprintf("Unknown field %q, cannot be read", name)}
+		}
+		selected[name] = true
+	}
+	return selected, nil
+}
+
+//fieldsStatement returns a statement that runs match, which must bind fi, and returns the uuid and the selected fields
+//of each financial instrument it matches
+func (s service) fieldsStatement(match string, selected map[string]bool) string {
+	statement := match
+	carried := []string{"fi"}
+	columns := []string{"fi.uuid as uuid"}
+	for _, field := range selectableFields {
+		if !selected[field.name] {
+			continue
+		}
+		match := field.match
+		if field.identifierType != "" {
+			match = fmt.Sprintf("(v:%s)-[:IDENTIFIES]->(fi)", s.label(field.identifierType))
+		}
+		if match == "" {
+			columns = append(columns, fmt.Sprintf("%s as %s", field.value, field.name))
+			continue
+		}
+		// Aggregating each relationship as it is matched keeps one row, rather than one per combination of the relationships
+		statement += fmt.Sprintf(`
+				OPTIONAL MATCH %s
+				WITH %s, %s as %s`, match, strings.Join(carried, ", "), field.value, field.name)
+		carried = append(carried, field.name)
+		columns = append(columns, field.name)
+	}
+	return statement + `
+				RETURN ` + strings.Join(columns, ", ")
+}
@@ -0,0 +1,121 @@
+package financialinstruments
+
+import (
+	"strings"
+
+	"github.com/jmcvetta/neoism"
+)
+
+//InstrumentFilter restricts the financial instruments CountFiltered counts and ReadFiltered reads to those matching every one of its non-empty fields.
+//Each field is a constraint added in match, so adding a field there makes it available to everything filtered.
+type InstrumentFilter struct {
+	// Type is one of typeLabels
+	Type string
+	// Currency is an ISO 4217 currency code
+	Currency string
+	// Status is one of statuses. Financial instruments without a status are active.
+	Status string
+	Source string
+	// IssuedBy is the uuid of the issuer
+	IssuedBy      string
+	Sector        string
+	CountryOfRisk string
+}
+
+//match returns a MATCH binding fi to the financial instruments that pass the filter, and the parameters it needs,
+//or a requestError if a constraint can never match
+func (f InstrumentFilter) match() (string, map[string]interface{}, error) {
+	conditions := []string{}
+	params := map[string]interface{}{}
+
+	if f.Type != "" {
+		if err := validateType(f.Type); err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, `fi:`+f.Type)
+	}
+	if f.Currency != "" {
+		if err := validateCurrency(f.Currency); err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, `fi.currency = {currency}`)
+		params["currency"] = f.Currency
+	}
+	if f.Status != "" {
+		if err := validateStatus(f.Status); err != nil {
+			return "", nil, err
+		}
+		if f.Status == statusActive {
+			conditions = append(conditions, `coalesce(fi.status, {status}) = {status}`)
+		} else {
+			conditions = append(conditions, `fi.status = {status}`)
+		}
+		params["status"] = f.Status
+	}
+	if f.Source != "" {
+		conditions = append(conditions, `fi.source = {source}`)
+		params["source"] = f.Source
+	}
+	if f.IssuedBy != "" {
+		if err := validateUUID(f.IssuedBy); err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, `(fi)-[:ISSUED_BY]->(:Thing {uuid:{issuedBy}})`)
+		params["issuedBy"] = f.IssuedBy
+	}
+	if f.Sector != "" {
+		conditions = append(conditions, `fi.sector = {sector}`)
+		params["sector"] = f.Sector
+	}
+	if f.CountryOfRisk != "" {
+		if err := validateCountry(f.CountryOfRisk); err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, `fi.countryOfRisk = {countryOfRisk}`)
+		params["countryOfRisk"] = f.CountryOfRisk
+	}
+
+	match := `MATCH (fi:FinancialInstrument)`
+	if len(conditions) > 0 {
+		match += `
+				WHERE ` + strings.Join(conditions, " AND ")
+	}
+	return match, params, nil
+}
+
+//ReadFiltered returns a page of the financial instruments that pass filter, ordered by uuid.
+//An empty filter reads a page of all the financial instruments.
+func (s service) ReadFiltered(filter InstrumentFilter, skip int, limit int) ([]financialInstrument, error) {
+	match, params, err := filter.match()
+	if err != nil {
+		return nil, err
+	}
+	return s.readPage(match, params, skip, limit)
+}
+
+//CountFiltered returns the number of financial instruments that pass filter, using a single query
+func (s service) CountFiltered(filter InstrumentFilter) (int, error) {
+	match, params, err := filter.match()
+	if err != nil {
+		return 0, err
+	}
+
+	results := []struct {
+		Count int `json:"count"`
+	}{}
+
+	query := &neoism.CypherQuery{
+		Statement: match + `
+				RETURN count(fi) as count`,
+		Parameters: params,
+		Result:     &results,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return 0, err
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Count, nil
+}
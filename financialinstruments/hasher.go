@@ -2,6 +2,7 @@ package financialinstruments
 
 import (
 	"encoding/hex"
+	"sort"
 
 	"github.com/spaolacci/murmur3"
 	"github.com/ugorji/go/codec"
@@ -13,6 +14,35 @@ var handle = func() codec.JsonHandle {
 	return h
 }()
 
+//hashOf returns the hash stored with fi, computed from fi as it can be read back, so that a hash recomputed from a read
+//matches the one written: relationship properties, which are only read WithRelationshipProperties, and LastModified,
+//which isn't read at all, are left out, and the lists are sorted, as they aren't read back in the order they were written,
+//without empty values, as empty tags and identifiers aren't written
+func hashOf(fi financialInstrument) (string, error) {
+	fi.RelationshipProperties = nil
+	fi.LastModified = nil
+	fi.Aliases = sorted(fi.Aliases)
+	fi.Tags = sorted(fi.Tags)
+	fi.DeprecatedIdentifiers = sorted(fi.DeprecatedIdentifiers)
+	fi.AlternativeIdentifiers.UUIDS = sorted(fi.AlternativeIdentifiers.UUIDS)
+	return writeHash(fi)
+}
+
+//sorted returns a sorted copy of the non-empty values, or nil if there are none, so an empty list hashes the same whether or not it is nil
+func sorted(values []string) []string {
+	sortedValues := []string{}
+	for _, value := range values {
+		if value != "" {
+			sortedValues = append(sortedValues, value)
+		}
+	}
+	if len(sortedValues) == 0 {
+		return nil
+	}
+	sort.Strings(sortedValues)
+	return sortedValues
+}
+
 func writeHash(thing interface{}) (string, error) {
 	h := murmur3.New128()
 	enc := codec.NewEncoder(h, &handle)
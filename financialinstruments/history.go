@@ -0,0 +1,199 @@
+package financialinstruments
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jmcvetta/neoism"
+)
+
+//IdentifierValidity is one value an identifier of a financial instrument has had, and when it had it
+type IdentifierValidity struct {
+	IdentifierType string `json:"identifierType"`
+	Value          string `json:"value"`
+	// ValidFrom is nil if the identifier was written before identifier history was kept
+	ValidFrom *time.Time `json:"validFrom,omitempty"`
+	// ValidTo is nil if the financial instrument still has the identifier
+	ValidTo *time.Time `json:"validTo,omitempty"`
+}
+
+//WithIdentifierHistory makes the service keep a history of the identifiers of each financial instrument.
+//Each Identifier node records when it was first written in a validFrom property, which is carried over when a write
+//recreates it with the same value. When a write or ReplaceIdentifiers removes an identifier, an IdentifierHistory node
+//with its identifierType, value, validFrom and validTo is linked to the financial instrument by a WAS_IDENTIFIER_OF
+//relationship. Times are stored as milliseconds since the epoch, as lastModified is.
+//Keeping the history costs three extra statements in each write's transaction.
+func WithIdentifierHistory() Option {
+	return func(s *service) {
+		s.identifierHistory = true
+	}
+}
+
+type storedIdentifier struct {
+	Labels    []string `json:"labels"`
+	Value     string   `json:"value"`
+	ValidFrom *int64   `json:"validFrom"`
+}
+
+//identifierType returns the type of the identifier with the given labels, or "" if none of them is the label of a type
+func (s service) identifierType(labels []string) string {
+	for _, identifierType := range identifierTypes {
+		for _, label := range labels {
+			if label == s.label(identifierType) {
+				return identifierType
+			}
+		}
+	}
+	return ""
+}
+
+//identifierHistoryQueries returns the queries to run, in the same transaction, before and after the queries that write fis,
+//to keep the history of their identifiers, if the service keeps it. The queries before snapshot the existing identifiers
+//as pending IdentifierHistory nodes, so that the history is taken from the identifiers the write replaces, even if another
+//write changed them since fis were read. The queries after carry the validFrom of each identifier that was kept over from
+//its snapshot, and then, if replace is true, archive the snapshots of the identifiers that were removed, deleting the rest.
+func (s service) identifierHistoryQueries(fis []financialInstrument, replace bool) ([]*neoism.CypherQuery, []*neoism.CypherQuery) {
+	if !s.identifierHistory || len(fis) == 0 {
+		return nil, nil
+	}
+
+	uuids := []string{}
+	for _, fi := range fis {
+		uuids = append(uuids, fi.UUID)
+	}
+	types := []map[string]interface{}{}
+	for _, identifierType := range identifierTypes {
+		types = append(types, map[string]interface{}{"identifierType": identifierType, "label": s.label(identifierType)})
+	}
+	now := lastModified(time.Now())
+
+	snapshotQuery := &neoism.CypherQuery{
+		Statement: `UNWIND {uuids} as uuid
+				MATCH (t:Thing {uuid:uuid})<-[:IDENTIFIES]-(i:Identifier)
+				WITH t, i, [type IN {types} WHERE type.label IN labels(i) | type.identifierType][0] as identifierType
+				WHERE identifierType IS NOT NULL
+				CREATE (t)<-[:WAS_IDENTIFIER_OF]-(:IdentifierHistory {pending: true, identifierType: identifierType,
+					value: i.value, validFrom: i.validFrom})`,
+		Parameters: map[string]interface{}{
+			"uuids": uuids,
+			"types": types,
+		},
+	}
+
+	validFromQuery := &neoism.CypherQuery{
+		Statement: `UNWIND {uuids} as uuid
+				MATCH (t:Thing {uuid:uuid})<-[:IDENTIFIES]-(i:Identifier)
+				WITH t, i, [type IN {types} WHERE type.label IN labels(i) | type.identifierType][0] as identifierType
+				WHERE identifierType IS NOT NULL
+				OPTIONAL MATCH (t)<-[:WAS_IDENTIFIER_OF]-(h:IdentifierHistory {pending: true, identifierType: identifierType, value: i.value})
+				WITH i, collect(h) as snapshots
+				SET i.validFrom = CASE WHEN size(snapshots) = 0 THEN {now} ELSE snapshots[0].validFrom END`,
+		Parameters: map[string]interface{}{
+			"uuids": uuids,
+			"types": types,
+			"now":   now,
+		},
+	}
+
+	archive := `DETACH DELETE h`
+	if replace {
+		archive = `FOREACH (x IN CASE WHEN kept THEN [1] ELSE [] END | DETACH DELETE h)
+				FOREACH (x IN CASE WHEN kept THEN [] ELSE [1] END | SET h.validTo = {now} REMOVE h.pending)`
+	}
+	archiveQuery := &neoism.CypherQuery{
+		Statement: `UNWIND {uuids} as uuid
+				MATCH (t:Thing {uuid:uuid})<-[:WAS_IDENTIFIER_OF]-(h:IdentifierHistory {pending: true})
+				WITH t, h, [type IN {types} WHERE type.identifierType = h.identifierType][0].label as label
+				OPTIONAL MATCH (t)<-[:IDENTIFIES]-(i:Identifier {value: h.value})
+				WHERE label IN labels(i)
+				WITH h, count(i) > 0 as kept
+				` + archive,
+		Parameters: map[string]interface{}{
+			"uuids": uuids,
+			"types": types,
+			"now":   now,
+		},
+	}
+
+	return []*neoism.CypherQuery{snapshotQuery}, []*neoism.CypherQuery{validFromQuery, archiveQuery}
+}
+
+//ReadIdentifierHistory returns the current and past identifiers of the financial instrument with the given uuid,
+//ordered by type and then by when they became valid. It only has past identifiers if the service keeps
+//identifier history (see WithIdentifierHistory), and is empty if there is no such financial instrument.
+func (s service) ReadIdentifierHistory(uuid string) ([]IdentifierValidity, error) {
+	current := []storedIdentifier{}
+	currentQuery := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument {uuid:{uuid}})<-[:IDENTIFIES]-(i:Identifier)
+				RETURN labels(i) as labels, i.value as value, i.validFrom as validFrom`,
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		Result: &current,
+	}
+
+	past := []struct {
+		IdentifierType string `json:"identifierType"`
+		Value          string `json:"value"`
+		ValidFrom      *int64 `json:"validFrom"`
+		ValidTo        *int64 `json:"validTo"`
+	}{}
+	pastQuery := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument {uuid:{uuid}})<-[:WAS_IDENTIFIER_OF]-(h:IdentifierHistory)
+				WHERE h.pending IS NULL
+				RETURN h.identifierType as identifierType, h.value as value, h.validFrom as validFrom, h.validTo as validTo`,
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		Result: &past,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{currentQuery, pastQuery}); err != nil {
+		return nil, err
+	}
+
+	history := []IdentifierValidity{}
+	for _, identifier := range current {
+		identifierType := s.identifierType(identifier.Labels)
+		if identifierType == "" {
+			return nil, fmt.Errorf("Identifier %s of financial instrument %s has none of the identifier labels", identifier.Value, uuid)
+		}
+		history = append(history, IdentifierValidity{
+			IdentifierType: identifierType,
+			Value:          identifier.Value,
+			ValidFrom:      millisecondsTime(identifier.ValidFrom),
+		})
+	}
+	for _, identifier := range past {
+		history = append(history, IdentifierValidity{
+			IdentifierType: identifier.IdentifierType,
+			Value:          identifier.Value,
+			ValidFrom:      millisecondsTime(identifier.ValidFrom),
+			ValidTo:        millisecondsTime(identifier.ValidTo),
+		})
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		if history[i].IdentifierType != history[j].IdentifierType {
+			return history[i].IdentifierType < history[j].IdentifierType
+		}
+		return validFromMilliseconds(history[i]) < validFromMilliseconds(history[j])
+	})
+	return history, nil
+}
+
+func millisecondsTime(milliseconds *int64) *time.Time {
+	if milliseconds == nil {
+		return nil
+	}
+	t := time.Unix(0, *milliseconds*int64(time.Millisecond))
+	return &t
+}
+
+func validFromMilliseconds(validity IdentifierValidity) int64 {
+	if validity.ValidFrom == nil {
+		return 0
+	}
+	return lastModified(*validity.ValidFrom)
+}
@@ -0,0 +1,90 @@
+package financialinstruments
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jmcvetta/neoism"
+)
+
+const defaultCountsCacheTTL = time.Minute
+
+//InstrumentCounts holds the figures published as the instruments_total and instruments_by_type gauges.
+//ByType is keyed by the financial instrument's type label, i.e. any label other than Thing, Concept and FinancialInstrument.
+type InstrumentCounts struct {
+	Total  int
+	ByType map[string]int
+}
+
+type countsCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	counts  InstrumentCounts
+	expires time.Time
+}
+
+//WithCountsCacheTTL sets how long InstrumentCounts reuses its last result before querying Neo4j again,
+//so frequent metrics scrapes don't each run the counting Cypher. A ttl <= 0 disables caching.
+func WithCountsCacheTTL(ttl time.Duration) Option {
+	return func(s *service) {
+		s.countsCache = &countsCache{ttl: ttl}
+	}
+}
+
+//InstrumentCounts returns the total and per type number of financial instruments, cached for the configured TTL
+func (s service) InstrumentCounts() (InstrumentCounts, error) {
+	cache := s.countsCache
+	if cache == nil || cache.ttl <= 0 {
+		return s.queryInstrumentCounts()
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	if time.Now().Before(cache.expires) {
+		return cache.counts, nil
+	}
+
+	counts, err := s.queryInstrumentCounts()
+	if err != nil {
+		return InstrumentCounts{}, err
+	}
+	cache.counts = counts
+	cache.expires = time.Now().Add(cache.ttl)
+	return counts, nil
+}
+
+func (s service) queryInstrumentCounts() (InstrumentCounts, error) {
+	totalResults := []struct {
+		Count int `json:"count"`
+	}{}
+	totalQuery := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument) return count(fi) as count`,
+		Result:    &totalResults,
+	}
+
+	typeResults := []struct {
+		Type  string `json:"type"`
+		Count int    `json:"count"`
+	}{}
+	byTypeQuery := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument)
+				UNWIND labels(fi) as type
+				WITH type WHERE NOT type IN ['Thing', 'Concept', 'FinancialInstrument']
+				return type, count(type) as count`,
+		Result: &typeResults,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{totalQuery, byTypeQuery}); err != nil {
+		return InstrumentCounts{}, err
+	}
+
+	counts := InstrumentCounts{ByType: map[string]int{}}
+	if len(totalResults) > 0 {
+		counts.Total = totalResults[0].Count
+	}
+	for _, result := range typeResults {
+		counts.ByType[result.Type] = result.Count
+	}
+	return counts, nil
+}
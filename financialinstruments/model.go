@@ -1,10 +1,42 @@
 package financialinstruments
 
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
 type financialInstrument struct {
 	UUID                   string                 `json:"uuid"`
 	PrefLabel              string                 `json:"prefLabel"`
+	Aliases                []string               `json:"aliases,omitempty"`
 	AlternativeIdentifiers alternativeIdentifiers `json:"alternativeIdentifiers"`
 	IssuedBy               string                 `json:"issuedBy,omitempty"`
+	Currency               string                 `json:"currency,omitempty"`
+	MICCode                string                 `json:"micCode,omitempty"`
+	Sector                 string                 `json:"sector,omitempty"`
+	CountryOfRisk          string                 `json:"countryOfRisk,omitempty"`
+	IsTest                 bool                   `json:"isTest,omitempty"`
+	Source                 string                 `json:"source,omitempty"`
+	IssueDate              string                 `json:"issueDate,omitempty"`
+	Tags                   []string               `json:"tags,omitempty"`
+	// TrustLevel is how authoritative the feed the financial instrument came from is, higher being more trusted.
+	// Writes with WriteOptions.OnlyIfHigherTrust don't overwrite a financial instrument stored with a higher trust level.
+	TrustLevel int `json:"trustLevel,omitempty"`
+	// PrimaryIdentifierType is the type, one of identifierTypes, of the alternative identifier the writer declares primary, if any
+	PrimaryIdentifierType string `json:"primaryIdentifierType,omitempty"`
+	// Status is the market status of the financial instrument, one of statuses. A financial instrument without one is active.
+	Status string `json:"status,omitempty"`
+	// DeprecatedIdentifiers are the values of those of the alternative identifiers that have been retired.
+	// They are kept for history, but can be left out when looking up a financial instrument by identifier.
+	DeprecatedIdentifiers []string `json:"deprecatedIdentifiers,omitempty"`
+	// LastModified is when the source last changed the financial instrument, if it says. When it doesn't, Write records
+	// the time of the write instead. It is only written, not read back.
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	// RelationshipProperties are the properties of the relationships of the financial instrument, keyed by relationship type.
+	// They are only read when the service is created WithRelationshipProperties. Write sets those of ISSUED_BY, if given,
+	// on the relationship to the issuer, and rejects any others.
+	RelationshipProperties map[string]map[string]interface{} `json:"relationshipProperties,omitempty"`
 }
 
 type alternativeIdentifiers struct {
@@ -14,9 +46,148 @@ type alternativeIdentifiers struct {
 	WSODIdentifier    string   `json:"wsodIdentifier"`
 }
 
+//issuer is the object form issuedBy can be given in, with properties for the ISSUED_BY relationship, e.g. the issuance role.
+//DecodeJSON reads them into RelationshipProperties.
+type issuer struct {
+	UUID       string                 `json:"uuid"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+//relationshipVariables maps the types of the relationships whose properties are read WithRelationshipProperties
+//to the variable financialInstrumentProjection binds each of them to
+var relationshipVariables = map[string]string{
+	"ISSUED_BY": "issuedBy",
+}
+
+//managedRelationships maps the types of the relationships the service writes to or from financial instruments
+//to a pattern matching one of them of fi. relationshipTypes is derived from it.
+var managedRelationships = map[string]string{
+	"ISSUED_BY":         "(fi)-[:ISSUED_BY]->(:Thing)",
+	"TAGGED_WITH":       "(fi)-[:TAGGED_WITH]->(:Thing)",
+	"IDENTIFIES":        "(fi)<-[:IDENTIFIES]-(:Identifier)",
+	"WAS_IDENTIFIER_OF": "(fi)<-[:WAS_IDENTIFIER_OF]-(:IdentifierHistory)",
+}
+
+//The market statuses a financial instrument can have
+const (
+	statusActive    = "active"
+	statusSuspended = "suspended"
+	statusDelisted  = "delisted"
+)
+
+var statuses = []string{statusActive, statusSuspended, statusDelisted}
+
+//issueDateLayout is the layout of IssueDate, which is stored as a string so that issue dates sort and compare as dates
+const issueDateLayout = "2006-01-02"
+
+//financialInstrumentRow is a row returned by financialInstrumentProjection. The alternative identifiers are returned as
+//top-level columns, as neoism can decode a nested map of them that is all nulls as nil
+type financialInstrumentRow struct {
+	financialInstrument
+	UUIDS             []string `json:"uuids"`
+	FactsetIdentifier string   `json:"factsetIdentifier"`
+	FIGICode          string   `json:"figiCode"`
+	WSODIdentifier    string   `json:"wsodIdentifier"`
+	Hash              string   `json:"hash"`
+}
+
+func (row financialInstrumentRow) decode() financialInstrument {
+	fi := row.financialInstrument
+	fi.AlternativeIdentifiers = alternativeIdentifiers{
+		UUIDS:             row.UUIDS,
+		FactsetIdentifier: row.FactsetIdentifier,
+		FIGICode:          row.FIGICode,
+		WSODIdentifier:    row.WSODIdentifier,
+	}
+	return fi
+}
+
 const (
 	uppIdentifierLabel     = "UPPIdentifier"
 	factsetIdentifierLabel = "FactsetIdentifier"
 	figiIdentifierLabel    = "FIGIIdentifier"
 	wsodIdentifierLabel    = "WSODIdentifier"
 )
+
+//identifierTypes are the identifier types this service writes, named by their default Neo4j label
+var identifierTypes = []string{
+	uppIdentifierLabel,
+	factsetIdentifierLabel,
+	figiIdentifierLabel,
+	wsodIdentifierLabel,
+}
+
+//singleValuedIdentifierTypes are the identifier types a financial instrument should have at most one of
+var singleValuedIdentifierTypes = []string{
+	factsetIdentifierLabel,
+	figiIdentifierLabel,
+	wsodIdentifierLabel,
+}
+
+//identifierValues returns the values of the alternative identifiers of fi of the given type (see identifierTypes)
+func identifierValues(fi financialInstrument, identifierType string) []string {
+	values := []string{}
+	switch identifierType {
+	case uppIdentifierLabel:
+		values = append(values, fi.AlternativeIdentifiers.UUIDS...)
+	case factsetIdentifierLabel:
+		values = append(values, fi.AlternativeIdentifiers.FactsetIdentifier)
+	case figiIdentifierLabel:
+		values = append(values, fi.AlternativeIdentifiers.FIGICode)
+	case wsodIdentifierLabel:
+		values = append(values, fi.AlternativeIdentifiers.WSODIdentifier)
+	}
+
+	nonEmpty := []string{}
+	for _, value := range values {
+		if value != "" {
+			nonEmpty = append(nonEmpty, value)
+		}
+	}
+	return nonEmpty
+}
+
+//tidy returns fi as it is written, with its IssuedBy trimmed and duplicates removed from its lists,
+//so that each identifier, alias and tag is only written once and the hash doesn't depend on repeats
+func tidy(fi financialInstrument) financialInstrument {
+	fi.IssuedBy = strings.TrimSpace(fi.IssuedBy)
+	fi.AlternativeIdentifiers.UUIDS = unique(fi.AlternativeIdentifiers.UUIDS)
+	fi.Aliases = unique(fi.Aliases)
+	fi.Tags = unique(fi.Tags)
+	fi.DeprecatedIdentifiers = unique(fi.DeprecatedIdentifiers)
+	return fi
+}
+
+//unique returns values without any repeats, keeping the first of each in order. A nil slice stays nil.
+func unique(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	uniqueValues := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			uniqueValues = append(uniqueValues, value)
+		}
+	}
+	return uniqueValues
+}
+
+//typeLabels are the labels that say what type of financial instrument a node is. Only FinancialInstrument is written so far,
+//but more specific types, e.g. for bonds, should be added here as they are written.
+var typeLabels = []string{
+	"FinancialInstrument",
+}
+
+//relationshipTypes are the types of managedRelationships, in alphabetical order
+var relationshipTypes = typesOf(managedRelationships)
+
+func typesOf(relationships map[string]string) []string {
+	types := make([]string, 0, len(relationships))
+	for relationshipType := range relationships {
+		types = append(types, relationshipType)
+	}
+	sort.Strings(types)
+	return types
+}
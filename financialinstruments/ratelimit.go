@@ -0,0 +1,72 @@
+package financialinstruments
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//WithQueryRateLimit limits the service to perSecond batches of queries a second, so that bulk loads don't overwhelm a Neo4j
+//shared with other services. Batches are allowed at a steady rate, with at most one second's worth in a burst after a quiet spell,
+//and a batch over the limit blocks until it is allowed. If ctx, e.g. one cancelled on shutdown, is done first it fails
+//with an UnavailableError instead. By default batches aren't limited.
+func WithQueryRateLimit(ctx context.Context, perSecond int) Option {
+	return func(s *service) {
+		if perSecond <= 0 {
+			s.rateLimiter = nil
+			return
+		}
+		s.rateLimiter = &rateLimiter{ctx: ctx, rate: float64(perSecond), burst: float64(perSecond), tokens: float64(perSecond), last: time.Now(),
+			now: time.Now, sleep: sleep}
+	}
+}
+
+//rateLimiter is a token bucket, holding up to burst tokens and refilled at rate tokens a second.
+//It is shared by copies of the service.
+type rateLimiter struct {
+	sync.Mutex
+	ctx    context.Context
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// now and sleep are time.Now and sleep, unless a test replaces them to control time
+	now   func() time.Time
+	sleep func(ctx context.Context, delay time.Duration) error
+}
+
+//wait blocks until a token can be taken, or returns an UnavailableError if the limiter's context is done first
+func (rl *rateLimiter) wait() error {
+	for {
+		rl.Lock()
+		now := rl.now()
+		rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+		if rl.tokens > rl.burst {
+			rl.tokens = rl.burst
+		}
+		rl.last = now
+		if rl.tokens >= 1 {
+			rl.tokens--
+			rl.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - rl.tokens) / rl.rate * float64(time.Second))
+		rl.Unlock()
+
+		if err := rl.sleep(rl.ctx, delay); err != nil {
+			return UnavailableError{err}
+		}
+	}
+}
+
+//sleep waits for delay, or returns the error of ctx if it is done first
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
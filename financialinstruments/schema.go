@@ -0,0 +1,92 @@
+package financialinstruments
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/jmcvetta/neoism"
+)
+
+var (
+	//constraintDescription matches the description Neo4j gives a uniqueness constraint, e.g. CONSTRAINT ON ( thing:Thing ) ASSERT thing.uuid IS UNIQUE
+	constraintDescription = regexp.MustCompile("^CONSTRAINT ON \\( *`?\\w+`?:`?(\\w+)`? *\\) ASSERT `?\\w+`?\\.`?(\\w+)`? IS UNIQUE$")
+	//indexDescription matches the description Neo4j gives an index on a single property, e.g. INDEX ON :Identifier(value)
+	indexDescription = regexp.MustCompile("^INDEX ON :`?(\\w+)`?\\(`?(\\w+)`?\\)$")
+)
+
+//SchemaDrift compares the constraints and indexes in Neo4j with those Initialise creates, returning a description of each one
+//that is missing, e.g. after a restore, and of each unexpected one on the FinancialInstrument label, which only this service writes.
+//Constraints and indexes on labels shared with other services, e.g. Thing, aren't reported as unexpected.
+//It only reads the schema, so calling Initialise is what fixes any missing ones.
+func (s service) SchemaDrift() ([]string, error) {
+	constraintResults := []struct {
+		Description string `json:"description"`
+	}{}
+	constraintsQuery := &neoism.CypherQuery{
+		Statement: `CALL db.constraints() YIELD description RETURN description`,
+		Result:    &constraintResults,
+	}
+
+	indexResults := []struct {
+		Description string `json:"description"`
+		Type        string `json:"type"`
+	}{}
+	indexesQuery := &neoism.CypherQuery{
+		Statement: `CALL db.indexes() YIELD description, type RETURN description, type`,
+		Result:    &indexResults,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{constraintsQuery, indexesQuery}); err != nil {
+		return nil, err
+	}
+
+	constraints := map[labelProperty]bool{}
+	for _, result := range constraintResults {
+		if match := constraintDescription.FindStringSubmatch(result.Description); match != nil {
+			constraints[labelProperty{match[1], match[2]}] = true
+		}
+	}
+	indexes := map[labelProperty]bool{}
+	for _, result := range indexResults {
+		// Each uniqueness constraint is backed by an index of its own, which is reported with the constraint
+		if result.Type == "node_unique_property" {
+			continue
+		}
+		if match := indexDescription.FindStringSubmatch(result.Description); match != nil {
+			indexes[labelProperty{match[1], match[2]}] = true
+		}
+	}
+
+	expectedIndexes := []labelProperty{identifierIndex}
+	for _, property := range indexedProperties {
+		expectedIndexes = append(expectedIndexes, labelProperty{"FinancialInstrument", property})
+	}
+
+	drift := []string{}
+	drift = append(drift, compareSchema("constraint", s.constraints(), constraints)...)
+	drift = append(drift, compareSchema("index", expectedIndexes, indexes)...)
+	return drift, nil
+}
+
+//compareSchema describes each of expected that isn't in live, in the order expected lists them,
+//followed by each on the FinancialInstrument label that is in live but not expected, sorted
+func compareSchema(kind string, expected []labelProperty, live map[labelProperty]bool) []string {
+	drift := []string{}
+	isExpected := map[labelProperty]bool{}
+	for _, lp := range expected {
+		isExpected[lp] = true
+		if !live[lp] {
+			drift = append(drift, fmt.Sprintf("missing %s on :%s(%s)", kind, lp.label, lp.property))
+		}
+	}
+
+	unexpected := []string{}
+	for lp := range live {
+		if lp.label == "FinancialInstrument" && !isExpected[lp] {
+			unexpected = append(unexpected, fmt.Sprintf("unexpected %s on :%s(%s)", kind, lp.label, lp.property))
+		}
+	}
+	sort.Strings(unexpected)
+	return append(drift, unexpected...)
+}
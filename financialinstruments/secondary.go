@@ -0,0 +1,37 @@
+package financialinstruments
+
+import (
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+	"github.com/jmcvetta/neoism"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//WithSecondary makes the service mirror every change it makes to the graph to secondary as well, e.g. to dual-run a new
+//Neo4j cluster during a migration and compare it with the old one. Mirroring is best-effort: a failure is logged
+//but doesn't fail the change, which has already been made to the primary. Everything is still read from the primary.
+func WithSecondary(secondary neoutils.CypherRunner) Option {
+	return func(s *service) {
+		s.secondary = secondary
+	}
+}
+
+//mirror runs queries, which have already been run against the primary, against the secondary if there is one.
+//They are copied first, so their results and stats from the primary are left alone.
+func (s service) mirror(queries []*neoism.CypherQuery) {
+	if s.secondary == nil || len(queries) == 0 {
+		return
+	}
+
+	mirrored := make([]*neoism.CypherQuery, 0, len(queries))
+	for _, query := range queries {
+		mirrored = append(mirrored, &neoism.CypherQuery{
+			Statement:  query.Statement,
+			Parameters: query.Parameters,
+		})
+	}
+
+	if err := s.secondary.CypherBatch(mirrored); err != nil {
+		log.WithError(err).Warn("Failed to mirror change to the secondary Neo4j")
+	}
+}
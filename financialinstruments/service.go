@@ -1,296 +1,3252 @@
 package financialinstruments
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/Financial-Times/neo-utils-go/neoutils"
 	"github.com/Financial-Times/up-rw-app-api-go/rwapi"
+	log "github.com/Sirupsen/logrus"
 	"github.com/jmcvetta/neoism"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 type service struct {
-	conn neoutils.NeoConnection
+	conn           neoutils.CypherRunner
+	indexManager   neoutils.IndexManager
+	checkTimeout   time.Duration
+	countsCache    *countsCache
+	auditSink      AuditSink
+	cache          Cache
+	deadLetterSink DeadLetterSink
+	changeHandler  ChangeHandler
+	readOnly       bool
+	countPageSize  int
+	legacyFIGIs    bool
+	secondary      neoutils.CypherRunner
+	// periodicCommitSize, if > 0, is how many identifiers WriteBatch commits at a time for batches of more financial instruments
+	periodicCommitSize int
+	maxIdentifiers     int
+	maxPrefLabelLength int
+	identifierHistory  bool
+	inFlight           *inFlight
+	rateLimiter        *rateLimiter
+	// csvListSeparator joins the values of multi-valued fields in ExportCSV
+	csvListSeparator string
+	// issuerLabel, if set, is a label the Thing an issuer identifies must have
+	issuerLabel            string
+	relationshipProperties bool
+	fastDecode             bool
+	// identifierLabels maps each of identifierTypes to the Neo4j label it is written with
+	identifierLabels map[string]string
 }
 
-const batchSize = 4096
+const (
+	batchSize            = 4096
+	defaultCheckTimeout  = 10 * time.Second
+	minAliasSearchLength = 3
+	minFIGIPrefixLength  = 4
+	maxSiblings          = 1000
+	maxRelatedDepth      = 3
+	maxRelated           = 1000
+	maxExistsBatch       = 1000
+	maxGroupedIssuers    = 100
+	maxGroupedByIssuer   = 10000
+	// defaultMaxIdentifiers is far more identifiers than any real financial instrument has
+	defaultMaxIdentifiers = 1000
+	// defaultMaxPrefLabelLength is far longer than any real financial instrument's name
+	defaultMaxPrefLabelLength = 1000
+)
 
-//NewCypherFinancialInstrumentService returns a new service responsible for writing financial instruments in Neo4j
-func NewCypherFinancialInstrumentService(cypherRunner neoutils.NeoConnection) service {
-	return service{cypherRunner}
-}
+//Option configures optional behaviour of the service returned by NewCypherFinancialInstrumentService
+type Option func(*service)
 
-func (s service) Initialise() error {
-	err := s.conn.EnsureIndexes(map[string]string{
-		"Identifier": "value",
-	})
+//WithCheckTimeout sets how long Check waits for Neo4j before failing, so a hung Neo4j doesn't hang the healthcheck and gtg endpoints.
+//A timeout <= 0 waits indefinitely.
+func WithCheckTimeout(timeout time.Duration) Option {
+	return func(s *service) {
+		s.checkTimeout = timeout
+	}
+}
 
-	if err != nil {
-		return err
+//WithIdentifierLabel writes identifiers of the given type, which is named by its default label (e.g. UPPIdentifier), with a different label,
+//so the graph can follow the same conventions as sibling services. Types other than identifierTypes are ignored.
+//The label is put into statements as is, so it panics unless label is letters, digits and underscores starting with a letter.
+func WithIdentifierLabel(identifierType string, label string) Option {
+	if !labelPattern.MatchString(label) {
+		panic(fmt.Sprintf("Invalid label %q for %s, must be letters, digits and underscores starting with a letter", label, identifierType))
+	}
+	return func(s *service) {
+		if _, ok := s.identifierLabels[identifierType]; ok {
+			s.identifierLabels[identifierType] = label
+		}
 	}
+}
 
-	return s.conn.EnsureConstraints(map[string]string{
-		"Thing":               "uuid",
-		"Concept":             "uuid",
-		"FinancialInstrument": "uuid",
-		"UPPIdentifier":       "value",
-		"FactsetIdentifier":   "value",
-		"FIGIIdentifier":      "value",
-	})
+//WithPagedCount makes Count and CountWithOptions count pageSize financial instruments per query and sum the pages,
+//for graphs so large that counting them in one query times out. A pageSize <= 0 counts in one query, which is the default.
+func WithPagedCount(pageSize int) Option {
+	return func(s *service) {
+		s.countPageSize = pageSize
+	}
 }
 
-func (s service) Read(uuid string, transactionID string) (interface{}, bool, error) {
+//WithLegacyFIGIs makes Write accept FIGIs that aren't in the standard format or have the wrong check digit,
+//for deployments that still have to store codes that predate the standard
+func WithLegacyFIGIs() Option {
+	return func(s *service) {
+		s.legacyFIGIs = true
+	}
+}
 
-	results := []financialInstrument{}
+//WithIssuerLabel requires the Thing the IssuedBy of a financial instrument identifies to also have label, e.g. Organisation,
+//so that financial instruments aren't linked to other Things that happen to share an identifier value.
+//A write whose issuer only identifies other Things is rejected, and issuers that don't exist yet are created with label.
+//label is quoted wherever it is put in a statement, so it is only ever matched or set as a label.
+func WithIssuerLabel(label string) Option {
+	return func(s *service) {
+		s.issuerLabel = label
+	}
+}
 
-	readQuery := &neoism.CypherQuery{
-		Statement: `MATCH (fi:FinancialInstrument {uuid:{uuid}})
-				OPTIONAL MATCH (fi)-[:ISSUED_BY]->(org:Thing)
-				OPTIONAL MATCH (upp:UPPIdentifier)-[:IDENTIFIES]->(fi)
-				OPTIONAL MATCH (factset:FactsetIdentifier)-[:IDENTIFIES]->(fi)
-				OPTIONAL MATCH (figi:FIGIIdentifier)-[:IDENTIFIES]->(fi)
-				OPTIONAL MATCH (wsod:WSODIdentifier)-[:IDENTIFIES]->(fi)
-				return fi.uuid as uuid,
-					fi.prefLabel as prefLabel,
-					org.uuid as issuedBy,
-					{uuids:collect(distinct upp.value),
-					figiCode:figi.value,
-					factsetIdentifier:factset.value,
-					wsodIdentifier: wsod.value} as alternativeIdentifiers`,
-		Parameters: map[string]interface{}{
-			"uuid": uuid,
-		},
-		Result: &results,
+//WithRelationshipProperties makes the methods reading financial instruments also return the properties of their relationships,
+//for those relationship types in relationshipVariables, e.g. so that a UI can display those of ISSUED_BY
+func WithRelationshipProperties() Option {
+	return func(s *service) {
+		s.relationshipProperties = true
 	}
+}
 
-	if err := s.conn.CypherBatch([]*neoism.CypherQuery{readQuery}); err != nil || len(results) == 0 {
-		return financialInstrument{}, false, err
+//WithMaxIdentifiers rejects financial instruments with more than n alternative identifiers in total, before any queries are run,
+//as each identifier is written by a query of its own. The default is defaultMaxIdentifiers, and n <= 0 means there is no limit.
+func WithMaxIdentifiers(n int) Option {
+	return func(s *service) {
+		s.maxIdentifiers = n
 	}
+}
 
-	return results[0], true, nil
+//WithMaxPrefLabelLength rejects financial instruments with a prefLabel longer than n characters, before any queries are run,
+//so a corrupt feed can't bloat their nodes. The default is defaultMaxPrefLabelLength, and n <= 0 means there is no limit.
+func WithMaxPrefLabelLength(n int) Option {
+	return func(s *service) {
+		s.maxPrefLabelLength = n
+	}
+}
 
+//validate checks fi can be written, as validate does, and also checks its FIGI unless the service accepts legacy FIGIs,
+//and that it doesn't have too many identifiers or too long a prefLabel
+func (s service) validate(fi financialInstrument) error {
+	if err := validate(fi); err != nil {
+		return err
+	}
+	if s.maxPrefLabelLength > 0 {
+		if length := utf8.RuneCountInString(fi.PrefLabel); length > s.maxPrefLabelLength {
+			return requestError{fmt.Sprintf("Financial instrument %s has a prefLabel of %d characters, but at most %d are allowed", fi.UUID, length, s.maxPrefLabelLength)}
+		}
+	}
+	if s.maxIdentifiers > 0 {
+		identifiers := 0
+		for _, identifierType := range identifierTypes {
+			identifiers += len(identifierValues(fi, identifierType))
+		}
+		if identifiers > s.maxIdentifiers {
+			return requestError{fmt.Sprintf("Financial instrument %s has %d identifiers, but at most %d are allowed", fi.UUID, identifiers, s.maxIdentifiers)}
+		}
+	}
+	if fi.AlternativeIdentifiers.FIGICode != "" && !s.legacyFIGIs {
+		return validateFIGI(fi.AlternativeIdentifiers.FIGICode)
+	}
+	return nil
 }
 
-func createNewIdentifierQuery(uuid string, identifierLabel string, identifierValue string) *neoism.CypherQuery {
-	statementTemplate := fmt.Sprintf(`MERGE (t:Thing {uuid:{uuid}})
-				CREATE (i:Identifier {value:{value}})
-				MERGE (t)<-[:IDENTIFIES]-(i)
-				set i : %s`, identifierLabel)
+//WithReadOnly makes every method that would change the graph return a ReadOnlyError without running any queries,
+//for deployments that read from a follower that must never be written to
+func WithReadOnly() Option {
+	return func(s *service) {
+		s.readOnly = true
+	}
+}
 
-	query := &neoism.CypherQuery{
-		Statement: statementTemplate,
-		Parameters: map[string]interface{}{
-			"uuid":  uuid,
-			"value": identifierValue,
-		},
+//NewCypherFinancialInstrumentService returns a new service responsible for writing financial instruments in Neo4j.
+//indexManager may be nil for read-only consumers that never call Initialise.
+func NewCypherFinancialInstrumentService(cypherRunner neoutils.CypherRunner, indexManager neoutils.IndexManager, opts ...Option) service {
+	s := service{
+		conn:               cypherRunner,
+		indexManager:       indexManager,
+		checkTimeout:       defaultCheckTimeout,
+		countsCache:        &countsCache{ttl: defaultCountsCacheTTL},
+		deadLetterSink:     noopDeadLetterSink{},
+		changeHandler:      noopChangeHandler,
+		inFlight:           &inFlight{},
+		maxIdentifiers:     defaultMaxIdentifiers,
+		maxPrefLabelLength: defaultMaxPrefLabelLength,
+		csvListSeparator:   defaultCSVListSeparator,
+		identifierLabels:   map[string]string{},
 	}
-	return query
+	for _, identifierType := range identifierTypes {
+		s.identifierLabels[identifierType] = identifierType
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return s
 }
 
-func getNewIdentifierQueries(fi financialInstrument) []*neoism.CypherQuery {
-	queries := []*neoism.CypherQuery{}
+//Initialise creates the constraints and then the indexes the service relies on, one at a time and always in the same order.
+//On a fresh database creating the Identifier value index can conflict with the identifier constraints being created,
+//so that is retried once after initialiseRetryDelay. If the service was created WithReadOnly it creates nothing and returns a ReadOnlyError.
+func (s service) Initialise() error {
+	if s.readOnly {
+		return ReadOnlyError{"create the constraints and indexes of"}
+	}
+	if s.indexManager == nil {
+		return errors.New("Cannot initialise financial instruments service: no index manager configured")
+	}
 
-	//ADD all the IDENTIFIER nodes and IDENTIFIES relationships
-	for _, alternativeUUID := range fi.AlternativeIdentifiers.UUIDS {
-		if alternativeUUID != "" {
-			queries = append(queries, createNewIdentifierQuery(fi.UUID, uppIdentifierLabel, alternativeUUID))
+	for _, constraint := range s.constraints() {
+		if err := s.indexManager.EnsureConstraints(map[string]string{constraint.label: constraint.property}); err != nil {
+			return err
 		}
 	}
 
-	if fi.AlternativeIdentifiers.FactsetIdentifier != "" {
-		queries = append(queries, createNewIdentifierQuery(fi.UUID, factsetIdentifierLabel, fi.AlternativeIdentifiers.FactsetIdentifier))
+	index := map[string]string{identifierIndex.label: identifierIndex.property}
+	if err := s.indexManager.EnsureIndexes(index); err != nil {
+		log.WithError(err).Warn("Failed to create the Identifier value index, retrying")
+		time.Sleep(initialiseRetryDelay)
+		if err := s.indexManager.EnsureIndexes(index); err != nil {
+			return err
+		}
 	}
 
-	if fi.AlternativeIdentifiers.FIGICode != "" {
-		queries = append(queries, createNewIdentifierQuery(fi.UUID, figiIdentifierLabel, fi.AlternativeIdentifiers.FIGICode))
+	// EnsureIndexes takes one property per label, so each FinancialInstrument property is indexed separately
+	for _, property := range indexedProperties {
+		if err := s.indexManager.EnsureIndexes(map[string]string{"FinancialInstrument": property}); err != nil {
+			return err
+		}
 	}
 
-	if fi.AlternativeIdentifiers.WSODIdentifier != "" {
-		queries = append(queries, createNewIdentifierQuery(fi.UUID, wsodIdentifierLabel, fi.AlternativeIdentifiers.WSODIdentifier))
+	return nil
+}
+
+//initialiseRetryDelay is how long Initialise waits before retrying the Identifier value index
+var initialiseRetryDelay = time.Second
+
+type labelProperty struct {
+	label    string
+	property string
+}
+
+//constraints are the uniqueness constraints Initialise creates, in the order it creates them
+func (s service) constraints() []labelProperty {
+	return []labelProperty{
+		{"Thing", "uuid"},
+		{"Concept", "uuid"},
+		{"FinancialInstrument", "uuid"},
+		{s.label(uppIdentifierLabel), "value"},
+		{s.label(factsetIdentifierLabel), "value"},
+		{s.label(figiIdentifierLabel), "value"},
 	}
+}
 
-	return queries
+//identifierIndex is the index Initialise creates on the values of identifiers of every type
+var identifierIndex = labelProperty{"Identifier", "value"}
+
+//indexedProperties are the properties of financial instruments that are looked up by value or range,
+//indexed so that doing so doesn't scan every FinancialInstrument node
+var indexedProperties = []string{
+	"prefLabel",
+	"source",
+	"currency",
+	"lastModified",
+	"issueDate",
+	"status",
+	"micCode",
+	"sector",
+	"countryOfRisk",
 }
 
-func (s service) Write(thing interface{}, transactionID string) error {
+//cypherBatch runs queries in a single transaction, skipping the call altogether if there are none,
+//as some CypherRunner implementations fail when given an empty batch. Errors are classified as classify does.
+//It waits first if the service was created WithQueryRateLimit and the limit has been reached.
+func (s service) cypherBatch(queries []*neoism.CypherQuery) error {
+	if len(queries) == 0 {
+		return nil
+	}
+	if s.rateLimiter != nil {
+		if err := s.rateLimiter.wait(); err != nil {
+			return err
+		}
+	}
+	return classify(s.conn.CypherBatch(queries))
+}
 
-	hash, err := writeHash(thing)
-	if err != nil {
-		return err
+//label returns the Neo4j label identifiers of the given type are written with
+func (s service) label(identifierType string) string {
+	if label, ok := s.identifierLabels[identifierType]; ok {
+		return label
 	}
+	return identifierType
+}
 
-	fi := thing.(financialInstrument)
+//quoteLabel returns label quoted for Cypher, so that a configured label can't change the statement it is put in
+func quoteLabel(label string) string {
+	return "`" + strings.Replace(label, "`", "``", -1) + "`"
+}
 
-	params := map[string]interface{}{
-		"uuid": fi.UUID,
-		"hash": hash,
+//financialInstrumentProjection follows a MATCH that binds fi, returning one row per financial instrument that decodes into a financialInstrument
+func (s service) financialInstrumentProjection() string {
+	relationshipProperties := ""
+	if s.relationshipProperties {
+		types := make([]string, 0, len(relationshipVariables))
+		for relationshipType := range relationshipVariables {
+			types = append(types, relationshipType)
+		}
+		sort.Strings(types)
+		entries := make([]string, 0, len(types))
+		for _, relationshipType := range types {
+			entries = append(entries, fmt.Sprintf("%s: properties(%s)", relationshipType, relationshipVariables[relationshipType]))
+		}
+		relationshipProperties = fmt.Sprintf(",\n\t\t\t\t\t{%s} as relationshipProperties", strings.Join(entries, ", "))
 	}
 
-	if fi.PrefLabel != "" {
-		params["prefLabel"] = fi.PrefLabel
+	return fmt.Sprintf(`
+				OPTIONAL MATCH (fi)-[issuedBy:ISSUED_BY]->(org:Thing)
+				OPTIONAL MATCH (upp:%s)-[:IDENTIFIES]->(fi)
+				OPTIONAL MATCH (factset:%s)-[:IDENTIFIES]->(fi)
+				OPTIONAL MATCH (figi:%s)-[:IDENTIFIES]->(fi)
+				OPTIONAL MATCH (wsod:%s)-[:IDENTIFIES]->(fi)
+				OPTIONAL MATCH (fi)-[:TAGGED_WITH]->(topic:Thing)
+				OPTIONAL MATCH (deprecated)-[:IDENTIFIES]->(fi) WHERE deprecated.deprecated = true
+				return fi.uuid as uuid,
+					fi.hash as hash,
+					fi.prefLabel as prefLabel,
+					fi.aliases as aliases,
+					fi.currency as currency,
+					fi.micCode as micCode,
+					fi.sector as sector,
+					fi.countryOfRisk as countryOfRisk,
+					fi.isTest as isTest,
+					fi.source as source,
+					fi.trustLevel as trustLevel,
+					fi.issueDate as issueDate,
+					fi.status as status,
+					fi.primaryIdentifierType as primaryIdentifierType,
+					org.uuid as issuedBy,
+					collect(distinct topic.uuid) as tags,
+					collect(distinct upp.value) as uuids,
+					figi.value as figiCode,
+					factset.value as factsetIdentifier,
+					wsod.value as wsodIdentifier,
+					collect(distinct deprecated.value) as deprecatedIdentifiers%s`,
+		s.label(uppIdentifierLabel), s.label(factsetIdentifierLabel), s.label(figiIdentifierLabel), s.label(wsodIdentifierLabel), relationshipProperties)
+}
+
+//normalise makes empty list fields nil, so they are omitted the same way whether the graph held nothing or an empty collection
+func normalise(fi financialInstrument) financialInstrument {
+	if len(fi.Tags) == 0 {
+		fi.Tags = nil
+	}
+	if len(fi.Aliases) == 0 {
+		fi.Aliases = nil
+	}
+	if len(fi.DeprecatedIdentifiers) == 0 {
+		fi.DeprecatedIdentifiers = nil
+	}
+	// A relationship the financial instrument doesn't have has null properties
+	for relationshipType, properties := range fi.RelationshipProperties {
+		if properties == nil {
+			delete(fi.RelationshipProperties, relationshipType)
+		}
+	}
+	if len(fi.RelationshipProperties) == 0 {
+		fi.RelationshipProperties = nil
 	}
+	return fi
+}
 
-	queries := []*neoism.CypherQuery{}
+func (s service) Read(uuid string, transactionID string) (interface{}, bool, error) {
+	return s.ReadTyped(uuid)
+}
 
-	deleteEntityRelationshipsQuery := &neoism.CypherQuery{
-		Statement: `MATCH (t:Thing {uuid:{uuid}})
-				OPTIONAL MATCH (t)-[is:ISSUED_BY]->(org:Thing)
-				OPTIONAL MATCH (i:Identifier)-[ir:IDENTIFIES]->(t)
-				DELETE ir, is, i`,
-		Parameters: map[string]interface{}{
-			"uuid": fi.UUID,
-		},
+//ReadTyped returns the financial instrument with the given uuid, as Read does but without needing a type assertion
+func (s service) ReadTyped(uuid string) (financialInstrument, bool, error) {
+	if s.cache != nil {
+		// A Cache shared with other code could hold something else under uuid, which is read from Neo4j instead
+		if cached, found := s.cache.Get(uuid); found {
+			if fi, ok := cached.(financialInstrument); ok {
+				return fi, true, nil
+			}
+		}
 	}
-	queries = append(queries, deleteEntityRelationshipsQuery)
 
-	writeQuery := &neoism.CypherQuery{
-		Statement: `MERGE (t:Thing{uuid: {uuid}})
-			set t={props}
-			set t :Concept
-			set t :FinancialInstrument`,
+	fi, found, err := s.readStored(uuid)
+	if err != nil || !found {
+		return fi, found, err
+	}
+	if s.cache != nil {
+		s.cache.Set(uuid, fi)
+	}
+	return fi, true, nil
+}
+
+//readStored returns the financial instrument with the given uuid as stored in Neo4j, bypassing the cache,
+//for changes that need its latest version
+func (s service) readStored(uuid string) (financialInstrument, bool, error) {
+	results := []financialInstrumentRow{}
+
+	readQuery := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument {uuid:{uuid}})` + s.financialInstrumentProjection(),
 		Parameters: map[string]interface{}{
-			"uuid":  fi.UUID,
-			"props": params,
+			"uuid": uuid,
 		},
+		Result: &results,
+	}
+	if s.fastDecode {
+		readQuery.Result = (*fastRows)(&results)
 	}
-	queries = append(queries, writeQuery)
-	queries = append(queries, getNewIdentifierQueries(fi)...)
 
-	if fi.IssuedBy != "" {
-		orgUUID := fi.IssuedBy
+	if err := s.cypherBatch([]*neoism.CypherQuery{readQuery}); err != nil {
+		return financialInstrument{}, false, err
+	}
 
-		orgResults := []struct {
-			UUID string `json:"uuid"`
-		}{}
+	var fis []financialInstrument
+	for _, result := range results {
+		fis = append(fis, result.decode())
+	}
 
-		findOrganisationQuery := &neoism.CypherQuery{
-			Statement: `MATCH (i:Identifier {value: {uuid}})-[:IDENTIFIES]->(org:Thing) RETURN org.uuid as uuid`,
-			Parameters: map[string]interface{}{
-				"uuid": fi.IssuedBy,
-			},
-			Result: &orgResults,
-		}
+	if len(fis) == 0 {
+		return financialInstrument{}, false, nil
+	}
+	if err := checkDecoded(fis); err != nil {
+		return financialInstrument{}, false, err
+	}
 
-		if err := s.conn.CypherBatch([]*neoism.CypherQuery{findOrganisationQuery}); err != nil {
-			fmt.Println(err)
-			return err
-		}
+	return normalise(fis[0]), true, nil
+}
 
-		if len(orgResults) > 0 {
-			orgUUID = orgResults[0].UUID
-		}
+//ExistsMany returns whether there is a financial instrument with each of uuids, keyed by uuid, using a single query.
+//At most maxExistsBatch uuids can be checked at once.
+func (s service) ExistsMany(uuids []string) (map[string]bool, error) {
+	if len(uuids) > maxExistsBatch {
+		return nil, requestError{fmt.Sprintf("Cannot check more than %d uuids at once, got %d", maxExistsBatch, len(uuids))}
+	}
 
-		organizationRelationshipQuery := &neoism.CypherQuery{
-			Statement: `MERGE (fi:Thing {uuid: {uuid}})
-					MERGE (orgUpp:Identifier:UPPIdentifier{value:{orgUuid}})
-					MERGE (orgUpp)-[:IDENTIFIES]->(o:Thing) ON CREATE SET o.uuid = {orgUuid}
-					MERGE (fi)-[:ISSUED_BY]->(o)`,
-			Parameters: map[string]interface{}{
-				"uuid":    fi.UUID,
-				"orgUuid": orgUUID,
-			},
+	exists := map[string]bool{}
+	for _, uuid := range uuids {
+		if err := validateUUID(uuid); err != nil {
+			return nil, err
 		}
-		queries = append(queries, organizationRelationshipQuery)
+		exists[uuid] = false
+	}
+	if len(uuids) == 0 {
+		return exists, nil
 	}
 
-	return s.conn.CypherBatch(queries)
-}
+	results := []struct {
+		UUID string `json:"uuid"`
+	}{}
 
-func (s service) Delete(uuid string, transactionID string) (bool, error) {
-	clearNode := &neoism.CypherQuery{
-		Statement: `MATCH (t:Thing {uuid: {uuid}})
-				OPTIONAL MATCH (t)-[is:ISSUED_BY]->(org:Thing)
-				OPTIONAL MATCH (t)<-[ir:IDENTIFIES]-(i:Identifier)
-				REMOVE t:Concept:FinancialInstrument
-				DELETE is, ir, i
-				SET t={props}`,
+	query := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument)
+				WHERE fi.uuid IN {uuids}
+				RETURN fi.uuid as uuid`,
 		Parameters: map[string]interface{}{
-			"uuid": uuid,
-			"props": map[string]interface{}{
-				"uuid": uuid,
-			},
+			"uuids": uuids,
 		},
-		IncludeStats: true,
+		Result: &results,
 	}
 
-	removeNodeIfUnused := &neoism.CypherQuery{
-		Statement: `MATCH (t:Thing {uuid: {uuid}})
-				OPTIONAL MATCH (t)-[a]-(x)
-				WITH t, count(a) AS relCount
-				WHERE relCount = 0
-				DELETE t`,
-		Parameters: map[string]interface{}{
-			"uuid": uuid,
-		},
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return nil, err
 	}
 
-	err := s.conn.CypherBatch([]*neoism.CypherQuery{clearNode, removeNodeIfUnused})
+	for _, result := range results {
+		exists[result.UUID] = true
+	}
+	return exists, nil
+}
 
-	stats, err := clearNode.Stats()
-	if err != nil {
-		return false, err
+//ReadManyOrdered returns the financial instruments with each of uuids, using a single query, in the same order as uuids,
+//with nil in place of those that don't exist. At most maxExistsBatch uuids can be read at once.
+func (s service) ReadManyOrdered(uuids []string) ([]*financialInstrument, error) {
+	if len(uuids) > maxExistsBatch {
+		return nil, requestError{fmt.Sprintf("Cannot read more than %d uuids at once, got %d", maxExistsBatch, len(uuids))}
+	}
+	for _, uuid := range uuids {
+		if err := validateUUID(uuid); err != nil {
+			return nil, err
+		}
+	}
+
+	ordered := make([]*financialInstrument, len(uuids))
+	if len(uuids) == 0 {
+		return ordered, nil
 	}
 
-	var deleted bool
-	if stats.ContainsUpdates && stats.LabelsRemoved > 0 {
-		deleted = true
+	fis, err := s.readPage(`MATCH (fi:FinancialInstrument)
+				WHERE fi.uuid IN {uuids}`,
+		map[string]interface{}{"uuids": uuids}, 0, len(uuids))
+	if err != nil {
+		return nil, err
 	}
 
-	return deleted, err
+	byUUID := map[string]financialInstrument{}
+	for _, fi := range fis {
+		byUUID[fi.UUID] = fi
+	}
+	for i, uuid := range uuids {
+		if fi, found := byUUID[uuid]; found {
+			ordered[i] = &fi
+		}
+	}
+	return ordered, nil
 }
 
-func (s service) Count() (int, error) {
+//ReadHash returns the hash stored when the financial instrument was last written,
+//so a consumer can cheaply check whether it has changed before reading all of it
+func (s service) ReadHash(uuid string) (string, bool, error) {
 	results := []struct {
-		Count int `json:"count"`
+		Hash string `json:"hash"`
 	}{}
 
 	query := &neoism.CypherQuery{
-		Statement: `MATCH (fi:FinancialInstrument) return count(fi) as count`,
-		Result:    &results,
+		Statement: `MATCH (fi:FinancialInstrument {uuid:{uuid}})
+				RETURN fi.hash as hash`,
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		Result: &results,
 	}
-	err := s.conn.CypherBatch([]*neoism.CypherQuery{query})
 
-	if err != nil {
-		return 0, err
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil || len(results) == 0 {
+		return "", false, err
 	}
 
-	return results[0].Count, nil
+	return results[0].Hash, true, nil
 }
 
-func (s service) DecodeJSON(dec *json.Decoder) (interface{}, string, error) {
-	fi := financialInstrument{}
-	err := dec.Decode(&fi)
-	return fi, fi.UUID, err
+//SearchByAlias returns up to limit financial instruments with an alias containing substring.
+//This has to check the aliases of every financial instrument, so substring must be at least minAliasSearchLength characters
+//to keep the number of matches, and so the cost of projecting them, down.
+func (s service) SearchByAlias(substring string, limit int) ([]financialInstrument, error) {
+	if len(substring) < minAliasSearchLength {
+		return nil, requestError{fmt.Sprintf("Alias search term must be at least %d characters", minAliasSearchLength)}
+	}
+
+	return s.readPage(`MATCH (fi:FinancialInstrument)
+				WHERE any(alias IN fi.aliases WHERE alias CONTAINS {substring})`,
+		map[string]interface{}{"substring": substring}, 0, limit)
 }
 
-func (s service) IDs(f func(id rwapi.IDEntry) (bool, error)) error {
+//sortFields are the properties ReadSorted can order financial instruments by, each of which has a constraint or an index
+var sortFields = map[string]bool{
+	"uuid":         true,
+	"prefLabel":    true,
+	"lastModified": true,
+}
 
-	for skip := 0; ; skip += batchSize {
-		results := []rwapi.IDEntry{}
-		readQuery := &neoism.CypherQuery{
-			Statement: `MATCH (fi:FinancialInstrument) RETURN fi.uuid as id, fi.hash as hash SKIP {skip} LIMIT {limit}`,
-			Parameters: map[string]interface{}{
-				"limit": batchSize,
-				"skip":  skip,
-			},
-			Result: &results,
-		}
+//ReadSorted returns a page of all the financial instruments ordered by sortField, one of sortFields, then by uuid.
+//Financial instruments without sortField come last in ascending order and first in descending order.
+func (s service) ReadSorted(sortField string, descending bool, skip int, limit int) ([]financialInstrument, error) {
+	if !sortFields[sortField] {
+		return nil, requestError{fmt.Sprintf("Cannot sort financial instruments by %q", sortField)}
+	}
+	return s.readSortedPage(`MATCH (fi:FinancialInstrument)`, nil, sortField, descending, skip, limit)
+}
 
-		if err := s.conn.CypherBatch([]*neoism.CypherQuery{readQuery}); err != nil {
-			return nil
-		}
-		if len(results) == 0 {
-			return nil
-		}
-		for _, result := range results {
-			more, err := f(result)
-			if !more || err != nil {
-				return err
-			}
+//readPage runs match, which must bind fi, and returns the page of matching financial instruments in uuid order
+func (s service) readPage(match string, params map[string]interface{}, skip int, limit int) ([]financialInstrument, error) {
+	return s.readSortedPage(match, params, "uuid", false, skip, limit)
+}
+
+//readSortedPage runs match, which must bind fi, and returns the page of matching financial instruments ordered by
+//the sortField property, then by uuid. sortField must not come from the caller unchecked, as it is part of the statement.
+func (s service) readSortedPage(match string, params map[string]interface{}, sortField string, descending bool, skip int, limit int) ([]financialInstrument, error) {
+	if err := validatePage(skip, limit); err != nil {
+		return nil, err
+	}
+
+	query, results := s.pageQuery(match, params, sortField, descending, skip, limit)
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return nil, err
+	}
+	return decodePage(*results)
+}
+
+//ReadAfter returns up to limit financial instruments with uuids after lastUUID, in uuid order, so that all of them can be read
+//a page at a time by passing the uuid of the last one read, starting from "". Unlike skipping, this uses the uuid constraint's index
+//to start each page, so later pages are as cheap as the first, and financial instruments written or deleted meanwhile don't shift the pages.
+func (s service) ReadAfter(lastUUID string, limit int) ([]financialInstrument, error) {
+	return s.readPage(`MATCH (fi:FinancialInstrument)
+				WHERE fi.uuid > {lastUUID}`, map[string]interface{}{"lastUUID": lastUUID}, 0, limit)
+}
+
+//ReadPageWithTotal returns a page of all the financial instruments in uuid order, with the total number of financial instruments.
+//Both are read in the same transaction, but total is a best-effort snapshot: under concurrent writes it may already be out of date,
+//and it isn't guaranteed to agree with the page with Neo4j's default isolation level.
+func (s service) ReadPageWithTotal(skip int, limit int) ([]financialInstrument, int, error) {
+	if err := validatePage(skip, limit); err != nil {
+		return nil, 0, err
+	}
+
+	query, results := s.pageQuery(`MATCH (fi:FinancialInstrument)`, nil, "uuid", false, skip, limit)
+
+	totals := []struct {
+		Total int `json:"total"`
+	}{}
+	totalQuery := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument) RETURN count(fi) as total`,
+		Result:    &totals,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{query, totalQuery}); err != nil {
+		return nil, 0, err
+	}
+	if len(totals) == 0 {
+		return nil, 0, errors.New("No total returned counting financial instruments")
+	}
+
+	fis, err := decodePage(*results)
+	if err != nil {
+		return nil, 0, err
+	}
+	return fis, totals[0].Total, nil
+}
+
+//pageQuery builds the query readSortedPage runs, returning it with the rows it will be decoded into
+func (s service) pageQuery(match string, params map[string]interface{}, sortField string, descending bool, skip int, limit int) (*neoism.CypherQuery, *[]financialInstrumentRow) {
+	order := "fi.uuid"
+	reorder := `
+				ORDER BY uuid`
+	if sortField != "uuid" || descending {
+		direction := ""
+		if descending {
+			direction = " DESC"
 		}
+		order = fmt.Sprintf("fi.%s%s, fi.uuid", sortField, direction)
+		// the projection groups by its columns, so the sort property has to be one of them to order the page by it again
+		reorder = fmt.Sprintf(`,
+					fi.%s as sortKey
+				ORDER BY sortKey%s, uuid`, sortField, direction)
+	}
+
+	parameters := map[string]interface{}{
+		"skip":  skip,
+		"limit": limit,
+	}
+	for name, value := range params {
+		parameters[name] = value
+	}
 
+	results := []financialInstrumentRow{}
+
+	query := &neoism.CypherQuery{
+		Statement: match + `
+				WITH fi ORDER BY ` + order + ` SKIP {skip} LIMIT {limit}` + s.financialInstrumentProjection() + reorder,
+		Parameters: parameters,
+		Result:     &results,
 	}
+	return query, &results
 }
 
-func (s service) Check() error {
-	return neoutils.Check(s.conn)
+//decodePage decodes the rows of a page of financial instruments, checking each was decoded with its uuid
+func decodePage(results []financialInstrumentRow) ([]financialInstrument, error) {
+	fis := make([]financialInstrument, 0, len(results))
+	for _, result := range results {
+		fis = append(fis, normalise(result.decode()))
+	}
+	if err := checkDecoded(fis); err != nil {
+		return nil, err
+	}
+	return fis, nil
+}
+
+//checkDecoded returns an error if any of fis, decoded from matched nodes, has no uuid. Every node has one, so the uuid column
+//must be missing from the results, and the other fields may have silently been left empty too.
+func checkDecoded(fis []financialInstrument) error {
+	for _, fi := range fis {
+		if fi.UUID == "" {
+			return errors.New("Financial instrument read without a uuid, the query results are missing columns")
+		}
+	}
+	return nil
+}
+
+//ResolveUUID returns the uuid of the financial instrument identified by the given identifier, without reading the rest of it.
+//identifierType is one of identifierTypes, e.g. FIGIIdentifier.
+func (s service) ResolveUUID(identifierType string, value string) (string, bool, error) {
+	return s.resolveUUID(identifierType, value, false)
+}
+
+//ReadByIdentifier returns the financial instrument identified by the given identifier, as ResolveUUID finds it.
+//If excludeDeprecated is true, a deprecated identifier doesn't identify anything.
+func (s service) ReadByIdentifier(identifierType string, value string, excludeDeprecated bool) (financialInstrument, bool, error) {
+	uuid, found, err := s.resolveUUID(identifierType, value, excludeDeprecated)
+	if err != nil || !found {
+		return financialInstrument{}, false, err
+	}
+	return s.ReadTyped(uuid)
+}
+
+func (s service) resolveUUID(identifierType string, value string, excludeDeprecated bool) (string, bool, error) {
+	if err := validateIdentifierType(identifierType); err != nil {
+		return "", false, err
+	}
+
+	results := []struct {
+		UUID string `json:"uuid"`
+	}{}
+
+	where := ""
+	if excludeDeprecated {
+		where = `WHERE NOT coalesce(i.deprecated, false)`
+	}
+
+	query := &neoism.CypherQuery{
+		Statement: fmt.Sprintf(`MATCH (i:%s {value:{value}})-[:IDENTIFIES]->(fi:FinancialInstrument) %s
+				RETURN fi.uuid as uuid`, s.label(identifierType), where),
+		Parameters: map[string]interface{}{
+			"value": value,
+		},
+		Result: &results,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil || len(results) == 0 {
+		return "", false, err
+	}
+
+	return results[0].UUID, true, nil
+}
+
+func validateIdentifierType(identifierType string) error {
+	for _, knownType := range identifierTypes {
+		if identifierType == knownType {
+			return nil
+		}
+	}
+	return requestError{fmt.Sprintf("Unknown identifier type %q, expected one of %v", identifierType, identifierTypes)}
+}
+
+//ReadRaw returns every property and label stored on the Thing node with the given uuid, or a nil map if there is none.
+//It is a debugging/admin aid for investigating data issues and is not part of the rwapi contract,
+//so the shape of what it returns follows whatever is in the graph rather than the financialInstrument model.
+func (s service) ReadRaw(uuid string) (map[string]interface{}, []string, error) {
+	results := []struct {
+		Props  map[string]interface{} `json:"props"`
+		Labels []string               `json:"labels"`
+	}{}
+
+	query := &neoism.CypherQuery{
+		Statement: `MATCH (t:Thing {uuid:{uuid}})
+				RETURN properties(t) as props, labels(t) as labels`,
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		Result: &results,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil || len(results) == 0 {
+		return nil, nil, err
+	}
+
+	return results[0].Props, results[0].Labels, nil
+}
+
+//WriteMetadata is what the last write of a Thing node recorded about itself, for investigating why it keeps looking changed
+type WriteMetadata struct {
+	Hash         string     `json:"hash,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Source       string     `json:"source,omitempty"`
+	// Version is only set by writers that record one, which this service doesn't
+	Version string `json:"version,omitempty"`
+	// Labels shows whether the writer set the FinancialInstrument label
+	Labels []string `json:"labels"`
+}
+
+//ReadWriteMetadata returns the write metadata stored on the Thing node with the given uuid, whether or not it is labelled
+//as a financial instrument, so nodes last written by another writer or service version can be inspected too.
+//Like ReadRaw it is a diagnostic aid and not part of the rwapi contract.
+func (s service) ReadWriteMetadata(uuid string) (WriteMetadata, bool, error) {
+	results := []struct {
+		Hash         string   `json:"hash"`
+		LastModified *int64   `json:"lastModified"`
+		Source       string   `json:"source"`
+		Version      string   `json:"version"`
+		Labels       []string `json:"labels"`
+	}{}
+
+	query := &neoism.CypherQuery{
+		Statement: `MATCH (t:Thing {uuid:{uuid}})
+				RETURN t.hash as hash, t.lastModified as lastModified, t.source as source, t.version as version, labels(t) as labels`,
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		Result: &results,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil || len(results) == 0 {
+		return WriteMetadata{}, false, err
+	}
+
+	result := results[0]
+	return WriteMetadata{
+		Hash:         result.Hash,
+		LastModified: millisecondsTime(result.LastModified),
+		Source:       result.Source,
+		Version:      result.Version,
+		Labels:       result.Labels,
+	}, true, nil
+}
+
+//ReadByCurrency returns a page of the financial instruments traded in the given ISO 4217 currency, ordered by uuid
+func (s service) ReadByCurrency(code string, skip int, limit int) ([]financialInstrument, error) {
+	// The filter ignores an empty currency rather than rejecting it
+	if err := validateCurrency(code); err != nil {
+		return nil, err
+	}
+
+	return s.ReadFiltered(InstrumentFilter{Currency: code}, skip, limit)
+}
+
+//ReadByMIC returns a page of the financial instruments listed on the venue with the given ISO 10383 market identifier code, ordered by uuid
+func (s service) ReadByMIC(mic string, skip int, limit int) ([]financialInstrument, error) {
+	if err := validateMIC(mic); err != nil {
+		return nil, err
+	}
+
+	return s.readPage(`MATCH (fi:FinancialInstrument {micCode:{micCode}})`, map[string]interface{}{"micCode": mic}, skip, limit)
+}
+
+//ReadBySector returns a page of the financial instruments in the given sector, ordered by uuid
+func (s service) ReadBySector(sector string, skip int, limit int) ([]financialInstrument, error) {
+	if sector == "" {
+		return nil, requestError{"A sector is required to read by sector"}
+	}
+
+	return s.ReadFiltered(InstrumentFilter{Sector: sector}, skip, limit)
+}
+
+//ReadByCountryOfRisk returns a page of the financial instruments whose country of risk is the given ISO 3166-1 alpha-2 country, ordered by uuid
+func (s service) ReadByCountryOfRisk(country string, skip int, limit int) ([]financialInstrument, error) {
+	// The filter ignores an empty country rather than rejecting it
+	if err := validateCountry(country); err != nil {
+		return nil, err
+	}
+
+	return s.ReadFiltered(InstrumentFilter{CountryOfRisk: country}, skip, limit)
+}
+
+//ReadByStatus returns a page of the financial instruments with the given market status, ordered by uuid.
+//Financial instruments without a status are active.
+func (s service) ReadByStatus(status string, skip int, limit int) ([]financialInstrument, error) {
+	// The filter ignores an empty status rather than rejecting it
+	if err := validateStatus(status); err != nil {
+		return nil, err
+	}
+
+	return s.ReadFiltered(InstrumentFilter{Status: status}, skip, limit)
+}
+
+//ReadIssuedBetween returns a page of the financial instruments, ordered by uuid, issued on or after the date of from
+//and on or before the date of to. Financial instruments without an issue date are never returned.
+func (s service) ReadIssuedBetween(from time.Time, to time.Time, skip int, limit int) ([]financialInstrument, error) {
+	return s.readPage(`MATCH (fi:FinancialInstrument)
+				WHERE fi.issueDate >= {from} AND fi.issueDate <= {to}`,
+		map[string]interface{}{"from": from.Format(issueDateLayout), "to": to.Format(issueDateLayout)}, skip, limit)
+}
+
+//ReadTestData returns a page of the financial instruments marked as test/sandbox data, ordered by uuid, e.g. for purging them
+func (s service) ReadTestData(skip int, limit int) ([]financialInstrument, error) {
+	return s.readPage(`MATCH (fi:FinancialInstrument {isTest:true})`, nil, skip, limit)
+}
+
+//ReadUnidentified returns a page of the financial instruments, ordered by uuid, that have no identifiers other than UPP uuids,
+//i.e. no Factset, FIGI or WSOD identifier
+func (s service) ReadUnidentified(skip int, limit int) ([]financialInstrument, error) {
+	return s.readPage(fmt.Sprintf(`MATCH (fi:FinancialInstrument)
+				OPTIONAL MATCH (fi)<-[:IDENTIFIES]-(i:Identifier) WHERE NOT i:%s
+				WITH fi, count(i) as identifiers WHERE identifiers = 0`, s.label(uppIdentifierLabel)), nil, skip, limit)
+}
+
+//ReadByRelationshipPresence returns a page of the financial instruments, ordered by uuid, that have a relationship of type relType
+//if present is true, or that have none if it is false. relType must be one of the relationships the service writes, e.g. ISSUED_BY.
+func (s service) ReadByRelationshipPresence(relType string, present bool, skip int, limit int) ([]financialInstrument, error) {
+	pattern, ok := managedRelationships[relType]
+	if !ok {
+		return nil, requestError{fmt.Sprintf("Unknown relationship type %q, must be one of those the service writes", relType)}
+	}
+
+	if !present {
+		pattern = "NOT " + pattern
+	}
+	return s.readPage(`MATCH (fi:FinancialInstrument)
+				WHERE `+pattern, nil, skip, limit)
+}
+
+//ReadByFIGIPrefix returns a page of the financial instruments, ordered by uuid, with a FIGI starting with prefix.
+//Every FIGI issued so far starts with BBG, so prefix must be at least minFIGIPrefixLength characters to narrow the match
+//rather than scan every FIGI.
+func (s service) ReadByFIGIPrefix(prefix string, skip int, limit int) ([]financialInstrument, error) {
+	if len(prefix) < minFIGIPrefixLength {
+		return nil, requestError{fmt.Sprintf("FIGI prefix must be at least %d characters", minFIGIPrefixLength)}
+	}
+
+	return s.readPage(fmt.Sprintf(`MATCH (figi:%s)-[:IDENTIFIES]->(fi:FinancialInstrument)
+				WHERE figi.value STARTS WITH {prefix}
+				WITH DISTINCT fi`, s.label(figiIdentifierLabel)),
+		map[string]interface{}{"prefix": prefix}, skip, limit)
+}
+
+//ReadSiblings returns the other financial instruments issued by the issuer of the one with the given uuid, ordered by uuid.
+//At most maxSiblings are returned. It returns an empty slice if the financial instrument doesn't exist or has no issuer.
+func (s service) ReadSiblings(uuid string) ([]financialInstrument, error) {
+	return s.readPage(`MATCH (:FinancialInstrument {uuid:{uuid}})-[:ISSUED_BY]->(issuer:Thing)<-[:ISSUED_BY]-(fi:FinancialInstrument)
+				WHERE fi.uuid <> {uuid}
+				WITH DISTINCT fi`,
+		map[string]interface{}{"uuid": uuid}, 0, maxSiblings)
+}
+
+//InstrumentDetail is what ReadDetail returns for a financial instrument's detail page
+type InstrumentDetail struct {
+	// FinancialInstrument is the financial instrument, as ReadTyped returns it
+	FinancialInstrument financialInstrument `json:"financialInstrument"`
+	// IssuerPrefLabel is the prefLabel of the Thing the financial instrument's IssuedBy identifies,
+	// empty if it has no issuer or the issuer has no prefLabel
+	IssuerPrefLabel string `json:"issuerPrefLabel"`
+	// SiblingCount is how many other financial instruments the same issuer issued, 0 if it has no issuer
+	SiblingCount int `json:"siblingCount"`
+}
+
+//ReadDetail returns the financial instrument with the given uuid, with its issuer's prefLabel and how many siblings it has,
+//reading them all in one transaction rather than calling ReadTyped, reading the issuer and counting ReadSiblings separately.
+//The cache is bypassed, so the three are consistent with each other.
+func (s service) ReadDetail(uuid string) (InstrumentDetail, bool, error) {
+	readQuery, results := s.pageQuery(`MATCH (fi:FinancialInstrument {uuid:{uuid}})`, map[string]interface{}{"uuid": uuid}, "uuid", false, 0, 1)
+
+	issuers := []struct {
+		PrefLabel string `json:"prefLabel"`
+	}{}
+	issuerQuery := &neoism.CypherQuery{
+		Statement: `MATCH (:FinancialInstrument {uuid:{uuid}})-[:ISSUED_BY]->(issuer:Thing)
+				RETURN issuer.prefLabel as prefLabel
+				LIMIT 1`,
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		Result: &issuers,
+	}
+
+	siblings := []struct {
+		Count int `json:"count"`
+	}{}
+	siblingsQuery := &neoism.CypherQuery{
+		Statement: `MATCH (:FinancialInstrument {uuid:{uuid}})-[:ISSUED_BY]->(issuer:Thing)<-[:ISSUED_BY]-(fi:FinancialInstrument)
+				WHERE fi.uuid <> {uuid}
+				RETURN count(DISTINCT fi) as count`,
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		Result: &siblings,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{readQuery, issuerQuery, siblingsQuery}); err != nil {
+		return InstrumentDetail{}, false, err
+	}
+
+	fis, err := decodePage(*results)
+	if err != nil || len(fis) == 0 {
+		return InstrumentDetail{}, false, err
+	}
+
+	detail := InstrumentDetail{FinancialInstrument: fis[0]}
+	if len(issuers) > 0 {
+		detail.IssuerPrefLabel = issuers[0].PrefLabel
+	}
+	if len(siblings) > 0 {
+		detail.SiblingCount = siblings[0].Count
+	}
+	return detail, true, nil
+}
+
+//ReadGroupedByIssuer returns the financial instruments issued by each of the issuers with orgUUIDs, keyed by issuer uuid,
+//each in uuid order, reading them all in one query. Every issuer asked for has an entry, which is empty if it issued none.
+//At most maxGroupedIssuers issuers can be read at once, and it returns a requestError rather than a partial result
+//if they issued more than maxGroupedByIssuer financial instruments between them.
+func (s service) ReadGroupedByIssuer(orgUUIDs []string) (map[string][]financialInstrument, error) {
+	if len(orgUUIDs) > maxGroupedIssuers {
+		return nil, requestError{fmt.Sprintf("Cannot read the financial instruments of more than %d issuers at once, got %d", maxGroupedIssuers, len(orgUUIDs))}
+	}
+	grouped := map[string][]financialInstrument{}
+	for _, orgUUID := range orgUUIDs {
+		if err := validateUUID(orgUUID); err != nil {
+			return nil, err
+		}
+		grouped[orgUUID] = []financialInstrument{}
+	}
+	if len(orgUUIDs) == 0 {
+		return grouped, nil
+	}
+
+	fis, err := s.readPage(`MATCH (issuer:Thing)<-[:ISSUED_BY]-(fi:FinancialInstrument)
+				WHERE issuer.uuid IN {orgUUIDs}
+				WITH DISTINCT fi`, map[string]interface{}{"orgUUIDs": orgUUIDs}, 0, maxGroupedByIssuer+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(fis) > maxGroupedByIssuer {
+		return nil, requestError{fmt.Sprintf("The issuers issued more than %d financial instruments between them, read fewer issuers at once", maxGroupedByIssuer)}
+	}
+
+	for _, fi := range fis {
+		if _, ok := grouped[fi.IssuedBy]; ok {
+			grouped[fi.IssuedBy] = append(grouped[fi.IssuedBy], fi)
+		}
+	}
+	return grouped, nil
+}
+
+//ReadWithRelated returns the financial instrument with the given uuid, as ReadTyped does, together with the financial instruments
+//connected to it by UNDERLIES or HAS_UNDERLYING relationships, in either direction, at most depth hops away, ordered by uuid.
+//depth must be between 1 and maxRelatedDepth, and at most maxRelated related financial instruments are returned.
+func (s service) ReadWithRelated(uuid string, depth int) (financialInstrument, []financialInstrument, bool, error) {
+	if depth < 1 || depth > maxRelatedDepth {
+		return financialInstrument{}, nil, false, requestError{fmt.Sprintf("Depth must be between 1 and %d, got %d", maxRelatedDepth, depth)}
+	}
+
+	fi, found, err := s.ReadTyped(uuid)
+	if err != nil || !found {
+		return financialInstrument{}, nil, false, err
+	}
+
+	// The depth of a variable length relationship can't be a parameter, but it has been validated
+	related, err := s.readPage(fmt.Sprintf(`MATCH (:FinancialInstrument {uuid:{uuid}})-[:UNDERLIES|HAS_UNDERLYING*1..%d]-(fi:FinancialInstrument)
+				WHERE fi.uuid <> {uuid}
+				WITH DISTINCT fi`, depth),
+		map[string]interface{}{"uuid": uuid}, 0, maxRelated)
+	if err != nil {
+		return financialInstrument{}, nil, false, err
+	}
+	return fi, related, true, nil
+}
+
+//ReadByIssuerIdentifier returns a page of the financial instruments issued by the Thing identified by the identifier of the given type and value,
+//ordered by uuid, resolving the issuer the way Write does. identifierType is one of identifierTypes, e.g. FactsetIdentifier.
+func (s service) ReadByIssuerIdentifier(identifierType string, value string, skip int, limit int) ([]financialInstrument, error) {
+	if err := validateIdentifierType(identifierType); err != nil {
+		return nil, err
+	}
+
+	issuerLabel := "Thing"
+	if s.issuerLabel != "" {
+		issuerLabel = quoteLabel(s.issuerLabel)
+	}
+	return s.readPage(fmt.Sprintf(`MATCH (:%s {value:{value}})-[:IDENTIFIES]->(issuer:%s)<-[:ISSUED_BY]-(fi:FinancialInstrument)
+				WITH DISTINCT fi`, s.label(identifierType), issuerLabel),
+		map[string]interface{}{"value": value}, skip, limit)
+}
+
+func createNewIdentifierQuery(uuid string, identifierLabel string, identifierValue string) *neoism.CypherQuery {
+	statementTemplate := fmt.Sprintf(`MERGE (t:Thing {uuid:{uuid}})
+				CREATE (i:Identifier {value:{value}})
+				MERGE (t)<-[:IDENTIFIES]-(i)
+				set i : %s`, identifierLabel)
+
+	query := &neoism.CypherQuery{
+		Statement: statementTemplate,
+		Parameters: map[string]interface{}{
+			"uuid":  uuid,
+			"value": identifierValue,
+		},
+	}
+	return query
+}
+
+//identifierQueryFor returns the function that builds the query writing each identifier when writing with opts
+func identifierQueryFor(opts WriteOptions) func(uuid string, identifierLabel string, identifierValue string) *neoism.CypherQuery {
+	if opts.PreserveRelationships {
+		return mergeIdentifierQuery
+	}
+	return createNewIdentifierQuery
+}
+
+//mergeIdentifierQuery is used instead of createNewIdentifierQuery when the existing identifiers have not been deleted
+func mergeIdentifierQuery(uuid string, identifierLabel string, identifierValue string) *neoism.CypherQuery {
+	statementTemplate := fmt.Sprintf(`MERGE (t:Thing {uuid:{uuid}})
+				MERGE (i:%s {value:{value}})
+				MERGE (t)<-[:IDENTIFIES]-(i)
+				set i : Identifier`, identifierLabel)
+
+	query := &neoism.CypherQuery{
+		Statement: statementTemplate,
+		Parameters: map[string]interface{}{
+			"uuid":  uuid,
+			"value": identifierValue,
+		},
+	}
+	return query
+}
+
+func (s service) getIdentifierQueries(fi financialInstrument, identifierQuery func(uuid string, identifierLabel string, identifierValue string) *neoism.CypherQuery) []*neoism.CypherQuery {
+	return append(s.createIdentifierQueries(fi, identifierQuery), deprecateIdentifiersQueries(fi)...)
+}
+
+//createIdentifierQueries returns the queries that write the identifiers of fi with identifierQuery, without marking any as deprecated
+func (s service) createIdentifierQueries(fi financialInstrument, identifierQuery func(uuid string, identifierLabel string, identifierValue string) *neoism.CypherQuery) []*neoism.CypherQuery {
+	queries := []*neoism.CypherQuery{}
+
+	//ADD all the IDENTIFIER nodes and IDENTIFIES relationships
+	for _, alternativeUUID := range fi.AlternativeIdentifiers.UUIDS {
+		if alternativeUUID != "" {
+			queries = append(queries, identifierQuery(fi.UUID, s.label(uppIdentifierLabel), alternativeUUID))
+		}
+	}
+
+	if fi.AlternativeIdentifiers.FactsetIdentifier != "" {
+		queries = append(queries, identifierQuery(fi.UUID, s.label(factsetIdentifierLabel), fi.AlternativeIdentifiers.FactsetIdentifier))
+	}
+
+	if fi.AlternativeIdentifiers.FIGICode != "" {
+		queries = append(queries, identifierQuery(fi.UUID, s.label(figiIdentifierLabel), fi.AlternativeIdentifiers.FIGICode))
+	}
+
+	if fi.AlternativeIdentifiers.WSODIdentifier != "" {
+		queries = append(queries, identifierQuery(fi.UUID, s.label(wsodIdentifierLabel), fi.AlternativeIdentifiers.WSODIdentifier))
+	}
+
+	return queries
+}
+
+//deprecateIdentifiersQueries returns the queries that mark the deprecated identifiers of fi as such, once they have been written
+func deprecateIdentifiersQueries(fi financialInstrument) []*neoism.CypherQuery {
+	queries := []*neoism.CypherQuery{}
+	if len(fi.DeprecatedIdentifiers) > 0 {
+		deprecateIdentifiersQuery := &neoism.CypherQuery{
+			Statement: `MATCH (t:Thing {uuid:{uuid}})<-[:IDENTIFIES]-(i:Identifier)
+				WHERE i.value IN {values}
+				SET i.deprecated = true`,
+			Parameters: map[string]interface{}{
+				"uuid":   fi.UUID,
+				"values": fi.DeprecatedIdentifiers,
+			},
+		}
+		queries = append(queries, deprecateIdentifiersQuery)
+	}
+
+	return queries
+}
+
+//WriteOptions changes how WriteWithOptions persists a financial instrument
+type WriteOptions struct {
+	// PreserveRelationships skips deleting the existing ISSUED_BY, TAGGED_WITH and IDENTIFIES relationships before writing,
+	// so only the core node and the identifiers/issuer/tags in the payload are upserted.
+	// Identifiers, issuers or tags dropped from the payload are NOT removed and will be left as stale relationships,
+	// so callers using this must manage those relationships themselves.
+	PreserveRelationships bool
+	// OnlyIfHigherTrust skips writing financial instruments stored with a higher trust level than their own, listing them
+	// in the WriteResult's Skipped. A financial instrument without a trust level has the lowest, 0. The stored trust level
+	// is compared in the write's own transaction, under a write lock on the financial instrument, so a concurrent
+	// less trusted write can't overwrite it.
+	OnlyIfHigherTrust bool
+	// RequireExistingIssuer rejects a financial instrument whose IssuedBy neither identifies an existing Thing nor is the uuid of one
+	// with a requestError, rather than creating a new Thing for the issuer. Without it, an unknown issuer is created as Write always has.
+	RequireExistingIssuer bool
+	// IdempotencyKey, if set, is recorded on each financial instrument written, and a financial instrument whose last write
+	// had the same key isn't written again, but listed in the WriteResult's Duplicates. The stored key is compared
+	// in the write's own transaction, under a write lock on the financial instrument, so two concurrent deliveries can't both be written.
+	IdempotencyKey string
+}
+
+//WriteResult lists the financial instruments a write with WriteOptions didn't write, as it was asked not to.
+//These aren't errors: any others in the same write were written.
+type WriteResult struct {
+	// Skipped are the uuids of those stored with a higher trust level, with WriteOptions.OnlyIfHigherTrust
+	Skipped []string
+	// Duplicates are the uuids of those whose last write had the same WriteOptions.IdempotencyKey, so were already written
+	Duplicates []string
+}
+
+func (s service) Write(thing interface{}, transactionID string) error {
+	return s.WriteTyped(thing.(financialInstrument), transactionID)
+}
+
+//WriteTyped writes the financial instrument as Write does, but without needing it passed as an interface{}
+func (s service) WriteTyped(fi financialInstrument, transactionID string) error {
+	_, err := s.WriteWithOptions(fi, transactionID, WriteOptions{})
+	return err
+}
+
+//WriteWithOptions writes the financial instrument as Write does, with the behaviour modified by opts
+func (s service) WriteWithOptions(fi financialInstrument, transactionID string, opts WriteOptions) (WriteResult, error) {
+	end, err := s.begin("write")
+	if err != nil {
+		return WriteResult{}, err
+	}
+	defer end()
+
+	if err := s.validate(fi); err != nil {
+		return WriteResult{}, err
+	}
+	fi = tidy(fi)
+
+	if err := s.validateUniqueness([]financialInstrument{fi}); err != nil {
+		return WriteResult{}, err
+	}
+
+	issuers, err := s.resolveIssuers([]financialInstrument{fi})
+	if err != nil {
+		return WriteResult{}, err
+	}
+
+	guard := s.newWriteGuard([]financialInstrument{fi}, opts)
+	queries, err := s.writeQueries(fi, opts, issuers, true)
+	if err != nil {
+		return WriteResult{}, err
+	}
+	queries = guard.guard(fi.UUID, queries)
+
+	before, after := s.identifierHistoryQueries([]financialInstrument{fi}, !opts.PreserveRelationships)
+	queries = append(append(before, queries...), after...)
+	queries = guard.wrap(queries)
+
+	if err := s.cypherBatch(queries); err != nil {
+		return WriteResult{}, err
+	}
+	s.mirror(queries)
+
+	for _, written := range guard.written([]financialInstrument{fi}) {
+		s.changed(AuditRecord{Operation: auditWrite, UUID: written.UUID, TransactionID: transactionID, Payload: &written, Options: &opts})
+	}
+	return guard.result(), nil
+}
+
+//WriteBatch writes all the financial instruments in a single batch, as WriteWithOptions would write each of them.
+//Their issuers are resolved together in one query rather than one query per financial instrument.
+func (s service) WriteBatch(fis []financialInstrument, transactionID string, opts WriteOptions) (WriteResult, error) {
+	end, err := s.begin("write")
+	if err != nil {
+		return WriteResult{}, err
+	}
+	defer end()
+
+	trimmed := make([]financialInstrument, 0, len(fis))
+	for _, fi := range fis {
+		if err := s.validate(fi); err != nil {
+			return WriteResult{}, err
+		}
+		trimmed = append(trimmed, tidy(fi))
+	}
+	fis = trimmed
+
+	if err := s.validateUniqueness(fis); err != nil {
+		return WriteResult{}, err
+	}
+
+	issuers, err := s.resolveIssuers(fis)
+	if err != nil {
+		return WriteResult{}, err
+	}
+
+	guard := s.newWriteGuard(fis, opts)
+	periodic := s.periodicCommitSize > 0 && len(fis) > s.periodicCommitSize
+	queries := []*neoism.CypherQuery{}
+	for _, fi := range fis {
+		fiQueries, err := s.writeQueries(fi, opts, issuers, !periodic)
+		if err != nil {
+			return WriteResult{}, err
+		}
+		queries = append(queries, guard.guard(fi.UUID, fiQueries)...)
+	}
+
+	before, after := s.identifierHistoryQueries(fis, !opts.PreserveRelationships)
+	queries = append(before, queries...)
+
+	if periodic {
+		if err := s.writeIdentifiersPeriodically(guard.wrap(queries), fis, opts, after, guard); err != nil {
+			return WriteResult{}, err
+		}
+	} else {
+		queries = guard.wrap(append(queries, after...))
+		if err := s.cypherBatch(queries); err != nil {
+			return WriteResult{}, err
+		}
+		s.mirror(queries)
+	}
+
+	written := guard.written(fis)
+	for i := range written {
+		s.changed(AuditRecord{Operation: auditWrite, UUID: written[i].UUID, TransactionID: transactionID, Payload: &written[i], Options: &opts})
+	}
+	return guard.result(), nil
+}
+
+//writeGuard makes a write skip the financial instruments its WriteOptions say not to write, deciding which in the write's
+//own transaction, so that the decision can't be overtaken by a concurrent write. A nil writeGuard skips nothing.
+type writeGuard struct {
+	uuids   []string
+	decide  *neoism.CypherQuery
+	report  *neoism.CypherQuery
+	skipped *[]skippedWrite
+}
+
+type skippedWrite struct {
+	UUID   string `json:"uuid"`
+	Reason string `json:"reason"`
+}
+
+//The reasons writeGuard records for skipping the write of a financial instrument
+const (
+	skippedDuplicate   = "duplicate"
+	skippedLessTrusted = "lessTrusted"
+)
+
+//newWriteGuard returns the writeGuard for writing fis with opts, or nil if opts don't make the write conditional
+func (s service) newWriteGuard(fis []financialInstrument, opts WriteOptions) *writeGuard {
+	if opts.IdempotencyKey == "" && !opts.OnlyIfHigherTrust {
+		return nil
+	}
+
+	uuids := make([]string, 0, len(fis))
+	guards := make([]map[string]interface{}, 0, len(fis))
+	for _, fi := range fis {
+		uuids = append(uuids, fi.UUID)
+		guards = append(guards, map[string]interface{}{"uuid": fi.UUID, "trustLevel": fi.TrustLevel})
+	}
+
+	parameters := map[string]interface{}{
+		"guards": guards,
+	}
+	reasons := ""
+	if opts.IdempotencyKey != "" {
+		reasons += `
+					WHEN coalesce(t.idempotencyKey, '') = {idempotencyKey} THEN {duplicate}`
+		parameters["idempotencyKey"] = opts.IdempotencyKey
+		parameters["duplicate"] = skippedDuplicate
+	}
+	if opts.OnlyIfHigherTrust {
+		reasons += `
+					WHEN coalesce(t.trustLevel, 0) > guard.trustLevel THEN {lessTrusted}`
+		parameters["lessTrusted"] = skippedLessTrusted
+	}
+
+	// The first SET takes the write lock on each Thing, so the stored properties the second reads are the latest committed,
+	// and a concurrent write of the same financial instrument waits for this one to commit
+	decide := &neoism.CypherQuery{
+		Statement: `UNWIND {guards} as guard
+				MERGE (t:Thing {uuid:guard.uuid})
+				SET t.writeSkipped = ''
+				WITH t, guard
+				SET t.writeSkipped = CASE` + reasons + `
+					ELSE '' END`,
+		Parameters: parameters,
+	}
+
+	skipped := []skippedWrite{}
+	report := &neoism.CypherQuery{
+		Statement: `UNWIND {uuids} as uuid
+				MATCH (t:Thing {uuid:uuid})
+				WHERE t.writeSkipped IS NOT NULL
+				WITH t, t.writeSkipped as reason
+				REMOVE t.writeSkipped
+				WITH t, reason
+				WHERE reason <> ''
+				RETURN t.uuid as uuid, reason`,
+		Parameters: map[string]interface{}{
+			"uuids": uuids,
+		},
+		Result: &skipped,
+	}
+
+	return &writeGuard{uuids: uuids, decide: decide, report: report, skipped: &skipped}
+}
+
+//guard makes each of queries, which write the financial instrument with the given uuid, do nothing if it is skipped
+func (g *writeGuard) guard(uuid string, queries []*neoism.CypherQuery) []*neoism.CypherQuery {
+	if g == nil {
+		return queries
+	}
+	for _, query := range queries {
+		parameters := map[string]interface{}{"guardUuid": uuid}
+		for name, value := range query.Parameters {
+			parameters[name] = value
+		}
+		query.Statement = `MATCH (guard:Thing {uuid:{guardUuid}})
+				WHERE coalesce(guard.writeSkipped, '') = ''
+				WITH guard
+				` + query.Statement
+		query.Parameters = parameters
+	}
+	return queries
+}
+
+//wrap returns queries, which must include those guard guarded, between the queries deciding which financial instruments
+//to skip and reporting which were
+func (g *writeGuard) wrap(queries []*neoism.CypherQuery) []*neoism.CypherQuery {
+	if g == nil {
+		return queries
+	}
+	return append(append([]*neoism.CypherQuery{g.decide}, queries...), g.report)
+}
+
+//written returns those of fis that weren't skipped, once the queries wrap returned have been run
+func (g *writeGuard) written(fis []financialInstrument) []financialInstrument {
+	if g == nil {
+		return fis
+	}
+	skipped := map[string]bool{}
+	for _, write := range *g.skipped {
+		skipped[write.UUID] = true
+	}
+	written := make([]financialInstrument, 0, len(fis))
+	for _, fi := range fis {
+		if !skipped[fi.UUID] {
+			written = append(written, fi)
+		}
+	}
+	return written
+}
+
+//result returns the WriteResult listing the financial instruments that were skipped, once the queries wrap returned have been run
+func (g *writeGuard) result() WriteResult {
+	result := WriteResult{}
+	if g == nil {
+		return result
+	}
+	for _, write := range *g.skipped {
+		switch write.Reason {
+		case skippedDuplicate:
+			result.Duplicates = append(result.Duplicates, write.UUID)
+		case skippedLessTrusted:
+			result.Skipped = append(result.Skipped, write.UUID)
+		}
+	}
+	return result
+}
+
+//Patch updates only the given scalar properties of the financial instrument, keyed by their JSON names (e.g. prefLabel),
+//leaving its other properties and relationships untouched. An empty value removes the property.
+//The stored hash is recomputed for the patched financial instrument, which is read from Neo4j rather than the cache
+//so that a stale cached version can't be patched. It returns false if there is no such financial instrument.
+func (s service) Patch(uuid string, changes map[string]interface{}) (bool, error) {
+	end, err := s.begin("patch")
+	if err != nil {
+		return false, err
+	}
+	defer end()
+
+	if _, ok := changes["uuid"]; ok {
+		return false, requestError{"The uuid of a financial instrument cannot be patched"}
+	}
+
+	fi, found, err := s.readStored(uuid)
+	if err != nil || !found {
+		return false, err
+	}
+
+	props := map[string]interface{}{}
+	for name, value := range changes {
+		if err := applyPatch(&fi, name, value); err != nil {
+			return false, err
+		}
+		if value == "" || value == false {
+			value = nil
+		}
+		props[name] = value
+	}
+
+	if err := s.validate(fi); err != nil {
+		return false, err
+	}
+
+	hash, err := hashOf(fi)
+	if err != nil {
+		return false, err
+	}
+	props["hash"] = hash
+	props["lastModified"] = lastModified(time.Now())
+
+	query := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument {uuid:{uuid}})
+				SET fi += {props}`,
+		Parameters: map[string]interface{}{
+			"uuid":  uuid,
+			"props": props,
+		},
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return false, err
+	}
+	s.mirror([]*neoism.CypherQuery{query})
+
+	s.changed(AuditRecord{Operation: auditWrite, UUID: uuid, Payload: &fi, Options: &WriteOptions{}})
+	return true, nil
+}
+
+//applyPatch sets the scalar field of fi with the given JSON name, returning a requestError if it can't be patched to value
+func applyPatch(fi *financialInstrument, name string, value interface{}) error {
+	var ok bool
+	switch name {
+	case "prefLabel":
+		fi.PrefLabel, ok = value.(string)
+	case "currency":
+		fi.Currency, ok = value.(string)
+	case "micCode":
+		fi.MICCode, ok = value.(string)
+	case "sector":
+		fi.Sector, ok = value.(string)
+	case "countryOfRisk":
+		fi.CountryOfRisk, ok = value.(string)
+	case "source":
+		fi.Source, ok = value.(string)
+	case "status":
+		fi.Status, ok = value.(string)
+	case "isTest":
+		fi.IsTest, ok = value.(bool)
+	default:
+		return requestError{fmt.Sprintf("Property %q cannot be patched", name)}
+	}
+	if !ok {
+		return requestError{fmt.Sprintf("Invalid value %v for property %q", value, name)}
+	}
+	return nil
+}
+
+//Rekey moves the financial instrument with oldUUID to newUUID, updating its uuid and the UPPIdentifier for it,
+//and keeping all its other identifiers and relationships. It returns a ConflictError if newUUID is already in use,
+//which is checked in the same statement as the rekey so that two rekeys to the same uuid can't both succeed.
+//The financial instrument is read from Neo4j rather than the cache, so that its new hash isn't of a stale cached version.
+func (s service) Rekey(oldUUID string, newUUID string) error {
+	end, err := s.begin("rekey")
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	if oldUUID == newUUID {
+		return requestError{"The new uuid must differ from the old uuid"}
+	}
+	if err := validateUUID(newUUID); err != nil {
+		return err
+	}
+
+	fi, found, err := s.readStored(oldUUID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return requestError{fmt.Sprintf("There is no financial instrument %s to rekey", oldUUID)}
+	}
+
+	fi.UUID = newUUID
+	uuids := []string{}
+	for _, alternativeUUID := range fi.AlternativeIdentifiers.UUIDS {
+		if alternativeUUID == oldUUID {
+			alternativeUUID = newUUID
+		}
+		uuids = append(uuids, alternativeUUID)
+	}
+	fi.AlternativeIdentifiers.UUIDS = uuids
+
+	hash, err := hashOf(fi)
+	if err != nil {
+		return err
+	}
+
+	uppLabel := s.label(uppIdentifierLabel)
+	results := []struct {
+		InUse bool `json:"inUse"`
+	}{}
+	rekeyQuery := &neoism.CypherQuery{
+		Statement: fmt.Sprintf(`MATCH (fi:FinancialInstrument {uuid:{oldUUID}})
+				OPTIONAL MATCH (t:Thing {uuid:{newUUID}})
+				OPTIONAL MATCH (i:%s {value:{newUUID}})
+				WITH fi, count(t) + count(i) > 0 as inUse
+				OPTIONAL MATCH (upp:%s {value:{oldUUID}})-[:IDENTIFIES]->(fi)
+				FOREACH (x IN CASE WHEN inUse THEN [] ELSE [1] END |
+					SET fi.uuid = {newUUID}, fi.hash = {hash}, fi.lastModified = {lastModified}, upp.value = {newUUID})
+				RETURN inUse`, uppLabel, uppLabel),
+		Parameters: map[string]interface{}{
+			"oldUUID":      oldUUID,
+			"newUUID":      newUUID,
+			"hash":         hash,
+			"lastModified": lastModified(time.Now()),
+		},
+		Result: &results,
+	}
+	if err := s.cypherBatch([]*neoism.CypherQuery{rekeyQuery}); err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return requestError{fmt.Sprintf("There is no financial instrument %s to rekey", oldUUID)}
+	}
+	if results[0].InUse {
+		return ConflictError{fmt.Sprintf("Cannot rekey financial instrument %s, uuid %s is already in use", oldUUID, newUUID)}
+	}
+	s.mirror([]*neoism.CypherQuery{rekeyQuery})
+
+	s.changed(AuditRecord{Operation: auditDelete, UUID: oldUUID})
+	s.changed(AuditRecord{Operation: auditWrite, UUID: newUUID, Payload: &fi, Options: &WriteOptions{}})
+	return nil
+}
+
+//ReplaceIdentifiers replaces all the alternative identifiers of the financial instrument with the given uuid with identifiers,
+//in a single transaction so that it is never read without either set. Its other properties, issuer and tags are left alone.
+//Deprecated identifiers that are among the new identifiers stay deprecated. The financial instrument is read from Neo4j
+//rather than the cache, so that its hash and deprecated identifiers aren't taken from a stale cached version.
+func (s service) ReplaceIdentifiers(uuid string, identifiers alternativeIdentifiers) error {
+	end, err := s.begin("replace identifiers of")
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	fi, found, err := s.readStored(uuid)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return requestError{fmt.Sprintf("There is no financial instrument %s to replace the identifiers of", uuid)}
+	}
+
+	fi.AlternativeIdentifiers = identifiers
+	fi = tidy(fi)
+	stillDeprecated := []string{}
+	for _, deprecated := range fi.DeprecatedIdentifiers {
+		for _, identifierType := range identifierTypes {
+			for _, value := range identifierValues(fi, identifierType) {
+				if value == deprecated {
+					stillDeprecated = append(stillDeprecated, deprecated)
+				}
+			}
+		}
+	}
+	fi.DeprecatedIdentifiers = nil
+	if len(stillDeprecated) > 0 {
+		fi.DeprecatedIdentifiers = stillDeprecated
+	}
+
+	if err := s.validate(fi); err != nil {
+		return err
+	}
+	if err := s.validateUniqueness([]financialInstrument{fi}); err != nil {
+		return err
+	}
+
+	hash, err := hashOf(fi)
+	if err != nil {
+		return err
+	}
+
+	deleteIdentifiersQuery := &neoism.CypherQuery{
+		Statement: `MATCH (t:Thing {uuid:{uuid}})<-[ir:IDENTIFIES]-(i:Identifier)
+				DELETE ir, i`,
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+	}
+	updateQuery := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument {uuid:{uuid}})
+				SET fi.hash = {hash}, fi.lastModified = {lastModified}`,
+		Parameters: map[string]interface{}{
+			"uuid":         uuid,
+			"hash":         hash,
+			"lastModified": lastModified(time.Now()),
+		},
+	}
+	before, after := s.identifierHistoryQueries([]financialInstrument{fi}, true)
+	queries := append(before, deleteIdentifiersQuery, updateQuery)
+	queries = append(append(queries, s.getIdentifierQueries(fi, createNewIdentifierQuery)...), after...)
+
+	if err := s.cypherBatch(queries); err != nil {
+		return err
+	}
+	s.mirror(queries)
+
+	s.changed(AuditRecord{Operation: auditWrite, UUID: uuid, Payload: &fi, Options: &WriteOptions{}})
+	return nil
+}
+
+//DeprecateIdentifier marks the identifier of the given type and value of the financial instrument with the given uuid as deprecated,
+//keeping it but letting ReadByIdentifier leave it out. Only the identifier and the hash of the financial instrument are updated.
+//It returns false if there is no such financial instrument or it has no such identifier.
+func (s service) DeprecateIdentifier(uuid string, identifierType string, value string) (bool, error) {
+	end, err := s.begin("deprecate identifiers of")
+	if err != nil {
+		return false, err
+	}
+	defer end()
+	if err := validateIdentifierType(identifierType); err != nil {
+		return false, err
+	}
+
+	fi, found, err := s.readStored(uuid)
+	if err != nil || !found {
+		return false, err
+	}
+
+	identified := false
+	for _, identifierValue := range identifierValues(fi, identifierType) {
+		identified = identified || identifierValue == value
+	}
+	if !identified {
+		return false, nil
+	}
+
+	for _, deprecated := range fi.DeprecatedIdentifiers {
+		if deprecated == value {
+			return true, nil
+		}
+	}
+	fi.DeprecatedIdentifiers = append(fi.DeprecatedIdentifiers, value)
+
+	hash, err := hashOf(fi)
+	if err != nil {
+		return false, err
+	}
+
+	results := []struct {
+		UUID string `json:"uuid"`
+	}{}
+	deprecateQuery := &neoism.CypherQuery{
+		Statement: fmt.Sprintf(`MATCH (i:%s {value:{value}})-[:IDENTIFIES]->(fi:FinancialInstrument {uuid:{uuid}})
+				SET i.deprecated = true, fi.hash = {hash}
+				RETURN fi.uuid as uuid`, s.label(identifierType)),
+		Parameters: map[string]interface{}{
+			"uuid":  uuid,
+			"value": value,
+			"hash":  hash,
+		},
+		Result: &results,
+	}
+	if err := s.cypherBatch([]*neoism.CypherQuery{deprecateQuery}); err != nil {
+		return false, err
+	}
+	if len(results) == 0 {
+		// The identifier was removed since the financial instrument was read
+		return false, nil
+	}
+	s.mirror([]*neoism.CypherQuery{deprecateQuery})
+
+	s.changed(AuditRecord{Operation: auditWrite, UUID: uuid, Payload: &fi, Options: &WriteOptions{}})
+	return true, nil
+}
+
+//changed is called after each successful change to a financial instrument, with a record of the change
+func (s service) changed(record AuditRecord) {
+	if s.cache != nil {
+		s.cache.Delete(record.UUID)
+	}
+	s.audit(record)
+	s.notify(record.UUID, record.Operation)
+}
+
+//validateUniqueness returns a ConflictError if any alternative UPP uuid of fis is the uuid of a different financial instrument,
+//as writing it would create a UPPIdentifier that resolves to the wrong financial instrument
+func (s service) validateUniqueness(fis []financialInstrument) error {
+	alternativeOf := map[string]string{}
+	for _, fi := range fis {
+		for _, alternativeUUID := range fi.AlternativeIdentifiers.UUIDS {
+			if alternativeUUID != "" && alternativeUUID != fi.UUID {
+				alternativeOf[alternativeUUID] = fi.UUID
+			}
+		}
+	}
+	if len(alternativeOf) == 0 {
+		return nil
+	}
+
+	for _, fi := range fis {
+		if owner, ok := alternativeOf[fi.UUID]; ok {
+			return ConflictError{fmt.Sprintf("Alternative uuid %s of financial instrument %s is the uuid of financial instrument %s", fi.UUID, owner, fi.UUID)}
+		}
+	}
+
+	alternativeUUIDs := []string{}
+	for alternativeUUID := range alternativeOf {
+		alternativeUUIDs = append(alternativeUUIDs, alternativeUUID)
+	}
+
+	results := []struct {
+		UUID string `json:"uuid"`
+	}{}
+
+	query := &neoism.CypherQuery{
+		Statement: `MATCH (other:FinancialInstrument)
+				WHERE other.uuid IN {uuids}
+				RETURN other.uuid as uuid`,
+		Parameters: map[string]interface{}{
+			"uuids": alternativeUUIDs,
+		},
+		Result: &results,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return err
+	}
+
+	if len(results) > 0 {
+		other := results[0].UUID
+		return ConflictError{fmt.Sprintf("Alternative uuid %s of financial instrument %s is the uuid of financial instrument %s", other, alternativeOf[other], other)}
+	}
+	return nil
+}
+
+//resolveIssuers looks up the distinct IssuedBy values of fis in a single transaction,
+//returning the uuid of the Thing each identifies, for those that identify one.
+//A value that no identifier has but is the uuid of a Thing, e.g. one written without a UPPIdentifier, resolves to that Thing.
+func (s service) resolveIssuers(fis []financialInstrument) (map[string]string, error) {
+	issuers := map[string]string{}
+
+	values := []string{}
+	seen := map[string]bool{}
+	for _, fi := range fis {
+		if fi.IssuedBy != "" && !seen[fi.IssuedBy] {
+			seen[fi.IssuedBy] = true
+			values = append(values, fi.IssuedBy)
+		}
+	}
+	if len(values) == 0 {
+		return issuers, nil
+	}
+
+	orgResults := []struct {
+		Value    string `json:"value"`
+		UUID     string `json:"uuid"`
+		IsIssuer bool   `json:"isIssuer"`
+	}{}
+
+	isIssuer := ""
+	if s.issuerLabel != "" {
+		isIssuer = ", org:" + quoteLabel(s.issuerLabel) + " as isIssuer"
+	}
+	findOrganisationsQuery := &neoism.CypherQuery{
+		Statement: fmt.Sprintf(`MATCH (i:Identifier)-[:IDENTIFIES]->(org:Thing)
+				WHERE i.value IN {values}
+				RETURN i.value as value, org.uuid as uuid%s`, isIssuer),
+		Parameters: map[string]interface{}{
+			"values": values,
+		},
+		Result: &orgResults,
+	}
+
+	thingResults := []struct {
+		Value    string `json:"value"`
+		UUID     string `json:"uuid"`
+		IsIssuer bool   `json:"isIssuer"`
+	}{}
+	findThingsQuery := &neoism.CypherQuery{
+		Statement: fmt.Sprintf(`MATCH (org:Thing)
+				WHERE org.uuid IN {values}
+				RETURN org.uuid as value, org.uuid as uuid%s`, isIssuer),
+		Parameters: map[string]interface{}{
+			"values": values,
+		},
+		Result: &thingResults,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{findOrganisationsQuery, findThingsQuery}); err != nil {
+		return nil, err
+	}
+
+	// Identifiers take precedence, so a Thing is only resolved by its uuid if no identifier has the value
+	identified := map[string]bool{}
+	for _, result := range orgResults {
+		identified[result.Value] = true
+	}
+	for _, result := range thingResults {
+		if !identified[result.Value] {
+			orgResults = append(orgResults, result)
+		}
+	}
+
+	others := map[string]string{}
+	for _, result := range orgResults {
+		if s.issuerLabel != "" && !result.IsIssuer {
+			others[result.Value] = result.UUID
+		} else if _, ok := issuers[result.Value]; !ok {
+			issuers[result.Value] = result.UUID
+		}
+	}
+	for value, other := range others {
+		if _, ok := issuers[value]; !ok {
+			return nil, requestError{fmt.Sprintf("Issuer %s identifies %s, which isn't labelled %s", value, other, s.issuerLabel)}
+		}
+	}
+	return issuers, nil
+}
+
+//lastModified is the value of the lastModified property of a financial instrument written at t, in milliseconds since the epoch
+func lastModified(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+//writeQueries builds the queries that write fi, linking it to the issuer uuid resolved for its IssuedBy in issuers if there is one.
+//Its identifiers are left out unless withIdentifiers is true.
+func (s service) writeQueries(fi financialInstrument, opts WriteOptions, issuers map[string]string, withIdentifiers bool) ([]*neoism.CypherQuery, error) {
+	hash, err := hashOf(fi)
+	if err != nil {
+		return nil, err
+	}
+
+	params := map[string]interface{}{
+		"uuid":         fi.UUID,
+		"hash":         hash,
+		"lastModified": lastModified(time.Now()),
+	}
+
+	if fi.LastModified != nil {
+		params["lastModified"] = lastModified(*fi.LastModified)
+	}
+
+	if fi.PrefLabel != "" {
+		params["prefLabel"] = fi.PrefLabel
+	}
+
+	if len(fi.Aliases) > 0 {
+		params["aliases"] = fi.Aliases
+	}
+
+	if fi.Currency != "" {
+		params["currency"] = fi.Currency
+	}
+
+	if fi.MICCode != "" {
+		params["micCode"] = fi.MICCode
+	}
+
+	if fi.Sector != "" {
+		params["sector"] = fi.Sector
+	}
+
+	if fi.CountryOfRisk != "" {
+		params["countryOfRisk"] = fi.CountryOfRisk
+	}
+
+	if fi.IsTest {
+		params["isTest"] = true
+	}
+
+	if fi.Source != "" {
+		params["source"] = fi.Source
+	}
+
+	if fi.TrustLevel > 0 {
+		params["trustLevel"] = fi.TrustLevel
+	}
+
+	if fi.IssueDate != "" {
+		params["issueDate"] = fi.IssueDate
+	}
+
+	if fi.Status != "" {
+		params["status"] = fi.Status
+	}
+
+	if fi.PrimaryIdentifierType != "" {
+		params["primaryIdentifierType"] = fi.PrimaryIdentifierType
+	}
+
+	if opts.IdempotencyKey != "" {
+		params["idempotencyKey"] = opts.IdempotencyKey
+	}
+
+	queries := []*neoism.CypherQuery{}
+
+	if !opts.PreserveRelationships {
+		deleteEntityRelationshipsQuery := &neoism.CypherQuery{
+			Statement: `MATCH (t:Thing {uuid:{uuid}})
+				OPTIONAL MATCH (t)-[is:ISSUED_BY]->(org:Thing)
+				OPTIONAL MATCH (i:Identifier)-[ir:IDENTIFIES]->(t)
+				OPTIONAL MATCH (t)-[tw:TAGGED_WITH]->(topic:Thing)
+				DELETE ir, is, i, tw`,
+			Parameters: map[string]interface{}{
+				"uuid": fi.UUID,
+			},
+		}
+		queries = append(queries, deleteEntityRelationshipsQuery)
+	}
+
+	writeQuery := &neoism.CypherQuery{
+		Statement: `MERGE (t:Thing{uuid: {uuid}})
+			set t={props}
+			set t :Concept
+			set t :FinancialInstrument`,
+		Parameters: map[string]interface{}{
+			"uuid":  fi.UUID,
+			"props": params,
+		},
+	}
+	queries = append(queries, writeQuery)
+	// Empty identifiers are skipped, so in replace mode an identifier omitted or blanked in the payload
+	// is removed along with the rest by deleteEntityRelationshipsQuery and simply not recreated
+	if withIdentifiers {
+		queries = append(queries, s.getIdentifierQueries(fi, identifierQueryFor(opts))...)
+	}
+
+	if fi.IssuedBy != "" {
+		orgUUID := fi.IssuedBy
+		if resolved, ok := issuers[fi.IssuedBy]; ok {
+			orgUUID = resolved
+		} else if opts.RequireExistingIssuer {
+			return nil, requestError{fmt.Sprintf("Issuer %s of financial instrument %s doesn't identify an existing Thing, nor is it the uuid of one", fi.IssuedBy, fi.UUID)}
+		}
+		if orgUUID == fi.UUID {
+			return nil, requestError{fmt.Sprintf("Financial instrument %s cannot be issued by itself, but issuer %s identifies it", fi.UUID, fi.IssuedBy)}
+		}
+
+		onCreate := ""
+		if s.issuerLabel != "" {
+			onCreate = " ON CREATE SET o:" + quoteLabel(s.issuerLabel)
+		}
+		parameters := map[string]interface{}{
+			"uuid":    fi.UUID,
+			"orgUuid": orgUUID,
+		}
+		issuedBy := "MERGE (fi)-[:ISSUED_BY]->(o)"
+		if properties, ok := fi.RelationshipProperties["ISSUED_BY"]; ok {
+			if properties == nil {
+				properties = map[string]interface{}{}
+			}
+			issuedBy = `MERGE (fi)-[issuedBy:ISSUED_BY]->(o)
+					SET issuedBy = {issuedByProperties}`
+			parameters["issuedByProperties"] = properties
+		}
+		organizationRelationshipQuery := &neoism.CypherQuery{
+			// The issuer is merged by uuid, so an existing Thing without a UPPIdentifier is given one rather than duplicated
+			Statement: fmt.Sprintf(`MERGE (fi:Thing {uuid: {uuid}})
+					MERGE (o:Thing {uuid:{orgUuid}})%s
+					MERGE (orgUpp:Identifier:%s{value:{orgUuid}})
+					MERGE (orgUpp)-[:IDENTIFIES]->(o)
+					%s`, onCreate, s.label(uppIdentifierLabel), issuedBy),
+			Parameters: parameters,
+		}
+		queries = append(queries, organizationRelationshipQuery)
+	}
+
+	for _, topicUUID := range fi.Tags {
+		if topicUUID == "" {
+			continue
+		}
+		topicRelationshipQuery := &neoism.CypherQuery{
+			// The topic is merged by uuid, as the issuer is, so an existing Thing without a UPPIdentifier is given one rather than duplicated
+			Statement: fmt.Sprintf(`MERGE (fi:Thing {uuid: {uuid}})
+					MERGE (topic:Thing {uuid:{topicUuid}})
+					MERGE (topicUpp:Identifier:%s{value:{topicUuid}})
+					MERGE (topicUpp)-[:IDENTIFIES]->(topic)
+					MERGE (fi)-[:TAGGED_WITH]->(topic)`, s.label(uppIdentifierLabel)),
+			Parameters: map[string]interface{}{
+				"uuid":      fi.UUID,
+				"topicUuid": topicUUID,
+			},
+		}
+		queries = append(queries, topicRelationshipQuery)
+	}
+
+	return queries, nil
+}
+
+//financialInstrumentProperties are the properties of a node that only describe the financial instrument,
+//which Delete removes from a node that is also another type of concept
+var financialInstrumentProperties = []string{"hash", "lastModified", "aliases", "currency", "micCode", "sector", "countryOfRisk",
+	"isTest", "source", "trustLevel", "issueDate", "status", "primaryIdentifierType", "idempotencyKey"}
+
+//Delete deletes the financial instrument with the given uuid, with its identifiers and relationships, returning whether there was one.
+//If the node is also another type of concept, e.g. an Organisation in a merged graph, only the FinancialInstrument label,
+//the financial instrument's properties and its identifiers other than UPP uuids are removed, leaving the other concept intact.
+func (s service) Delete(uuid string, transactionID string) (bool, error) {
+	stats, err := s.DeleteWithOptions(uuid, transactionID, DeleteOptions{})
+	return stats.Found, err
+}
+
+//DeleteOptions changes what DeleteWithOptions does to the financial instrument's Thing
+type DeleteOptions struct {
+	// Force detach deletes the Thing, with all of its identifiers and relationships, even if other nodes still refer to it
+	// or it is also another type of concept. Without it, the Thing is only deleted once nothing else refers to it, as Delete does.
+	Force bool
+}
+
+//DeleteStats describes what DeleteWithOptions did
+type DeleteStats struct {
+	// Found is whether there was a financial instrument with the uuid
+	Found bool
+	// NodeDeleted is whether the Thing itself was deleted, rather than kept because it is still referenced
+	NodeDeleted bool
+	// RelationshipsDeleted is how many relationships were deleted, including those of deleted identifiers
+	RelationshipsDeleted int
+}
+
+//DeleteWithOptions deletes the financial instrument with the given uuid as Delete does, with the behaviour modified by opts
+func (s service) DeleteWithOptions(uuid string, transactionID string, opts DeleteOptions) (DeleteStats, error) {
+	end, err := s.begin("delete")
+	if err != nil {
+		return DeleteStats{}, err
+	}
+	defer end()
+
+	var stats DeleteStats
+	if opts.Force {
+		stats, err = s.forceDelete(uuid)
+	} else {
+		stats, err = s.safeDelete(uuid)
+	}
+	if err != nil {
+		return DeleteStats{}, err
+	}
+
+	if stats.Found {
+		s.changed(AuditRecord{Operation: auditDelete, UUID: uuid, TransactionID: transactionID})
+	}
+	return stats, nil
+}
+
+//forceDelete detach deletes the financial instrument's Thing and all of its identifiers
+func (s service) forceDelete(uuid string) (DeleteStats, error) {
+	deleteNode := &neoism.CypherQuery{
+		Statement: `MATCH (t:FinancialInstrument {uuid: {uuid}})
+				OPTIONAL MATCH (t)<-[:IDENTIFIES]-(i:Identifier)
+				DETACH DELETE i, t`,
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		IncludeStats: true,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{deleteNode}); err != nil {
+		return DeleteStats{}, err
+	}
+	s.mirror([]*neoism.CypherQuery{deleteNode})
+
+	stats, err := deleteNode.Stats()
+	if err != nil {
+		return DeleteStats{}, err
+	}
+	found := stats.ContainsUpdates && stats.NodesDeleted > 0
+	return DeleteStats{Found: found, NodeDeleted: found, RelationshipsDeleted: stats.RelationshipDeleted}, nil
+}
+
+//safeDelete removes the financial instrument from its Thing, only deleting the Thing once nothing else refers to it
+func (s service) safeDelete(uuid string) (DeleteStats, error) {
+	removeProperties := make([]string, 0, len(financialInstrumentProperties))
+	for _, property := range financialInstrumentProperties {
+		removeProperties = append(removeProperties, "t."+property)
+	}
+
+	clearNode := &neoism.CypherQuery{
+		Statement: fmt.Sprintf(`MATCH (t:FinancialInstrument {uuid: {uuid}})
+				REMOVE t:FinancialInstrument
+				WITH t, size([label IN labels(t) WHERE NOT label IN ['Thing', 'Concept']]) = 0 as onlyFinancialInstrument
+				OPTIONAL MATCH (t)-[is:ISSUED_BY]->(org:Thing)
+				OPTIONAL MATCH (t)<-[ir:IDENTIFIES]-(i:Identifier) WHERE onlyFinancialInstrument OR NOT i:%s
+				OPTIONAL MATCH (t)-[tw:TAGGED_WITH]->(topic:Thing)
+				DELETE is, ir, i, tw
+				WITH DISTINCT t, onlyFinancialInstrument
+				FOREACH (x IN CASE WHEN onlyFinancialInstrument THEN [1] ELSE [] END | REMOVE t:Concept SET t={props})
+				FOREACH (x IN CASE WHEN onlyFinancialInstrument THEN [] ELSE [1] END | REMOVE %s)`,
+			s.label(uppIdentifierLabel), strings.Join(removeProperties, ", ")),
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+			"props": map[string]interface{}{
+				"uuid": uuid,
+			},
+		},
+		IncludeStats: true,
+	}
+
+	removeNodeIfUnused := &neoism.CypherQuery{
+		Statement: `MATCH (t:Thing {uuid: {uuid}})
+				WHERE NOT t:Concept
+				OPTIONAL MATCH (t)-[a]-(x)
+				WITH t, count(a) AS relCount
+				WHERE relCount = 0
+				DELETE t`,
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		IncludeStats: true,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{clearNode, removeNodeIfUnused}); err != nil {
+		return DeleteStats{}, err
+	}
+	s.mirror([]*neoism.CypherQuery{clearNode, removeNodeIfUnused})
+
+	clearStats, err := clearNode.Stats()
+	if err != nil {
+		return DeleteStats{}, err
+	}
+	removeStats, err := removeNodeIfUnused.Stats()
+	if err != nil {
+		return DeleteStats{}, err
+	}
+
+	return DeleteStats{
+		Found:                clearStats.ContainsUpdates && clearStats.LabelsRemoved > 0,
+		NodeDeleted:          removeStats.NodesDeleted > 0,
+		RelationshipsDeleted: clearStats.RelationshipDeleted + removeStats.RelationshipDeleted,
+	}, nil
+}
+
+//ListOptions changes which financial instruments CountWithOptions and IDsWithOptions include
+type ListOptions struct {
+	// ExcludeTest leaves out financial instruments marked as test/sandbox data
+	ExcludeTest bool
+}
+
+//where returns the WHERE clause, if any, restricting fi to the financial instruments these options include
+func (opts ListOptions) where() string {
+	if opts.ExcludeTest {
+		return `WHERE ` + opts.includes()
+	}
+	return ""
+}
+
+//includes returns a predicate that is true when fi is one of the financial instruments these options include
+func (opts ListOptions) includes() string {
+	if opts.ExcludeTest {
+		return `NOT coalesce(fi.isTest, false)`
+	}
+	return `true`
+}
+
+//RepairBaseLabels adds the Thing and Concept labels to a page of the FinancialInstrument nodes missing either of them,
+//ordered by uuid, returning how many were repaired. Repaired nodes no longer match, so it can be called
+//repeatedly with skip 0 until it returns 0, and repeating an interrupted run is safe.
+func (s service) RepairBaseLabels(skip int, limit int) (int, error) {
+	end, err := s.begin("repair")
+	if err != nil {
+		return 0, err
+	}
+	defer end()
+	if err := validatePage(skip, limit); err != nil {
+		return 0, err
+	}
+
+	results := []struct {
+		Count int `json:"count"`
+	}{}
+
+	query := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument) WHERE NOT fi:Thing OR NOT fi:Concept
+				WITH fi ORDER BY fi.uuid SKIP {skip} LIMIT {limit}
+				SET fi :Thing:Concept
+				RETURN count(fi) as count`,
+		Parameters: map[string]interface{}{
+			"skip":  skip,
+			"limit": limit,
+		},
+		Result: &results,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return 0, err
+	}
+	s.mirror([]*neoism.CypherQuery{query})
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Count, nil
+}
+
+//RepairIdentifierLabels adds the Identifier label to a page of the nodes with an identifier type label but not the Identifier label,
+//ordered by value, returning how many were repaired. Delete only matches identifiers by the Identifier label, so it leaves such nodes behind.
+//Repaired nodes no longer match, so it can be called repeatedly with skip 0 until it returns 0, and repeating an interrupted run is safe.
+func (s service) RepairIdentifierLabels(skip int, limit int) (int, error) {
+	end, err := s.begin("repair")
+	if err != nil {
+		return 0, err
+	}
+	defer end()
+	if err := validatePage(skip, limit); err != nil {
+		return 0, err
+	}
+
+	typed := []string{}
+	for _, identifierType := range identifierTypes {
+		typed = append(typed, "i:"+s.label(identifierType))
+	}
+
+	results := []struct {
+		Count int `json:"count"`
+	}{}
+
+	query := &neoism.CypherQuery{
+		Statement: fmt.Sprintf(`MATCH (i) WHERE (%s) AND NOT i:Identifier
+				WITH i ORDER BY i.value SKIP {skip} LIMIT {limit}
+				SET i :Identifier
+				RETURN count(i) as count`, strings.Join(typed, " OR ")),
+		Parameters: map[string]interface{}{
+			"skip":  skip,
+			"limit": limit,
+		},
+		Result: &results,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return 0, err
+	}
+	s.mirror([]*neoism.CypherQuery{query})
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Count, nil
+}
+
+//RecomputeHashes recomputes the hash of each of a page of the financial instruments, ordered by uuid, from the financial instrument
+//as it is read, and stores it in place of the stale ones, returning how many financial instruments were in the page.
+//Nothing else about them is changed, not even lastModified, so once the hash algorithm has changed it can be called
+//with increasing skip until it returns less than limit, and repeating an interrupted run is safe.
+func (s service) RecomputeHashes(skip int, limit int) (int, error) {
+	end, err := s.begin("recompute hashes of")
+	if err != nil {
+		return 0, err
+	}
+	defer end()
+
+	fis, err := s.readPage(`MATCH (fi:FinancialInstrument)`, nil, skip, limit)
+	if err != nil || len(fis) == 0 {
+		return 0, err
+	}
+
+	if err := s.storeHashes(fis, false); err != nil {
+		return 0, err
+	}
+	return len(fis), nil
+}
+
+//BackfillHashes stores a hash for each of a page of the financial instruments without one, ordered by uuid,
+//computed from the financial instrument as it is read, and returns how many were in the page. Nothing else about them is changed.
+//As those given a hash no longer match, it can be called with skip 0 until it returns 0. A hash is only stored
+//if there still isn't one, so one stored by a concurrent write is kept, and repeating a run is safe.
+func (s service) BackfillHashes(skip int, limit int) (int, error) {
+	end, err := s.begin("backfill hashes of")
+	if err != nil {
+		return 0, err
+	}
+	defer end()
+
+	fis, err := s.readPage(`MATCH (fi:FinancialInstrument)
+				WHERE fi.hash IS NULL`, nil, skip, limit)
+	if err != nil || len(fis) == 0 {
+		return 0, err
+	}
+
+	if err := s.storeHashes(fis, true); err != nil {
+		return 0, err
+	}
+	return len(fis), nil
+}
+
+//storeHashes stores the hash of each of fis, as they were read, in place of those that are stale,
+//or only where there is no hash at all if onlyMissing is true
+func (s service) storeHashes(fis []financialInstrument, onlyMissing bool) error {
+	hashes := make([]map[string]interface{}, 0, len(fis))
+	for _, fi := range fis {
+		hash, err := hashOf(fi)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, map[string]interface{}{"uuid": fi.UUID, "hash": hash})
+	}
+
+	condition := "fi.hash IS NULL OR fi.hash <> h.hash"
+	if onlyMissing {
+		condition = "fi.hash IS NULL"
+	}
+	query := &neoism.CypherQuery{
+		Statement: `UNWIND {hashes} as h
+				MATCH (fi:FinancialInstrument {uuid:h.uuid})
+				WHERE ` + condition + `
+				SET fi.hash = h.hash`,
+		Parameters: map[string]interface{}{
+			"hashes": hashes,
+		},
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return err
+	}
+	s.mirror([]*neoism.CypherQuery{query})
+	return nil
+}
+
+//RepointIssuers moves the ISSUED_BY relationships of financial instruments issued by each key of mapping, an issuer uuid,
+//to the issuer with the uuid it maps to, keeping the properties of the relationships, and returns how many were moved.
+//A new issuer that doesn't exist yet is created as Write creates one. The relationships are moved batchSize at a time, each batch
+//in its own transaction, and the hashes of the financial instruments moved are updated, so an interrupted run can simply be repeated.
+//A mapping where an issuer is both repointed and repointed to, a chain or a swap, is rejected, as its result would depend on
+//the order the issuers are repointed in.
+func (s service) RepointIssuers(mapping map[string]string) (int, error) {
+	end, err := s.begin("repoint issuers of")
+	if err != nil {
+		return 0, err
+	}
+	defer end()
+
+	oldUUIDs := make([]string, 0, len(mapping))
+	for oldUUID, newUUID := range mapping {
+		if err := validateUUID(oldUUID); err != nil {
+			return 0, err
+		}
+		if err := validateUUID(newUUID); err != nil {
+			return 0, err
+		}
+		if oldUUID == newUUID {
+			return 0, requestError{fmt.Sprintf("Issuer %s cannot be repointed to itself", oldUUID)}
+		}
+		if _, repointed := mapping[newUUID]; repointed {
+			return 0, requestError{fmt.Sprintf("Issuer %s cannot be repointed to %s, which is itself repointed", oldUUID, newUUID)}
+		}
+		oldUUIDs = append(oldUUIDs, oldUUID)
+	}
+	sort.Strings(oldUUIDs)
+
+	onCreate := ""
+	if s.issuerLabel != "" {
+		onCreate = " ON CREATE SET o:" + quoteLabel(s.issuerLabel)
+	}
+	// The new issuer is merged by uuid, as in writeQueries, so an existing Thing without a UPPIdentifier is given one rather than duplicated
+	repoint := fmt.Sprintf(`MERGE (o:Thing {uuid:{newUuid}})%s
+					MERGE (orgUpp:Identifier:%s{value:{newUuid}})
+					MERGE (orgUpp)-[:IDENTIFIES]->(o)
+					MERGE (fi)-[issuedBy:ISSUED_BY]->(o)
+					SET issuedBy += properties(old)
+					DELETE old
+					RETURN fi.uuid as uuid`, onCreate, s.label(uppIdentifierLabel))
+
+	moved := 0
+	for _, oldUUID := range oldUUIDs {
+		for {
+			results := []struct {
+				UUID string `json:"uuid"`
+			}{}
+
+			query := &neoism.CypherQuery{
+				Statement: `MATCH (fi:FinancialInstrument)-[old:ISSUED_BY]->(:Thing {uuid:{oldUuid}})
+					WITH fi, old LIMIT {limit}
+					` + repoint,
+				Parameters: map[string]interface{}{
+					"oldUuid": oldUUID,
+					"newUuid": mapping[oldUUID],
+					"limit":   batchSize,
+				},
+				Result: &results,
+			}
+
+			if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+				return moved, err
+			}
+			if len(results) == 0 {
+				break
+			}
+			moved += len(results)
+
+			uuids := make([]string, 0, len(results))
+			for _, result := range results {
+				uuids = append(uuids, result.UUID)
+			}
+			// The secondary is given the financial instruments moved by uuid, as LIMIT could pick others there
+			s.mirror([]*neoism.CypherQuery{{
+				Statement: `MATCH (fi:FinancialInstrument)-[old:ISSUED_BY]->(:Thing {uuid:{oldUuid}})
+					WHERE fi.uuid IN {uuids}
+					` + repoint,
+				Parameters: map[string]interface{}{
+					"oldUuid": oldUUID,
+					"newUuid": mapping[oldUUID],
+					"uuids":   uuids,
+				},
+			}})
+			fis, err := s.readPage(`MATCH (fi:FinancialInstrument)
+					WHERE fi.uuid IN {uuids}`, map[string]interface{}{"uuids": uuids}, 0, len(uuids))
+			if err != nil {
+				return moved, err
+			}
+			if err := s.storeHashes(fis, false); err != nil {
+				return moved, err
+			}
+			for i := range fis {
+				s.changed(AuditRecord{Operation: auditWrite, UUID: fis[i].UUID, Payload: &fis[i], Options: &WriteOptions{}})
+			}
+
+			if len(results) < batchSize {
+				break
+			}
+		}
+	}
+	return moved, nil
+}
+
+//DeleteBySource deletes, as Delete does, every financial instrument written with the given source, returning how many were deleted.
+//UUIDs are fetched a page at a time, and as deleted financial instruments no longer match,
+//an interrupted run can simply be repeated to delete the rest.
+func (s service) DeleteBySource(source string) (int, error) {
+	end, err := s.begin("delete")
+	if err != nil {
+		return 0, err
+	}
+	defer end()
+
+	if source == "" {
+		return 0, requestError{"A source is required to delete by source"}
+	}
+
+	return s.deleteMatching(`MATCH (fi:FinancialInstrument {source:{source}})`, map[string]interface{}{"source": source},
+		"from source "+source)
+}
+
+//PruneOlderThan deletes, as DeleteBySource does, every financial instrument written with the given source
+//that was last modified before t, returning how many were deleted. It is for feeds that send full snapshots,
+//where anything the latest snapshot didn't write has been withdrawn. Financial instruments without a lastModified aren't deleted.
+func (s service) PruneOlderThan(source string, t time.Time) (int, error) {
+	end, err := s.begin("prune")
+	if err != nil {
+		return 0, err
+	}
+	defer end()
+
+	if source == "" {
+		return 0, requestError{"A source is required to prune by source"}
+	}
+
+	return s.deleteMatching(`MATCH (fi:FinancialInstrument {source:{source}})
+					WHERE fi.lastModified < {before}`, map[string]interface{}{"source": source, "before": lastModified(t)},
+		fmt.Sprintf("from source %s modified before %s", source, t.Format(time.RFC3339)))
+}
+
+//deleteMatching deletes, as Delete does, each financial instrument match binds to fi, a page at a time, returning how many were deleted.
+//As deleted financial instruments no longer match, each page is fetched from the start. description says which are being
+//deleted in the error returned if none of a page could be, which would otherwise make it loop forever.
+func (s service) deleteMatching(match string, params map[string]interface{}, description string) (int, error) {
+	parameters := map[string]interface{}{
+		"limit": batchSize,
+	}
+	for name, value := range params {
+		parameters[name] = value
+	}
+
+	deleted := 0
+	for {
+		results := []struct {
+			UUID string `json:"uuid"`
+		}{}
+
+		query := &neoism.CypherQuery{
+			Statement: match + `
+					RETURN fi.uuid as uuid LIMIT {limit}`,
+			Parameters: parameters,
+			Result:     &results,
+		}
+
+		if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+			return deleted, err
+		}
+		if len(results) == 0 {
+			return deleted, nil
+		}
+
+		deletedFromPage := 0
+		for _, result := range results {
+			found, err := s.Delete(result.UUID, "")
+			if err != nil {
+				return deleted, err
+			}
+			if found {
+				deletedFromPage++
+			}
+		}
+		if deletedFromPage == 0 {
+			return deleted, fmt.Errorf("None of %d financial instruments %s could be deleted", len(results), description)
+		}
+		deleted += deletedFromPage
+	}
+}
+
+func (s service) Count() (int, error) {
+	return s.CountWithOptions(ListOptions{})
+}
+
+//CountWithOptions returns the number of financial instruments, restricted by opts
+func (s service) CountWithOptions(opts ListOptions) (int, error) {
+	if s.countPageSize > 0 {
+		return s.pagedCount(opts)
+	}
+
+	results := []struct {
+		Count int `json:"count"`
+	}{}
+
+	query := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument) ` + opts.where() + ` return count(fi) as count`,
+		Result:    &results,
+	}
+	err := s.cypherBatch([]*neoism.CypherQuery{query})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return results[0].Count, nil
+}
+
+//CountChangedBetween returns the number of financial instruments last modified at or after from and before to,
+//so that consecutive windows, e.g. days, don't count any financial instrument twice. It uses the lastModified index.
+func (s service) CountChangedBetween(from time.Time, to time.Time) (int, error) {
+	results := []struct {
+		Count int `json:"count"`
+	}{}
+
+	query := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument)
+				WHERE fi.lastModified >= {from} AND fi.lastModified < {to}
+				RETURN count(fi) as count`,
+		Parameters: map[string]interface{}{
+			"from": lastModified(from),
+			"to":   lastModified(to),
+		},
+		Result: &results,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return 0, err
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Count, nil
+}
+
+//pagedCount counts the financial instruments restricted by opts a page of countPageSize at a time, in uuid order,
+//so each query only has to count one page
+func (s service) pagedCount(opts ListOptions) (int, error) {
+	total := 0
+	after := ""
+	for {
+		results := []struct {
+			Scanned int    `json:"scanned"`
+			Last    string `json:"last"`
+			Count   int    `json:"count"`
+		}{}
+
+		query := &neoism.CypherQuery{
+			Statement: `MATCH (fi:FinancialInstrument) WHERE fi.uuid > {after}
+				WITH fi ORDER BY fi.uuid LIMIT {limit}
+				RETURN count(fi) as scanned, max(fi.uuid) as last, sum(CASE WHEN ` + opts.includes() + ` THEN 1 ELSE 0 END) as count`,
+			Parameters: map[string]interface{}{
+				"after": after,
+				"limit": s.countPageSize,
+			},
+			Result: &results,
+		}
+		if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+			return 0, err
+		}
+		if len(results) == 0 {
+			return total, nil
+		}
+
+		total += results[0].Count
+		if results[0].Scanned < s.countPageSize {
+			return total, nil
+		}
+		after = results[0].Last
+	}
+}
+
+//CountIdentifiers returns the number of identifier nodes of each type, keyed by the identifier type (see identifierTypes).
+//Identifier nodes are counted by their type label alone, so a node labelled both Identifier and FIGIIdentifier is counted once.
+func (s service) CountIdentifiers() (map[string]int, error) {
+	results := make([][]struct {
+		Count int `json:"count"`
+	}, len(identifierTypes))
+
+	queries := []*neoism.CypherQuery{}
+	for i, identifierType := range identifierTypes {
+		queries = append(queries, &neoism.CypherQuery{
+			Statement: fmt.Sprintf(`MATCH (i:%s) return count(i) as count`, s.label(identifierType)),
+			Result:    &results[i],
+		})
+	}
+
+	if err := s.cypherBatch(queries); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for i, identifierType := range identifierTypes {
+		if len(results[i]) > 0 {
+			counts[identifierType] = results[i][0].Count
+		}
+	}
+	return counts, nil
+}
+
+//IdentifierCoverage returns, keyed by identifier type (see identifierTypes), the fraction of financial instruments that have
+//at least one identifier of that type, or 0 for each if there are no financial instruments. It uses a single query,
+//so the fractions are of the same point-in-time snapshot, which may be out of date as soon as it is returned.
+func (s service) IdentifierCoverage() (map[string]float64, error) {
+	covered := make([]string, 0, len(identifierTypes))
+	for _, identifierType := range identifierTypes {
+		covered = append(covered, fmt.Sprintf(`sum(CASE WHEN (fi)<-[:IDENTIFIES]-(:%s) THEN 1 ELSE 0 END)`, s.label(identifierType)))
+	}
+
+	results := []struct {
+		Total   int   `json:"total"`
+		Covered []int `json:"covered"`
+	}{}
+
+	query := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument)
+				RETURN count(fi) as total, [` + strings.Join(covered, ", ") + `] as covered`,
+		Result: &results,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return nil, err
+	}
+
+	coverage := map[string]float64{}
+	for i, identifierType := range identifierTypes {
+		coverage[identifierType] = 0
+		if len(results) > 0 && results[0].Total > 0 && i < len(results[0].Covered) {
+			coverage[identifierType] = float64(results[0].Covered[i]) / float64(results[0].Total)
+		}
+	}
+	return coverage, nil
+}
+
+//RelationshipCounts returns the number of relationships of each of relationshipTypes to or from financial instruments,
+//keyed by relationship type. The counts are not taken from a single snapshot, so are approximate while writes are happening.
+func (s service) RelationshipCounts() (map[string]int, error) {
+	results := []struct {
+		Type  string `json:"type"`
+		Count int    `json:"count"`
+	}{}
+
+	query := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument)-[r:ISSUED_BY|TAGGED_WITH]->()
+				RETURN type(r) as type, count(r) as count
+				UNION ALL
+				MATCH (fi:FinancialInstrument)<-[r:IDENTIFIES|WAS_IDENTIFIER_OF]-()
+				RETURN type(r) as type, count(r) as count`,
+		Result: &results,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, relationshipType := range relationshipTypes {
+		counts[relationshipType] = 0
+	}
+	for _, result := range results {
+		counts[result.Type] += result.Count
+	}
+	return counts, nil
+}
+
+func (s service) DecodeJSON(dec *json.Decoder) (interface{}, string, error) {
+	fi, err := decodeFinancialInstrument(dec)
+	return fi, fi.UUID, err
+}
+
+//decodeFinancialInstrument decodes the next financial instrument from dec. Its issuedBy can be either the issuer's uuid,
+//or an issuer with the properties of the ISSUED_BY relationship, which are decoded into RelationshipProperties.
+func decodeFinancialInstrument(dec *json.Decoder) (financialInstrument, error) {
+	payload := struct {
+		financialInstrument
+		IssuedBy json.RawMessage `json:"issuedBy,omitempty"`
+	}{}
+	if err := dec.Decode(&payload); err != nil {
+		return financialInstrument{}, err
+	}
+
+	fi := payload.financialInstrument
+	issuedBy := bytes.TrimSpace(payload.IssuedBy)
+	switch {
+	case len(issuedBy) == 0 || bytes.Equal(issuedBy, []byte("null")):
+	case issuedBy[0] == '{':
+		i := issuer{}
+		if err := json.Unmarshal(issuedBy, &i); err != nil {
+			return fi, err
+		}
+		fi.IssuedBy = i.UUID
+		if i.Properties != nil {
+			if fi.RelationshipProperties == nil {
+				fi.RelationshipProperties = map[string]map[string]interface{}{}
+			}
+			fi.RelationshipProperties["ISSUED_BY"] = i.Properties
+		}
+	default:
+		if err := json.Unmarshal(issuedBy, &fi.IssuedBy); err != nil {
+			return fi, err
+		}
+	}
+	return fi, nil
+}
+
+func (s service) IDs(f func(id rwapi.IDEntry) (bool, error)) error {
+	return s.IDsWithOptions(ListOptions{}, f)
+}
+
+//IDsWithOptions calls f with the uuid and hash of each financial instrument, restricted by opts, until f returns false or an error
+func (s service) IDsWithOptions(opts ListOptions, f func(id rwapi.IDEntry) (bool, error)) error {
+
+	for skip := 0; ; skip += batchSize {
+		results := []rwapi.IDEntry{}
+		readQuery := &neoism.CypherQuery{
+			Statement: `MATCH (fi:FinancialInstrument) ` + opts.where() + ` RETURN fi.uuid as id, fi.hash as hash SKIP {skip} LIMIT {limit}`,
+			Parameters: map[string]interface{}{
+				"limit": batchSize,
+				"skip":  skip,
+			},
+			Result: &results,
+		}
+
+		if err := s.cypherBatch([]*neoism.CypherQuery{readQuery}); err != nil {
+			return nil
+		}
+		if len(results) == 0 {
+			return nil
+		}
+		for _, result := range results {
+			more, err := f(result)
+			if !more || err != nil {
+				return err
+			}
+		}
+
+	}
+}
+
+//IDsByType calls f with the uuid and hash of each financial instrument with the given type label, one of typeLabels,
+//in uuid order, until f returns false or an error
+func (s service) IDsByType(typeLabel string, f func(id rwapi.IDEntry) (bool, error)) error {
+	if err := validateType(typeLabel); err != nil {
+		return err
+	}
+
+	for skip := 0; ; skip += batchSize {
+		results := []rwapi.IDEntry{}
+		readQuery := &neoism.CypherQuery{
+			Statement: fmt.Sprintf(`MATCH (fi:FinancialInstrument:%s)
+				RETURN fi.uuid as id, fi.hash as hash ORDER BY fi.uuid SKIP {skip} LIMIT {limit}`, typeLabel),
+			Parameters: map[string]interface{}{
+				"limit": batchSize,
+				"skip":  skip,
+			},
+			Result: &results,
+		}
+
+		if err := s.cypherBatch([]*neoism.CypherQuery{readQuery}); err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			return nil
+		}
+		for _, result := range results {
+			more, err := f(result)
+			if !more || err != nil {
+				return err
+			}
+		}
+	}
+}
+
+//ChangedSince calls f with the uuid and hash of each financial instrument last modified at or after since,
+//oldest first, until f returns false or an error
+func (s service) ChangedSince(since time.Time, f func(id rwapi.IDEntry) (bool, error)) error {
+	return s.changedSince(`MATCH (fi:FinancialInstrument)`, nil, since, f)
+}
+
+//ChangedBySourceSince calls f with the uuid and hash of each financial instrument from source last modified at or after since,
+//oldest first, until f returns false or an error, so that a feed can check its writes have landed
+func (s service) ChangedBySourceSince(source string, since time.Time, f func(id rwapi.IDEntry) (bool, error)) error {
+	return s.changedSince(`MATCH (fi:FinancialInstrument {source:{source}})`, map[string]interface{}{"source": source}, since, f)
+}
+
+//changedSince runs match, which must bind fi, and calls f with each of the matching financial instruments last modified
+//at or after since, ordered by lastModified and then uuid
+func (s service) changedSince(match string, params map[string]interface{}, since time.Time, f func(id rwapi.IDEntry) (bool, error)) error {
+	for skip := 0; ; skip += batchSize {
+		parameters := map[string]interface{}{
+			"since": lastModified(since),
+			"limit": batchSize,
+			"skip":  skip,
+		}
+		for name, value := range params {
+			parameters[name] = value
+		}
+
+		results := []rwapi.IDEntry{}
+		readQuery := &neoism.CypherQuery{
+			Statement: match + `
+				WHERE fi.lastModified >= {since}
+				RETURN fi.uuid as id, fi.hash as hash ORDER BY fi.lastModified, fi.uuid SKIP {skip} LIMIT {limit}`,
+			Parameters: parameters,
+			Result:     &results,
+		}
+
+		if err := s.cypherBatch([]*neoism.CypherQuery{readQuery}); err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			return nil
+		}
+		for _, result := range results {
+			more, err := f(result)
+			if !more || err != nil {
+				return err
+			}
+		}
+	}
+}
+
+//VerifyIdentifierCardinality calls f with each financial instrument that has more than one identifier of one of
+//singleValuedIdentifierTypes, with the type and the values it has, until f returns false or an error
+func (s service) VerifyIdentifierCardinality(f func(uuid string, identifierType string, values []string) (bool, error)) error {
+	for _, identifierType := range singleValuedIdentifierTypes {
+		for skip := 0; ; skip += batchSize {
+			results := []struct {
+				UUID   string   `json:"uuid"`
+				Values []string `json:"values"`
+			}{}
+			query := &neoism.CypherQuery{
+				Statement: fmt.Sprintf(`MATCH (fi:FinancialInstrument)<-[:IDENTIFIES]-(i:%s)
+					WITH fi, collect(distinct i.value) as values WHERE size(values) > 1
+					RETURN fi.uuid as uuid, values ORDER BY fi.uuid SKIP {skip} LIMIT {limit}`, s.label(identifierType)),
+				Parameters: map[string]interface{}{
+					"limit": batchSize,
+					"skip":  skip,
+				},
+				Result: &results,
+			}
+
+			if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+				return err
+			}
+			if len(results) == 0 {
+				break
+			}
+			for _, result := range results {
+				sort.Strings(result.Values)
+				more, err := f(result.UUID, identifierType, result.Values)
+				if !more || err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//FindDuplicateByIdentifier calls f with each value of identifiers of the given type that identifies more than one financial instrument,
+//and the sorted uuids of those financial instruments, until f returns false or an error. Such values predate the uniqueness constraints
+//on identifier values, and the financial instruments sharing them are candidates for merging.
+func (s service) FindDuplicateByIdentifier(identifierType string, f func(value string, uuids []string) (bool, error)) error {
+	if err := validateIdentifierType(identifierType); err != nil {
+		return err
+	}
+
+	for skip := 0; ; skip += batchSize {
+		results := []struct {
+			Value string   `json:"value"`
+			UUIDs []string `json:"uuids"`
+		}{}
+		query := &neoism.CypherQuery{
+			Statement: fmt.Sprintf(`MATCH (i:%s)-[:IDENTIFIES]->(fi:FinancialInstrument)
+				WITH i.value as value, collect(distinct fi.uuid) as uuids WHERE size(uuids) > 1
+				RETURN value, uuids ORDER BY value SKIP {skip} LIMIT {limit}`, s.label(identifierType)),
+			Parameters: map[string]interface{}{
+				"limit": batchSize,
+				"skip":  skip,
+			},
+			Result: &results,
+		}
+
+		if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			return nil
+		}
+		for _, result := range results {
+			sort.Strings(result.UUIDs)
+			more, err := f(result.Value, result.UUIDs)
+			if !more || err != nil {
+				return err
+			}
+		}
+	}
+}
+
+//FindMislabelledIdentifiers calls f with the financial instrument uuid, label and value of each identifier whose value
+//doesn't match the format in identifierFormats for its type, until f returns false or an error, as it has probably
+//been written with the wrong type
+func (s service) FindMislabelledIdentifiers(f func(uuid string, label string, value string) (bool, error)) error {
+	for _, identifierType := range identifierTypes {
+		label := s.label(identifierType)
+		for skip := 0; ; skip += batchSize {
+			results := []struct {
+				UUID  string `json:"uuid"`
+				Value string `json:"value"`
+			}{}
+			query := &neoism.CypherQuery{
+				Statement: fmt.Sprintf(`MATCH (i:%s)-[:IDENTIFIES]->(fi:FinancialInstrument)
+					RETURN fi.uuid as uuid, i.value as value ORDER BY fi.uuid, i.value SKIP {skip} LIMIT {limit}`, label),
+				Parameters: map[string]interface{}{
+					"limit": batchSize,
+					"skip":  skip,
+				},
+				Result: &results,
+			}
+
+			if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+				return err
+			}
+			if len(results) == 0 {
+				break
+			}
+			for _, result := range results {
+				if identifierFormats[identifierType].MatchString(result.Value) {
+					continue
+				}
+				more, err := f(result.UUID, label, result.Value)
+				if !more || err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//FindSelfIssued calls f with the uuid of each financial instrument with an ISSUED_BY relationship to itself,
+//until f returns false or an error, so they can be repaired. The pages start after the last uuid found, as ReadAfter's do,
+//so repairing the financial instruments already found doesn't shift the later pages.
+func (s service) FindSelfIssued(f func(uuid string) (bool, error)) error {
+	lastUUID := ""
+	for {
+		results := []struct {
+			UUID string `json:"uuid"`
+		}{}
+		query := &neoism.CypherQuery{
+			Statement: `MATCH (fi:FinancialInstrument)-[:ISSUED_BY]->(fi)
+					WHERE fi.uuid > {lastUUID}
+					RETURN fi.uuid as uuid ORDER BY uuid LIMIT {limit}`,
+			Parameters: map[string]interface{}{
+				"limit":    batchSize,
+				"lastUUID": lastUUID,
+			},
+			Result: &results,
+		}
+
+		if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			return nil
+		}
+		for _, result := range results {
+			more, err := f(result.UUID)
+			if !more || err != nil {
+				return err
+			}
+		}
+		lastUUID = results[len(results)-1].UUID
+	}
+}
+
+//FindIssuedByMissingOrg calls f with the uuid of each financial instrument issued by a Thing that is neither an Organisation nor a Concept,
+//and the uuid of that Thing, until f returns false or an error. Such Things are usually all that is left of a purged organisation,
+//or were created by Write for an issuer that was never written, so the financial instruments can be detached from them.
+func (s service) FindIssuedByMissingOrg(f func(instrumentUUID string, orgUUID string) (bool, error)) error {
+	for skip := 0; ; skip += batchSize {
+		results := []struct {
+			UUID    string `json:"uuid"`
+			OrgUUID string `json:"orgUUID"`
+		}{}
+		query := &neoism.CypherQuery{
+			Statement: `MATCH (fi:FinancialInstrument)-[:ISSUED_BY]->(org:Thing)
+					WHERE NOT org:Organisation AND NOT org:Concept
+					RETURN fi.uuid as uuid, org.uuid as orgUUID ORDER BY uuid, orgUUID SKIP {skip} LIMIT {limit}`,
+			Parameters: map[string]interface{}{
+				"limit": batchSize,
+				"skip":  skip,
+			},
+			Result: &results,
+		}
+
+		if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			return nil
+		}
+		for _, result := range results {
+			more, err := f(result.UUID, result.OrgUUID)
+			if !more || err != nil {
+				return err
+			}
+		}
+	}
+}
+
+//FindIdentifierResolutionLoops calls f with the uuids of each pair of financial instruments that each have the other's uuid
+//as an alternative UPP uuid, until f returns false or an error. Each pair is reported once, with a before b in uuid order.
+//Resolving either uuid of such a pair is ambiguous, so it is usually a sign of corrupted concordance.
+func (s service) FindIdentifierResolutionLoops(f func(a string, b string) (bool, error)) error {
+	for skip := 0; ; skip += batchSize {
+		results := []struct {
+			A string `json:"a"`
+			B string `json:"b"`
+		}{}
+		query := &neoism.CypherQuery{
+			Statement: fmt.Sprintf(`MATCH (alternative:%s)-[:IDENTIFIES]->(a:FinancialInstrument)
+					WHERE alternative.value <> a.uuid
+					MATCH (b:FinancialInstrument {uuid: alternative.value})
+					WHERE a.uuid < b.uuid
+					MATCH (:%[1]s {value: a.uuid})-[:IDENTIFIES]->(b)
+					RETURN DISTINCT a.uuid as a, b.uuid as b ORDER BY a, b SKIP {skip} LIMIT {limit}`, s.label(uppIdentifierLabel)),
+			Parameters: map[string]interface{}{
+				"limit": batchSize,
+				"skip":  skip,
+			},
+			Result: &results,
+		}
+
+		if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			return nil
+		}
+		for _, result := range results {
+			more, err := f(result.A, result.B)
+			if !more || err != nil {
+				return err
+			}
+		}
+	}
+}
+
+//VerifyCanonicalIdentifier calls f with the uuid of each financial instrument without a UPPIdentifier whose value is its own uuid,
+//in uuid order, and whether it has any other UPPIdentifier, until f returns false or an error. The pages start after the last uuid
+//found, as ReadAfter's do, so repairing the financial instruments already found doesn't shift the later pages.
+func (s service) VerifyCanonicalIdentifier(f func(uuid string, found bool) (bool, error)) error {
+	lastUUID := ""
+	for {
+		results := []struct {
+			UUID  string `json:"uuid"`
+			Found bool   `json:"found"`
+		}{}
+		query := &neoism.CypherQuery{
+			Statement: fmt.Sprintf(`MATCH (fi:FinancialInstrument)
+					WHERE fi.uuid > {lastUUID}
+					OPTIONAL MATCH (upp:%s)-[:IDENTIFIES]->(fi)
+					WITH fi, collect(upp.value) as uuids
+					WHERE NOT fi.uuid IN uuids
+					RETURN fi.uuid as uuid, size(uuids) > 0 as found ORDER BY uuid LIMIT {limit}`, s.label(uppIdentifierLabel)),
+			Parameters: map[string]interface{}{
+				"limit":    batchSize,
+				"lastUUID": lastUUID,
+			},
+			Result: &results,
+		}
+
+		if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			return nil
+		}
+		for _, result := range results {
+			more, err := f(result.UUID, result.Found)
+			if !more || err != nil {
+				return err
+			}
+		}
+		lastUUID = results[len(results)-1].UUID
+	}
+}
+
+//RepairCanonicalIdentifier gives a page of the financial instruments VerifyCanonicalIdentifier finds, ordered by uuid,
+//a UPPIdentifier whose value is their uuid, updating their hashes, and returns how many were repaired.
+//Repaired financial instruments no longer match, so it can be called repeatedly with skip 0 until it returns 0,
+//and repeating an interrupted run is safe.
+func (s service) RepairCanonicalIdentifier(skip int, limit int) (int, error) {
+	end, err := s.begin("repair")
+	if err != nil {
+		return 0, err
+	}
+	defer end()
+	if err := validatePage(skip, limit); err != nil {
+		return 0, err
+	}
+
+	results := []struct {
+		UUID string `json:"uuid"`
+	}{}
+
+	query := &neoism.CypherQuery{
+		Statement: fmt.Sprintf(`MATCH (fi:FinancialInstrument)
+				OPTIONAL MATCH (upp:%[1]s)-[:IDENTIFIES]->(fi)
+				WITH fi, collect(upp.value) as uuids
+				WHERE NOT fi.uuid IN uuids
+				WITH fi ORDER BY fi.uuid SKIP {skip} LIMIT {limit}
+				MERGE (i:Identifier:%[1]s {value:fi.uuid})
+				MERGE (i)-[:IDENTIFIES]->(fi)
+				RETURN fi.uuid as uuid`, s.label(uppIdentifierLabel)),
+		Parameters: map[string]interface{}{
+			"skip":  skip,
+			"limit": limit,
+		},
+		Result: &results,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{query}); err != nil {
+		return 0, err
+	}
+	s.mirror([]*neoism.CypherQuery{query})
+	if len(results) == 0 {
+		return 0, nil
+	}
+
+	uuids := make([]string, 0, len(results))
+	for _, result := range results {
+		uuids = append(uuids, result.UUID)
+	}
+	fis, err := s.readPage(`MATCH (fi:FinancialInstrument)
+				WHERE fi.uuid IN {uuids}`, map[string]interface{}{"uuids": uuids}, 0, len(uuids))
+	if err != nil {
+		return 0, err
+	}
+	if err := s.storeHashes(fis, false); err != nil {
+		return 0, err
+	}
+	for i := range fis {
+		s.changed(AuditRecord{Operation: auditWrite, UUID: fis[i].UUID, Payload: &fis[i], Options: &WriteOptions{}})
+	}
+	return len(results), nil
+}
+
+func (s service) Check() error {
+	if s.checkTimeout <= 0 {
+		return neoutils.Check(s.conn)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- neoutils.Check(s.conn)
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(s.checkTimeout):
+		return fmt.Errorf("Neo4j check did not complete within %v", s.checkTimeout)
+	}
 }
@@ -1,13 +1,23 @@
 package financialinstruments
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"github.com/Financial-Times/neo-utils-go/neoutils"
 	"github.com/jmcvetta/neoism"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 	"github.com/Financial-Times/up-rw-app-api-go/rwapi"
 )
 
@@ -20,6 +30,9 @@ const (
 	figiCode = "BBG000Y1HJT8"
 	orgUUID = "4e484678-cf47-4168-b844-6adb47f8eb58"
 	upToDateOrgUUID = "fbe74159-f4a0-4aa0-9cca-c2bbb9e8bffe"
+	topicUUID = "c2b4ffd5-7ce3-4b8a-a4c3-a7cbc1a2b3d6"
+	otherTopicUUID = "0f4c9a0d-3d0b-4f0c-8f9b-9c0c3c1b6f4e"
+	rekeyedFinancialInstrumentUUID = "5d1f0a3c-8b7e-4e2a-9f6d-2c4b8a1e7f30"
 	test_trans_id = "test_tid"
 )
 
@@ -30,6 +43,9 @@ var uuidsToBeDeleted = []string{
 	duplicateFinancialInstrumentUUID,
 	orgUUID,
 	upToDateOrgUUID,
+	topicUUID,
+	otherTopicUUID,
+	rekeyedFinancialInstrumentUUID,
 }
 
 var testFinancialInstrument = financialInstrument{
@@ -141,6 +157,2372 @@ func TestUpdateWillRemoveNoLongerPresentProps(t *testing.T) {
 	readAndCompare(upToDateFinancialInstrument, t, db)
 }
 
+func TestWriteWithBlankedFigiCodeRemovesFigiIdentifier(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	withoutFigi := testFinancialInstrument
+	withoutFigi.AlternativeIdentifiers.FIGICode = ""
+	assert.NoError(cypherDriver.Write(withoutFigi, test_trans_id), "Failed to update financial instrument")
+
+	readAndCompare(withoutFigi, t, db)
+
+	result := []struct {
+		Count int `json:"count"`
+	}{}
+	query := &neoism.CypherQuery{
+		Statement: `MATCH (i:FIGIIdentifier {value:{value}}) RETURN count(i) as count`,
+		Parameters: neoism.Props{
+			"value": figiCode,
+		},
+		Result: &result,
+	}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{query}))
+	assert.Equal(0, result[0].Count, "FIGIIdentifier %s should have been removed", figiCode)
+}
+
+func TestWriteWithPreserveRelationshipsKeepsExistingIdentifiers(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	var withoutIdentifiers = financialInstrument{
+		UUID:      testFinancialInstrumentUUID,
+		PrefLabel: "A&E CAPITAL FUNDING CORP  MULTI-VTG",
+		AlternativeIdentifiers: alternativeIdentifiers{
+			UUIDS: []string{testFinancialInstrumentUUID},
+		},
+	}
+
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(withoutIdentifiers, test_trans_id, WriteOptions{PreserveRelationships: true})), "Failed to update financial instrument")
+
+	expected := testFinancialInstrument
+	expected.PrefLabel = withoutIdentifiers.PrefLabel
+	readAndCompare(expected, t, db)
+}
+
+func TestWriteWithoutPreserveRelationshipsRemovesExistingIdentifiers(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	var withoutIdentifiers = financialInstrument{
+		UUID:      testFinancialInstrumentUUID,
+		PrefLabel: "A&E CAPITAL FUNDING CORP  MULTI-VTG",
+		AlternativeIdentifiers: alternativeIdentifiers{
+			UUIDS: []string{testFinancialInstrumentUUID},
+		},
+	}
+
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(withoutIdentifiers, test_trans_id, WriteOptions{})), "Failed to update financial instrument")
+
+	readAndCompare(withoutIdentifiers, t, db)
+}
+
+func TestWriteWithTags(t *testing.T) {
+	taggedFinancialInstrument := testFinancialInstrument
+	taggedFinancialInstrument.Tags = []string{topicUUID, otherTopicUUID}
+
+	WriteValueAndTestResult(t, taggedFinancialInstrument)
+}
+
+func TestWriteWithTagWithoutIdentifier(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement:  `CREATE (:Thing {uuid:{uuid}})`,
+		Parameters: neoism.Props{"uuid": topicUUID},
+	}}))
+
+	taggedFinancialInstrument := testFinancialInstrument
+	taggedFinancialInstrument.Tags = []string{topicUUID}
+	assert.NoError(cypherDriver.Write(taggedFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	readAndCompare(taggedFinancialInstrument, t, db)
+
+	results := []struct {
+		Things      int `json:"things"`
+		Identifiers int `json:"identifiers"`
+	}{}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement: `MATCH (t:Thing {uuid:{uuid}})
+				OPTIONAL MATCH (t)<-[:IDENTIFIES]-(i:UPPIdentifier)
+				RETURN count(DISTINCT t) as things, count(i) as identifiers`,
+		Parameters: neoism.Props{"uuid": topicUUID},
+		Result:     &results,
+	}}))
+	assert.Equal([]struct {
+		Things      int `json:"things"`
+		Identifiers int `json:"identifiers"`
+	}{{1, 1}}, results, "The existing topic should be given a UPPIdentifier rather than duplicated")
+}
+
+func TestWriteWithAliases(t *testing.T) {
+	aliasedFinancialInstrument := testFinancialInstrument
+	aliasedFinancialInstrument.Aliases = []string{"GREENWICH CAP ACCEPT 91-B", "GCA 1991-B B1"}
+
+	WriteValueAndTestResult(t, aliasedFinancialInstrument)
+}
+
+func TestSearchByAlias(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	aliasedFinancialInstrument := testFinancialInstrument
+	aliasedFinancialInstrument.Aliases = []string{"GREENWICH CAP ACCEPT 91-B", "GCA 1991-B B1"}
+	assert.NoError(cypherDriver.Write(aliasedFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	found, err := cypherDriver.SearchByAlias("ACCEPT", 10)
+	assert.NoError(err)
+	assert.Len(found, 1)
+	assert.Equal(testFinancialInstrumentUUID, found[0].UUID)
+	assert.Equal(aliasedFinancialInstrument.Aliases, found[0].Aliases)
+
+	found, err = cypherDriver.SearchByAlias("NO SUCH ALIAS", 10)
+	assert.NoError(err)
+	assert.Empty(found)
+}
+
+func TestSearchByAliasRejectsShortSubstring(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatal("No query should be run for a short search term")
+			return nil
+		},
+	}
+
+	_, err := NewCypherFinancialInstrumentService(conn, conn).SearchByAlias("GC", 10)
+	assert.IsType(requestError{}, err)
+}
+
+func TestRewriteWithFewerTagsRemovesSurplusTags(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	taggedFinancialInstrument := testFinancialInstrument
+	taggedFinancialInstrument.Tags = []string{topicUUID, otherTopicUUID}
+	assert.NoError(cypherDriver.Write(taggedFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	taggedFinancialInstrument.Tags = []string{topicUUID}
+	assert.NoError(cypherDriver.Write(taggedFinancialInstrument, test_trans_id), "Failed to update financial instrument")
+
+	readAndCompare(taggedFinancialInstrument, t, db)
+}
+
+func TestWriteWithCurrency(t *testing.T) {
+	gbpFinancialInstrument := testFinancialInstrument
+	gbpFinancialInstrument.Currency = "GBP"
+
+	WriteValueAndTestResult(t, gbpFinancialInstrument)
+}
+
+func TestWriteWithUnknownCurrencyFails(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatal("No query should be run for an invalid financial instrument")
+			return nil
+		},
+	}
+
+	invalidFinancialInstrument := testFinancialInstrument
+	invalidFinancialInstrument.Currency = "XYZ"
+
+	err := NewCypherFinancialInstrumentService(conn, conn).Write(invalidFinancialInstrument, test_trans_id)
+	assert.IsType(requestError{}, err)
+}
+
+func TestValidateFIGI(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, figi := range []string{figiCode, "BBG0066578X7", "BBG000BLNNH6", "BBG000B9XRY4"} {
+		assert.NoError(validateFIGI(figi), figi)
+	}
+	for _, figi := range []string{"BBG000Y1HJT9", "BBG000Y1HJT", "bbg000y1hjt8", "BSG000Y1HJT8", "BBX000Y1HJT8", "BBG000A1HJT8", "1BG000Y1HJT8", ""} {
+		assert.IsType(requestError{}, validateFIGI(figi), figi)
+	}
+}
+
+func TestWriteWithInvalidFIGIFailsUnlessLegacyFIGIsAccepted(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			return nil
+		},
+	}
+
+	legacyFinancialInstrument := testFinancialInstrument
+	legacyFinancialInstrument.AlternativeIdentifiers.FIGICode = "LEGACY-FIGI"
+
+	err := NewCypherFinancialInstrumentService(conn, conn).Write(legacyFinancialInstrument, test_trans_id)
+	assert.IsType(requestError{}, err)
+	assert.Contains(err.(requestError).InvalidRequestDetails(), "LEGACY-FIGI")
+
+	assert.NoError(NewCypherFinancialInstrumentService(conn, conn, WithLegacyFIGIs()).Write(legacyFinancialInstrument, test_trans_id))
+}
+
+func TestValidateISIN(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, isin := range []string{"US0378331005", "GB0002634946", "AU0000XVGZA3"} {
+		assert.NoError(validateISIN(isin), isin)
+	}
+	for _, isin := range []string{"US0378331006", "GB0002634947", "US037833100", "us0378331005", "120378331005", ""} {
+		assert.IsType(requestError{}, validateISIN(isin), isin)
+	}
+}
+
+func TestValidateSEDOL(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, sedol := range []string{"0263494", "B0YBKJ7", "2046251"} {
+		assert.NoError(validateSEDOL(sedol), sedol)
+	}
+	for _, sedol := range []string{"0263495", "B0YBKJ8", "A0YBKJ7", "026349", ""} {
+		assert.IsType(requestError{}, validateSEDOL(sedol), sedol)
+	}
+}
+
+func TestReadByCurrency(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	gbpFinancialInstrument := testFinancialInstrument
+	gbpFinancialInstrument.Currency = "GBP"
+	usdFinancialInstrument := incompleteFinancialInstrument
+	usdFinancialInstrument.Currency = "USD"
+	assert.NoError(cypherDriver.Write(gbpFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(usdFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	found, err := cypherDriver.ReadByCurrency("GBP", 0, 10)
+	assert.NoError(err)
+	assert.Len(found, 1)
+	assert.Equal(testFinancialInstrumentUUID, found[0].UUID)
+
+	found, err = cypherDriver.ReadByCurrency("GBP", 1, 10)
+	assert.NoError(err)
+	assert.Empty(found)
+
+	_, err = cypherDriver.ReadByCurrency("gbp", 0, 10)
+	assert.IsType(requestError{}, err)
+}
+
+func TestWriteWithSectorAndCountryOfRisk(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	screenedFinancialInstrument := testFinancialInstrument
+	screenedFinancialInstrument.Sector = "Financials"
+	screenedFinancialInstrument.CountryOfRisk = "GB"
+	assert.NoError(cypherDriver.Write(screenedFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	readAndCompare(screenedFinancialInstrument, t, db)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to update financial instrument")
+	readAndCompare(testFinancialInstrument, t, db)
+}
+
+func TestWriteWithUnknownCountryOfRiskFails(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatalf("No queries should be run for an invalid financial instrument, but ran %s", queries[0].Statement)
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	for _, country := range []string{"UK", "gb", "GBR", "XX"} {
+		invalidFinancialInstrument := testFinancialInstrument
+		invalidFinancialInstrument.CountryOfRisk = country
+		assert.IsType(requestError{}, cypherDriver.Write(invalidFinancialInstrument, test_trans_id), country)
+	}
+
+	_, err := cypherDriver.ReadByCountryOfRisk("UK", 0, 10)
+	assert.IsType(requestError{}, err)
+	_, err = cypherDriver.ReadBySector("", 0, 10)
+	assert.IsType(requestError{}, err)
+}
+
+func TestReadBySectorAndCountryOfRisk(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	gbFinancialInstrument := testFinancialInstrument
+	gbFinancialInstrument.Sector = "Financials"
+	gbFinancialInstrument.CountryOfRisk = "GB"
+	usFinancialInstrument := incompleteFinancialInstrument
+	usFinancialInstrument.Sector = "Energy"
+	usFinancialInstrument.CountryOfRisk = "US"
+	assert.NoError(cypherDriver.Write(gbFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(usFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	found, err := cypherDriver.ReadBySector("Energy", 0, 10)
+	assert.NoError(err)
+	assert.Len(found, 1)
+	assert.Equal(testIncompleteFinancialInstrumentUUID, found[0].UUID)
+
+	found, err = cypherDriver.ReadByCountryOfRisk("GB", 0, 10)
+	assert.NoError(err)
+	assert.Len(found, 1)
+	assert.Equal(testFinancialInstrumentUUID, found[0].UUID)
+
+	found, err = cypherDriver.ReadByCountryOfRisk("FR", 0, 10)
+	assert.NoError(err)
+	assert.Empty(found)
+}
+
+func TestReadByFieldUsesInstrumentFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	statements := []string{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			statements = append(statements, queries[0].Statement)
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	reads := []struct {
+		read   func() ([]financialInstrument, error)
+		filter InstrumentFilter
+	}{
+		{func() ([]financialInstrument, error) { return cypherDriver.ReadByCurrency("GBP", 0, 10) }, InstrumentFilter{Currency: "GBP"}},
+		{func() ([]financialInstrument, error) { return cypherDriver.ReadByStatus(statusActive, 0, 10) }, InstrumentFilter{Status: statusActive}},
+		{func() ([]financialInstrument, error) { return cypherDriver.ReadBySector("Financials", 0, 10) }, InstrumentFilter{Sector: "Financials"}},
+		{func() ([]financialInstrument, error) { return cypherDriver.ReadByCountryOfRisk("GB", 0, 10) }, InstrumentFilter{CountryOfRisk: "GB"}},
+	}
+	for _, read := range reads {
+		statements = statements[:0]
+		_, err := read.read()
+		assert.NoError(err)
+		_, err = cypherDriver.ReadFiltered(read.filter, 0, 10)
+		assert.NoError(err)
+		if assert.Len(statements, 2) {
+			assert.Equal(statements[1], statements[0], "%+v", read.filter)
+		}
+	}
+
+	_, err := cypherDriver.ReadByCurrency("", 0, 10)
+	assert.IsType(requestError{}, err, "An empty currency shouldn't read every financial instrument")
+	_, err = cypherDriver.ReadByStatus("", 0, 10)
+	assert.IsType(requestError{}, err)
+	_, err = cypherDriver.ReadByCountryOfRisk("", 0, 10)
+	assert.IsType(requestError{}, err)
+}
+
+func TestInstrumentFilterMatch(t *testing.T) {
+	assert := assert.New(t)
+
+	match, params, err := InstrumentFilter{}.match()
+	assert.NoError(err)
+	assert.Equal(`MATCH (fi:FinancialInstrument)`, match)
+	assert.Empty(params)
+
+	match, params, err = InstrumentFilter{Type: "FinancialInstrument", Currency: "GBP", Status: statusSuspended, Source: "factset", IssuedBy: orgUUID, Sector: "Financials", CountryOfRisk: "GB"}.match()
+	assert.NoError(err)
+	assert.Equal(`MATCH (fi:FinancialInstrument)
+				WHERE fi:FinancialInstrument AND fi.currency = {currency} AND fi.status = {status} AND fi.source = {source} AND (fi)-[:ISSUED_BY]->(:Thing {uuid:{issuedBy}}) AND fi.sector = {sector} AND fi.countryOfRisk = {countryOfRisk}`, match)
+	assert.Equal(map[string]interface{}{"currency": "GBP", "status": statusSuspended, "source": "factset", "issuedBy": orgUUID, "sector": "Financials", "countryOfRisk": "GB"}, params)
+
+	match, _, err = InstrumentFilter{Status: statusActive}.match()
+	assert.NoError(err)
+	assert.Contains(match, `WHERE coalesce(fi.status, {status}) = {status}`, "Financial instruments without a status are active")
+
+	for _, filter := range []InstrumentFilter{{Type: "Bond) DETACH DELETE (fi"}, {Currency: "gbp"}, {Status: "closed"}, {IssuedBy: "not-a-uuid"}, {CountryOfRisk: "UK"}} {
+		_, _, err := filter.match()
+		assert.IsType(requestError{}, err, "%+v", filter)
+	}
+}
+
+func TestCountFiltered(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	gbpFinancialInstrument := testFinancialInstrument
+	gbpFinancialInstrument.Currency = "GBP"
+	gbpFinancialInstrument.Status = statusSuspended
+	gbpFinancialInstrument.Source = "factset"
+	usdFinancialInstrument := incompleteFinancialInstrument
+	usdFinancialInstrument.Currency = "USD"
+	assert.NoError(cypherDriver.Write(gbpFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(usdFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	for _, counted := range []struct {
+		filter   InstrumentFilter
+		expected int
+	}{
+		{InstrumentFilter{}, 2},
+		{InstrumentFilter{Currency: "GBP"}, 1},
+		{InstrumentFilter{Currency: "GBP", Status: statusSuspended, IssuedBy: orgUUID}, 1},
+		{InstrumentFilter{Currency: "GBP", Status: statusActive}, 0},
+		{InstrumentFilter{Status: statusActive}, 1},
+		{InstrumentFilter{Source: "factset"}, 1},
+		{InstrumentFilter{Source: "factset", Currency: "USD"}, 0},
+		{InstrumentFilter{Type: "FinancialInstrument", Currency: "GBP", Status: statusSuspended}, 1},
+	} {
+		count, err := cypherDriver.CountFiltered(counted.filter)
+		assert.NoError(err)
+		assert.Equal(counted.expected, count, "%+v", counted.filter)
+	}
+}
+
+func TestReadFiltered(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	gbpFinancialInstrument := testFinancialInstrument
+	gbpFinancialInstrument.Currency = "GBP"
+	usdFinancialInstrument := incompleteFinancialInstrument
+	usdFinancialInstrument.Currency = "USD"
+	assert.NoError(cypherDriver.Write(gbpFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(usdFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	found, err := cypherDriver.ReadFiltered(InstrumentFilter{Currency: "GBP", IssuedBy: orgUUID}, 0, 10)
+	assert.NoError(err)
+	assert.Len(found, 1)
+	assert.Equal(testFinancialInstrumentUUID, found[0].UUID)
+
+	all, err := cypherDriver.ReadFiltered(InstrumentFilter{}, 0, 10)
+	assert.NoError(err)
+	assert.Len(all, 2)
+	assert.Equal(testIncompleteFinancialInstrumentUUID, all[0].UUID)
+	assert.Equal(testFinancialInstrumentUUID, all[1].UUID)
+
+	page, err := cypherDriver.ReadFiltered(InstrumentFilter{}, 1, 10)
+	assert.NoError(err)
+	assert.Equal(all[1:], page)
+}
+
+func TestReadFilteredQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	queries := []*neoism.CypherQuery{}
+	conn := mockNeoConnection{
+		cypherBatch: func(batch []*neoism.CypherQuery) error {
+			queries = append(queries, batch...)
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	_, err := cypherDriver.ReadFiltered(InstrumentFilter{}, 0, 10)
+	assert.NoError(err)
+	_, err = cypherDriver.ReadFiltered(InstrumentFilter{Sector: "Energy"}, 5, 10)
+	assert.NoError(err)
+
+	assert.Len(queries, 2)
+	assert.True(strings.HasPrefix(queries[0].Statement, "MATCH (fi:FinancialInstrument)\n\t\t\t\tWITH fi ORDER BY fi.uuid"), "An empty filter should read every financial instrument")
+	assert.Equal(map[string]interface{}{"skip": 0, "limit": 10}, queries[0].Parameters)
+	assert.Contains(queries[1].Statement, "WHERE fi.sector = {sector}")
+	assert.Equal(map[string]interface{}{"skip": 5, "limit": 10, "sector": "Energy"}, queries[1].Parameters)
+
+	_, err = cypherDriver.ReadFiltered(InstrumentFilter{}, 0, 0)
+	assert.IsType(requestError{}, err)
+	_, err = cypherDriver.ReadFiltered(InstrumentFilter{Currency: "XYZ"}, 0, 10)
+	assert.IsType(requestError{}, err)
+	assert.Len(queries, 2, "No query should be run for an invalid page or filter")
+}
+
+func TestReadByStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	delistedFinancialInstrument := testFinancialInstrument
+	delistedFinancialInstrument.Status = statusDelisted
+	activeFinancialInstrument := specialCharactersFinancialInstrument
+	activeFinancialInstrument.Status = statusActive
+	assert.NoError(cypherDriver.Write(delistedFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(activeFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	readAndCompare(delistedFinancialInstrument, t, db)
+
+	readByStatus := func(status string) []string {
+		found, err := cypherDriver.ReadByStatus(status, 0, 10)
+		assert.NoError(err)
+		uuids := []string{}
+		for _, fi := range found {
+			uuids = append(uuids, fi.UUID)
+		}
+		sort.Strings(uuids)
+		return uuids
+	}
+
+	assert.Equal([]string{testFinancialInstrumentUUID}, readByStatus(statusDelisted))
+	assert.Equal([]string{}, readByStatus(statusSuspended))
+	active := []string{specialCharactersFinancialInstrumentUUID, testIncompleteFinancialInstrumentUUID}
+	sort.Strings(active)
+	assert.Equal(active, readByStatus(statusActive))
+
+	_, err := cypherDriver.ReadByStatus("halted", 0, 10)
+	assert.IsType(requestError{}, err)
+}
+
+func TestWriteWithPrimaryIdentifierType(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	figiPrimaryFinancialInstrument := testFinancialInstrument
+	figiPrimaryFinancialInstrument.PrimaryIdentifierType = figiIdentifierLabel
+	assert.NoError(cypherDriver.Write(figiPrimaryFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	readAndCompare(figiPrimaryFinancialInstrument, t, db)
+}
+
+func TestWriteWithInconsistentPrimaryIdentifierTypeFails(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatalf("No queries should be run for an invalid financial instrument, but ran %s", queries[0].Statement)
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	wsodPrimaryFinancialInstrument := testFinancialInstrument
+	wsodPrimaryFinancialInstrument.PrimaryIdentifierType = wsodIdentifierLabel
+	assert.IsType(requestError{}, cypherDriver.Write(wsodPrimaryFinancialInstrument, test_trans_id))
+
+	isinPrimaryFinancialInstrument := testFinancialInstrument
+	isinPrimaryFinancialInstrument.PrimaryIdentifierType = "ISIN"
+	assert.IsType(requestError{}, cypherDriver.Write(isinPrimaryFinancialInstrument, test_trans_id))
+}
+
+func TestWriteWithTooManyIdentifiersFails(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatalf("No queries should be run for an invalid financial instrument, but ran %s", queries[0].Statement)
+			return nil
+		},
+	}
+
+	overLimitFinancialInstrument := incompleteFinancialInstrument
+	overLimitFinancialInstrument.AlternativeIdentifiers.UUIDS = []string{testIncompleteFinancialInstrumentUUID}
+	for i := 0; i < defaultMaxIdentifiers; i++ {
+		overLimitFinancialInstrument.AlternativeIdentifiers.UUIDS = append(overLimitFinancialInstrument.AlternativeIdentifiers.UUIDS, fmt.Sprintf("38431a92-dda3-4eb9-a367-%012d", i))
+	}
+	assert.IsType(requestError{}, NewCypherFinancialInstrumentService(conn, conn).Write(overLimitFinancialInstrument, test_trans_id))
+
+	// The factset identifier makes three, one too many
+	assert.IsType(requestError{}, NewCypherFinancialInstrumentService(conn, conn, WithMaxIdentifiers(2)).Write(testFinancialInstrument, test_trans_id))
+}
+
+func TestWriteWithOverLengthPrefLabelFails(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatalf("No queries should be run for an invalid financial instrument, but ran %s", queries[0].Statement)
+			return nil
+		},
+	}
+
+	overLength := testFinancialInstrument
+	overLength.PrefLabel = strings.Repeat("A", defaultMaxPrefLabelLength+1)
+	err := NewCypherFinancialInstrumentService(conn, conn).Write(overLength, test_trans_id)
+	if assert.IsType(requestError{}, err) {
+		assert.Contains(err.(requestError).InvalidRequestDetails(), "prefLabel")
+	}
+	assert.IsType(requestError{}, writeErr(NewCypherFinancialInstrumentService(conn, conn).WriteBatch([]financialInstrument{incompleteFinancialInstrument, overLength}, test_trans_id, WriteOptions{})))
+
+	// Characters are counted rather than bytes, so ten three byte characters fit a limit of ten
+	atLimit := testFinancialInstrument
+	atLimit.PrefLabel = strings.Repeat("€", 10)
+	writingConn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			return nil
+		},
+	}
+	assert.NoError(NewCypherFinancialInstrumentService(writingConn, writingConn, WithMaxPrefLabelLength(10)).Write(atLimit, test_trans_id))
+	atLimit.PrefLabel += "€"
+	assert.IsType(requestError{}, NewCypherFinancialInstrumentService(conn, conn, WithMaxPrefLabelLength(10)).Write(atLimit, test_trans_id))
+	assert.NoError(NewCypherFinancialInstrumentService(writingConn, writingConn, WithMaxPrefLabelLength(0)).Write(overLength, test_trans_id))
+}
+
+func TestWriteWithIdentifierLimitRemoved(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			return nil
+		},
+	}
+
+	manyIdentifiersFinancialInstrument := incompleteFinancialInstrument
+	manyIdentifiersFinancialInstrument.AlternativeIdentifiers.UUIDS = []string{testIncompleteFinancialInstrumentUUID}
+	for i := 0; i < defaultMaxIdentifiers; i++ {
+		manyIdentifiersFinancialInstrument.AlternativeIdentifiers.UUIDS = append(manyIdentifiersFinancialInstrument.AlternativeIdentifiers.UUIDS, fmt.Sprintf("38431a92-dda3-4eb9-a367-%012d", i))
+	}
+	assert.NoError(NewCypherFinancialInstrumentService(conn, conn, WithMaxIdentifiers(0)).Write(manyIdentifiersFinancialInstrument, test_trans_id))
+}
+
+func TestWriteWithUnknownStatusFails(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatalf("No queries should be run for an invalid financial instrument, but ran %s", queries[0].Statement)
+			return nil
+		},
+	}
+
+	haltedFinancialInstrument := testFinancialInstrument
+	haltedFinancialInstrument.Status = "halted"
+	assert.IsType(requestError{}, NewCypherFinancialInstrumentService(conn, conn).Write(haltedFinancialInstrument, test_trans_id))
+}
+
+func TestReadIssuedByRelationshipProperties(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := NewCypherFinancialInstrumentService(db, db, WithRelationshipProperties())
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	fi, found, err := cypherDriver.ReadTyped(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(map[string]map[string]interface{}{"ISSUED_BY": {}}, fi.RelationshipProperties)
+
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement:  `MATCH (:FinancialInstrument {uuid:{uuid}})-[is:ISSUED_BY]->() SET is.source = "factset"`,
+		Parameters: map[string]interface{}{"uuid": testFinancialInstrumentUUID},
+	}}))
+	fi, found, err = cypherDriver.ReadTyped(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(map[string]map[string]interface{}{"ISSUED_BY": {"source": "factset"}}, fi.RelationshipProperties)
+
+	fi, found, err = cypherDriver.ReadTyped(testIncompleteFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Nil(fi.RelationshipProperties)
+
+	readAndCompare(testFinancialInstrument, t, db)
+}
+
+func TestWriteIssuedByRelationshipProperties(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := NewCypherFinancialInstrumentService(db, db, WithRelationshipProperties())
+	assert.NoError(cypherDriver.Initialise())
+	defer cleanDB(db, assert)
+
+	withProperties := testFinancialInstrument
+	withProperties.RelationshipProperties = map[string]map[string]interface{}{"ISSUED_BY": {"role": "guarantor", "percentage": 40.0}}
+	assert.NoError(cypherDriver.Write(withProperties, test_trans_id), "Failed to create financial instrument")
+
+	fi, found, err := cypherDriver.ReadTyped(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(orgUUID, fi.IssuedBy)
+	assert.Equal(withProperties.RelationshipProperties, fi.RelationshipProperties)
+
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(testFinancialInstrument, test_trans_id, WriteOptions{PreserveRelationships: true})))
+	fi, _, err = cypherDriver.ReadTyped(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Equal(withProperties.RelationshipProperties, fi.RelationshipProperties, "Properties should be kept unless given")
+}
+
+func TestBackfillHashes(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(specialCharactersFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	withHash, _, err := cypherDriver.ReadRaw(specialCharactersFinancialInstrumentUUID)
+	assert.NoError(err)
+
+	// As if written before hashes were stored
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement:  `MATCH (fi:FinancialInstrument {uuid:{uuid}}) REMOVE fi.hash`,
+		Parameters: map[string]interface{}{"uuid": testFinancialInstrumentUUID},
+	}}))
+	before, _, err := cypherDriver.ReadRaw(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Nil(before["hash"])
+
+	backfilled, err := cypherDriver.BackfillHashes(0, 10)
+	assert.NoError(err)
+	assert.Equal(1, backfilled)
+
+	expectedHash, err := hashOf(testFinancialInstrument)
+	assert.NoError(err)
+	after, _, err := cypherDriver.ReadRaw(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	before["hash"] = expectedHash
+	assert.Equal(before, after, "Only the hash should have been added")
+	readAndCompare(testFinancialInstrument, t, db)
+
+	backfilled, err = cypherDriver.BackfillHashes(0, 10)
+	assert.NoError(err)
+	assert.Equal(0, backfilled)
+	unchanged, _, err := cypherDriver.ReadRaw(specialCharactersFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Equal(withHash, unchanged)
+}
+
+func TestBackfillHashesOnlyStoresMissingHashes(t *testing.T) {
+	assert := assert.New(t)
+
+	statements := []string{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			statements = append(statements, queries[0].Statement)
+			if queries[0].Result != nil {
+				setQueryResult(queries[0], testReadRow)
+			}
+			return nil
+		},
+	}
+
+	backfilled, err := NewCypherFinancialInstrumentService(conn, conn).BackfillHashes(0, 10)
+	assert.NoError(err)
+	assert.Equal(1, backfilled)
+	if assert.Len(statements, 2) {
+		assert.Contains(statements[0], "WHERE fi.hash IS NULL")
+		assert.Contains(statements[1], "WHERE fi.hash IS NULL\n")
+		assert.NotContains(statements[1], "fi.hash <> h.hash")
+	}
+}
+
+func TestRecomputeHashes(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	relationships := func() []string {
+		results := []struct {
+			Type string `json:"type"`
+		}{}
+		assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+			Statement:  `MATCH (fi:FinancialInstrument {uuid:{uuid}})-[r]-() RETURN type(r) as type ORDER BY type`,
+			Parameters: map[string]interface{}{"uuid": testFinancialInstrumentUUID},
+			Result:     &results,
+		}}))
+		types := []string{}
+		for _, result := range results {
+			types = append(types, result.Type)
+		}
+		return types
+	}
+	before := relationships()
+	props, _, err := cypherDriver.ReadRaw(testFinancialInstrumentUUID)
+	assert.NoError(err)
+
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement:  `MATCH (fi:FinancialInstrument {uuid:{uuid}}) SET fi.hash = "stale"`,
+		Parameters: map[string]interface{}{"uuid": testFinancialInstrumentUUID},
+	}}))
+
+	for i := 0; i < 2; i++ {
+		recomputed, err := cypherDriver.RecomputeHashes(0, 10)
+		assert.NoError(err)
+		assert.Equal(1, recomputed)
+
+		fi, _, err := cypherDriver.ReadTyped(testFinancialInstrumentUUID)
+		assert.NoError(err)
+		expectedHash, err := hashOf(fi)
+		assert.NoError(err)
+		hash, found, err := cypherDriver.ReadHash(testFinancialInstrumentUUID)
+		assert.NoError(err)
+		assert.True(found)
+		assert.Equal(expectedHash, hash)
+	}
+
+	recomputed, err := cypherDriver.RecomputeHashes(1, 10)
+	assert.NoError(err)
+	assert.Equal(0, recomputed)
+
+	assert.Equal(before, relationships())
+	recomputedProps, _, err := cypherDriver.ReadRaw(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Equal(props["lastModified"], recomputedProps["lastModified"])
+	readAndCompare(testFinancialInstrument, t, db)
+}
+
+func TestRecomputeHashesKeepsWrittenHash(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	lastModified := time.Date(2017, time.March, 1, 12, 0, 0, 0, time.UTC)
+	fi := testFinancialInstrument
+	fi.LastModified = &lastModified
+	fi.Aliases = []string{"GREENWICH CAP", "ACCEPTANCE 1991"}
+	fi.AlternativeIdentifiers.UUIDS = []string{testFinancialInstrumentUUID, rekeyedFinancialInstrumentUUID}
+	assert.NoError(cypherDriver.Write(fi, test_trans_id), "Failed to create financial instrument")
+
+	written, found, err := cypherDriver.ReadHash(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+
+	recomputed, err := cypherDriver.RecomputeHashes(0, 10)
+	assert.NoError(err)
+	assert.Equal(1, recomputed)
+
+	hash, _, err := cypherDriver.ReadHash(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Equal(written, hash, "A financial instrument that hasn't changed should keep the hash it was written with")
+}
+
+func TestRecomputeHashesKeepsWrittenHashWithEmptyListEntries(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	fi := testFinancialInstrument
+	fi.Tags = []string{topicUUID, ""}
+	fi.AlternativeIdentifiers.UUIDS = []string{testFinancialInstrumentUUID, ""}
+	assert.NoError(cypherDriver.Write(fi, test_trans_id), "Failed to create financial instrument")
+
+	written, found, err := cypherDriver.ReadHash(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+
+	_, err = cypherDriver.RecomputeHashes(0, 10)
+	assert.NoError(err)
+
+	hash, _, err := cypherDriver.ReadHash(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Equal(written, hash, "Empty tags and identifiers, which aren't written, shouldn't change the hash")
+}
+
+func TestRepointIssuersCreatesMissingIssuer(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(specialCharactersFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	moved, err := cypherDriver.RepointIssuers(map[string]string{orgUUID: upToDateOrgUUID})
+	assert.NoError(err)
+	assert.Equal(2, moved)
+
+	for _, fi := range []financialInstrument{testFinancialInstrument, specialCharactersFinancialInstrument} {
+		fi.IssuedBy = upToDateOrgUUID
+		readAndCompare(fi, t, db)
+
+		expectedHash, err := hashOf(fi)
+		assert.NoError(err)
+		hash, _, err := cypherDriver.ReadHash(fi.UUID)
+		assert.NoError(err)
+		assert.Equal(expectedHash, hash, "The hash should be updated for the new issuer")
+	}
+	readAndCompare(incompleteFinancialInstrument, t, db)
+
+	moved, err = cypherDriver.RepointIssuers(map[string]string{orgUUID: upToDateOrgUUID})
+	assert.NoError(err)
+	assert.Equal(0, moved, "Repeating a finished run should move nothing")
+}
+
+func TestRepointIssuersToExistingIssuer(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	otherIssuerFinancialInstrument := incompleteFinancialInstrument
+	otherIssuerFinancialInstrument.IssuedBy = upToDateOrgUUID
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(otherIssuerFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	moved, err := cypherDriver.RepointIssuers(map[string]string{orgUUID: upToDateOrgUUID})
+	assert.NoError(err)
+	assert.Equal(1, moved)
+
+	results := []struct {
+		Issuers     int `json:"issuers"`
+		Identifiers int `json:"identifiers"`
+	}{}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement: `MATCH (fi:FinancialInstrument)-[:ISSUED_BY]->(o:Thing)<-[:IDENTIFIES]-(i:Identifier)
+				RETURN count(DISTINCT o) as issuers, count(DISTINCT i) as identifiers`,
+		Result: &results,
+	}}))
+	assert.Equal(1, results[0].Issuers, "Both financial instruments should be issued by the existing issuer")
+	assert.Equal(1, results[0].Identifiers)
+
+	siblings, err := cypherDriver.ReadSiblings(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Len(siblings, 1)
+	assert.Equal(testIncompleteFinancialInstrumentUUID, siblings[0].UUID)
+}
+
+func TestRepointIssuersMergesNewIssuerByUUID(t *testing.T) {
+	assert := assert.New(t)
+
+	repoints := []*neoism.CypherQuery{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			if strings.Contains(queries[0].Statement, "DELETE old") {
+				repoints = append(repoints, queries[0])
+				if len(repoints) == 1 {
+					setQueryResult(queries[0], `[{"uuid": "`+testFinancialInstrumentUUID+`"}]`)
+				}
+			}
+			return nil
+		},
+	}
+	mirrored := []*neoism.CypherQuery{}
+	secondary := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			if strings.Contains(queries[0].Statement, "DELETE old") {
+				mirrored = append(mirrored, queries...)
+			}
+			return nil
+		},
+	}
+
+	moved, err := NewCypherFinancialInstrumentService(conn, conn, WithIssuerLabel("Organisation"), WithSecondary(secondary)).RepointIssuers(map[string]string{orgUUID: upToDateOrgUUID})
+	assert.NoError(err)
+	assert.Equal(1, moved)
+	assert.Len(repoints, 1, "A batch smaller than the batch size should be the last")
+	assert.Contains(repoints[0].Statement, "MERGE (o:Thing {uuid:{newUuid}}) ON CREATE SET o:`Organisation`")
+	assert.Contains(repoints[0].Statement, "MERGE (orgUpp:Identifier:UPPIdentifier{value:{newUuid}})")
+	assert.Contains(repoints[0].Statement, "MERGE (orgUpp)-[:IDENTIFIES]->(o)\n")
+	assert.Equal(orgUUID, repoints[0].Parameters["oldUuid"])
+	assert.Equal(upToDateOrgUUID, repoints[0].Parameters["newUuid"])
+
+	if assert.Len(mirrored, 1) {
+		assert.NotContains(mirrored[0].Statement, "LIMIT", "The secondary should move the same financial instruments, not a batch of its own")
+		assert.Contains(mirrored[0].Statement, "WHERE fi.uuid IN {uuids}")
+		assert.Equal([]string{testFinancialInstrumentUUID}, mirrored[0].Parameters["uuids"])
+	}
+}
+
+func TestRepointIssuersToThingWithoutIdentifier(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement:  `CREATE (:Thing {uuid:{uuid}})`,
+		Parameters: neoism.Props{"uuid": upToDateOrgUUID},
+	}}))
+
+	moved, err := cypherDriver.RepointIssuers(map[string]string{orgUUID: upToDateOrgUUID})
+	assert.NoError(err)
+	assert.Equal(1, moved)
+
+	repointed := testFinancialInstrument
+	repointed.IssuedBy = upToDateOrgUUID
+	readAndCompare(repointed, t, db)
+
+	results := []struct {
+		Things      int `json:"things"`
+		Identifiers int `json:"identifiers"`
+	}{}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement: `MATCH (t:Thing {uuid:{uuid}})
+				OPTIONAL MATCH (t)<-[:IDENTIFIES]-(i:UPPIdentifier)
+				RETURN count(DISTINCT t) as things, count(i) as identifiers`,
+		Parameters: neoism.Props{"uuid": upToDateOrgUUID},
+		Result:     &results,
+	}}))
+	assert.Equal([]struct {
+		Things      int `json:"things"`
+		Identifiers int `json:"identifiers"`
+	}{{1, 1}}, results, "The existing issuer should be given a UPPIdentifier rather than duplicated")
+}
+
+func TestRepointIssuersRejectsInvalidMappings(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatalf("No query should be run for an invalid mapping, but ran %s", queries[0].Statement)
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	chain := map[string]string{orgUUID: upToDateOrgUUID, upToDateOrgUUID: testIncompleteFinancialInstrumentUUID}
+	swap := map[string]string{orgUUID: upToDateOrgUUID, upToDateOrgUUID: orgUUID}
+	for _, mapping := range []map[string]string{{orgUUID: orgUUID}, {orgUUID: "not-a-uuid"}, {"not-a-uuid": orgUUID}, chain, swap} {
+		_, err := cypherDriver.RepointIssuers(mapping)
+		assert.IsType(requestError{}, err, "%v", mapping)
+	}
+
+	moved, err := cypherDriver.RepointIssuers(map[string]string{})
+	assert.NoError(err)
+	assert.Equal(0, moved)
+}
+
+func TestReadByMIC(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	londonFinancialInstrument := testFinancialInstrument
+	londonFinancialInstrument.MICCode = "XLON"
+	assert.NoError(cypherDriver.Write(londonFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	readAndCompare(londonFinancialInstrument, t, db)
+
+	found, err := cypherDriver.ReadByMIC("XLON", 0, 10)
+	assert.NoError(err)
+	assert.Len(found, 1)
+	assert.Equal(testFinancialInstrumentUUID, found[0].UUID)
+
+	found, err = cypherDriver.ReadByMIC("XNYS", 0, 10)
+	assert.NoError(err)
+	assert.Empty(found)
+
+	_, err = cypherDriver.ReadByMIC("xlon", 0, 10)
+	assert.IsType(requestError{}, err)
+}
+
+func TestWriteWithInvalidMICFails(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatalf("No queries should be run for an invalid financial instrument, but ran %s", queries[0].Statement)
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	for _, mic := range []string{"XLO", "XLONX", "xlon", "XL-N"} {
+		invalidFinancialInstrument := testFinancialInstrument
+		invalidFinancialInstrument.MICCode = mic
+		assert.IsType(requestError{}, cypherDriver.Write(invalidFinancialInstrument, test_trans_id), mic)
+	}
+}
+
+func TestReadByIssuerIdentifier(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	otherIssuerFinancialInstrument := specialCharactersFinancialInstrument
+	otherIssuerFinancialInstrument.IssuedBy = upToDateOrgUUID
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(otherIssuerFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{createNewIdentifierQuery(orgUUID, factsetIdentifierLabel, "000C7F-E")}))
+
+	readByIssuerIdentifier := func(identifierType string, value string) []string {
+		found, err := cypherDriver.ReadByIssuerIdentifier(identifierType, value, 0, 10)
+		assert.NoError(err)
+		uuids := []string{}
+		for _, fi := range found {
+			uuids = append(uuids, fi.UUID)
+		}
+		return uuids
+	}
+
+	assert.Equal([]string{testFinancialInstrumentUUID}, readByIssuerIdentifier(factsetIdentifierLabel, "000C7F-E"))
+	assert.Equal([]string{testFinancialInstrumentUUID}, readByIssuerIdentifier(uppIdentifierLabel, orgUUID))
+	assert.Equal([]string{specialCharactersFinancialInstrumentUUID}, readByIssuerIdentifier(uppIdentifierLabel, upToDateOrgUUID))
+	assert.Equal([]string{}, readByIssuerIdentifier(factsetIdentifierLabel, "999999-E"))
+
+	_, err := cypherDriver.ReadByIssuerIdentifier("LEI", "213800D1EI4B9WTWWD28", 0, 10)
+	assert.IsType(requestError{}, err)
+}
+
+func TestReadByRelationshipPresence(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	issued, err := cypherDriver.ReadByRelationshipPresence("ISSUED_BY", true, 0, 10)
+	assert.NoError(err)
+	assert.Len(issued, 1)
+	assert.Equal(testFinancialInstrumentUUID, issued[0].UUID)
+
+	unissued, err := cypherDriver.ReadByRelationshipPresence("ISSUED_BY", false, 0, 10)
+	assert.NoError(err)
+	assert.Len(unissued, 1)
+	assert.Equal(testIncompleteFinancialInstrumentUUID, unissued[0].UUID)
+
+	identified, err := cypherDriver.ReadByRelationshipPresence("IDENTIFIES", true, 0, 10)
+	assert.NoError(err)
+	assert.Len(identified, 2)
+}
+
+func TestReadByRelationshipPresenceRejectsUnmanagedRelationships(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatal("No query should be run for a relationship type the service doesn't write")
+			return nil
+		},
+	}
+
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+	for _, relType := range []string{"LISTED_ON", "issued_by", "ISSUED_BY]->() MATCH (x", ""} {
+		_, err := cypherDriver.ReadByRelationshipPresence(relType, true, 0, 10)
+		assert.IsType(requestError{}, err, relType)
+	}
+}
+
+func TestReadByRelationshipPresenceQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	statements := []string{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			statements = append(statements, queries[0].Statement)
+			return nil
+		},
+	}
+
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+	_, err := cypherDriver.ReadByRelationshipPresence("TAGGED_WITH", true, 0, 10)
+	assert.NoError(err)
+	_, err = cypherDriver.ReadByRelationshipPresence("TAGGED_WITH", false, 0, 10)
+	assert.NoError(err)
+
+	assert.Contains(statements[0], "WHERE (fi)-[:TAGGED_WITH]->(:Thing)")
+	assert.Contains(statements[1], "WHERE NOT (fi)-[:TAGGED_WITH]->(:Thing)")
+}
+
+func TestReadByFIGIPrefix(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	found, err := cypherDriver.ReadByFIGIPrefix(figiCode[:6], 0, 10)
+	assert.NoError(err)
+	assert.Len(found, 1)
+	assert.Equal(testFinancialInstrumentUUID, found[0].UUID)
+
+	found, err = cypherDriver.ReadByFIGIPrefix("BBGXXX", 0, 10)
+	assert.NoError(err)
+	assert.Empty(found)
+}
+
+func TestReadByFIGIPrefixRejectsShortPrefix(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatal("No query should be run for a short prefix")
+			return nil
+		},
+	}
+
+	_, err := NewCypherFinancialInstrumentService(conn, conn).ReadByFIGIPrefix("BBG", 0, 10)
+	assert.IsType(requestError{}, err)
+}
+
+func TestIDsByType(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	ids := []string{}
+	assert.NoError(cypherDriver.IDsByType("FinancialInstrument", func(id rwapi.IDEntry) (bool, error) {
+		ids = append(ids, id.ID)
+		return true, nil
+	}))
+	assert.Contains(ids, testFinancialInstrumentUUID)
+}
+
+func TestIDsByTypeRejectsUnknownType(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatal("No query should be run for an unknown type")
+			return nil
+		},
+	}
+
+	err := NewCypherFinancialInstrumentService(conn, conn).IDsByType("Thing) DETACH DELETE (fi", func(id rwapi.IDEntry) (bool, error) {
+		return true, nil
+	})
+	assert.IsType(requestError{}, err)
+}
+
+func TestChangedBySourceSince(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	before := time.Now().Add(-time.Second)
+
+	factsetFinancialInstrument := testFinancialInstrument
+	factsetFinancialInstrument.Source = "Factset"
+	otherFinancialInstrument := incompleteFinancialInstrument
+	otherFinancialInstrument.Source = "Other"
+	assert.NoError(cypherDriver.Write(factsetFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(otherFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	changed := []string{}
+	collect := func(id rwapi.IDEntry) (bool, error) {
+		changed = append(changed, id.ID)
+		return true, nil
+	}
+
+	assert.NoError(cypherDriver.ChangedBySourceSince("Factset", before, collect))
+	assert.Equal([]string{testFinancialInstrumentUUID}, changed)
+
+	changed = []string{}
+	assert.NoError(cypherDriver.ChangedBySourceSince("Factset", time.Now().Add(time.Minute), collect))
+	assert.Empty(changed)
+}
+
+func TestChangedSinceUsesSuppliedLastModified(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	twoDaysAgo := time.Now().Add(-48 * time.Hour)
+	historicalFinancialInstrument := testFinancialInstrument
+	historicalFinancialInstrument.LastModified = &twoDaysAgo
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(historicalFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	changedSince := func(since time.Time) []string {
+		changed := []string{}
+		assert.NoError(cypherDriver.ChangedSince(since, func(id rwapi.IDEntry) (bool, error) {
+			if id.ID == testFinancialInstrumentUUID || id.ID == testIncompleteFinancialInstrumentUUID {
+				changed = append(changed, id.ID)
+			}
+			return true, nil
+		}))
+		return changed
+	}
+
+	assert.Equal([]string{testFinancialInstrumentUUID, testIncompleteFinancialInstrumentUUID}, changedSince(twoDaysAgo.Add(-time.Hour)))
+	assert.Equal([]string{testIncompleteFinancialInstrumentUUID}, changedSince(twoDaysAgo.Add(time.Hour)))
+}
+
+func TestWriteTypedAndReadTyped(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.WriteTyped(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	fi, found, err := cypherDriver.ReadTyped(testIncompleteFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(incompleteFinancialInstrument, fi)
+}
+
+func TestExistsMany(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	exists, err := cypherDriver.ExistsMany([]string{testFinancialInstrumentUUID, testIncompleteFinancialInstrumentUUID})
+	assert.NoError(err)
+	assert.Equal(map[string]bool{testFinancialInstrumentUUID: true, testIncompleteFinancialInstrumentUUID: false}, exists)
+}
+
+func TestExistsManyRejectsInvalidRequests(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatal("No query should be run for an invalid request")
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	_, err := cypherDriver.ExistsMany([]string{testFinancialInstrumentUUID, "not-a-uuid"})
+	assert.IsType(requestError{}, err)
+
+	tooMany := make([]string, maxExistsBatch+1)
+	for i := range tooMany {
+		tooMany[i] = testFinancialInstrumentUUID
+	}
+	_, err = cypherDriver.ExistsMany(tooMany)
+	assert.IsType(requestError{}, err)
+}
+
+func TestReplaceIdentifiersIsNeverReadWithoutIdentifiers(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	newIdentifiers := alternativeIdentifiers{
+		UUIDS:             []string{testFinancialInstrumentUUID},
+		FactsetIdentifier: "QX6S54-S",
+		FIGICode:          "BBG0066578X7",
+	}
+
+	done := make(chan struct{})
+	unidentifiedReads := make(chan int)
+	go func() {
+		unidentified := 0
+		for {
+			select {
+			case <-done:
+				unidentifiedReads <- unidentified
+				return
+			default:
+			}
+			fi, found, err := cypherDriver.ReadTyped(testFinancialInstrumentUUID)
+			if err == nil && found && fi.AlternativeIdentifiers.FIGICode == "" {
+				unidentified++
+			}
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		identifiers := testFinancialInstrument.AlternativeIdentifiers
+		if i%2 == 0 {
+			identifiers = newIdentifiers
+		}
+		assert.NoError(cypherDriver.ReplaceIdentifiers(testFinancialInstrumentUUID, identifiers))
+	}
+	close(done)
+	assert.Equal(0, <-unidentifiedReads)
+
+	replaced := testFinancialInstrument
+	replaced.AlternativeIdentifiers = testFinancialInstrument.AlternativeIdentifiers
+	readAndCompare(replaced, t, db)
+
+	assert.NoError(cypherDriver.ReplaceIdentifiers(testFinancialInstrumentUUID, newIdentifiers))
+	replaced.AlternativeIdentifiers = newIdentifiers
+	readAndCompare(replaced, t, db)
+}
+
+func TestReplaceIdentifiersReadsAroundTheCache(t *testing.T) {
+	assert := assert.New(t)
+
+	stored := strings.Replace(testReadRow, `"deprecatedIdentifiers": []`, `"deprecatedIdentifiers": ["`+facsetIdentifier+`"]`, 1)
+	updates := []*neoism.CypherQuery{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			for _, query := range queries {
+				if strings.Contains(query.Statement, "SET fi.hash = {hash}") {
+					updates = append(updates, query)
+				}
+			}
+			if len(queries) == 1 && strings.HasPrefix(queries[0].Statement, "MATCH (fi:FinancialInstrument {uuid:{uuid}})") {
+				setQueryResult(queries[0], stored)
+			}
+			return nil
+		},
+	}
+	cache := NewLRUCache(10)
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithCache(cache))
+
+	stale := testFinancialInstrument
+	stale.Currency = "USD"
+	stale.DeprecatedIdentifiers = nil
+	cache.Set(testFinancialInstrumentUUID, stale)
+
+	identifiers := alternativeIdentifiers{
+		UUIDS:             []string{testFinancialInstrumentUUID},
+		FactsetIdentifier: facsetIdentifier,
+		FIGICode:          "BBG0066578X7",
+	}
+	assert.NoError(cypherDriver.ReplaceIdentifiers(testFinancialInstrumentUUID, identifiers))
+	if assert.Len(updates, 1) {
+		replaced, _, err := cypherDriver.readStored(testFinancialInstrumentUUID)
+		assert.NoError(err)
+		assert.Equal([]string{facsetIdentifier}, replaced.DeprecatedIdentifiers)
+		replaced.AlternativeIdentifiers = identifiers
+		hash, err := hashOf(tidy(replaced))
+		assert.NoError(err)
+		assert.Equal(hash, updates[0].Parameters["hash"], "The hash should be of the stored, not the cached, version")
+	}
+}
+
+func TestReadIdentifierHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := NewCypherFinancialInstrumentService(db, db, WithIdentifierHistory())
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	firstWrite, err := cypherDriver.ReadIdentifierHistory(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Len(firstWrite, 3)
+
+	refigied := testFinancialInstrument
+	refigied.AlternativeIdentifiers.FIGICode = "BBG0066578X7"
+	assert.NoError(cypherDriver.Write(refigied, test_trans_id), "Failed to update financial instrument")
+
+	history, err := cypherDriver.ReadIdentifierHistory(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Len(history, 4)
+
+	// Sorted by type, so the Factset identifier comes first, then the old and new FIGIs, then the UPP uuid
+	assert.Equal(firstWrite[0], history[0])
+	assert.Equal(factsetIdentifierLabel, history[0].IdentifierType)
+	assert.Nil(history[0].ValidTo)
+
+	assert.Equal(figiIdentifierLabel, history[1].IdentifierType)
+	assert.Equal(figiCode, history[1].Value)
+	assert.Equal(firstWrite[1].ValidFrom, history[1].ValidFrom)
+	assert.NotNil(history[1].ValidTo)
+
+	assert.Equal(figiIdentifierLabel, history[2].IdentifierType)
+	assert.Equal("BBG0066578X7", history[2].Value)
+	assert.False(history[2].ValidFrom.Before(*history[1].ValidTo))
+	assert.Nil(history[2].ValidTo)
+
+	assert.Equal(firstWrite[2], history[3])
+}
+
+func TestIdentifierHistoryIsKeptInTheWriteTransaction(t *testing.T) {
+	assert := assert.New(t)
+
+	batches := [][]string{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			statements := []string{}
+			for _, query := range queries {
+				statements = append(statements, query.Statement)
+			}
+			batches = append(batches, statements)
+			return nil
+		},
+	}
+
+	assert.NoError(NewCypherFinancialInstrumentService(conn, conn, WithIdentifierHistory()).Write(testFinancialInstrument, test_trans_id))
+	if assert.NotEmpty(batches) {
+		write := batches[len(batches)-1]
+		assert.Contains(write[0], "CREATE (t)<-[:WAS_IDENTIFIER_OF]-(:IdentifierHistory {pending: true")
+		assert.Contains(write[len(write)-2], "SET i.validFrom")
+		assert.Contains(write[len(write)-1], "REMOVE h.pending")
+		for _, batch := range batches[:len(batches)-1] {
+			for _, statement := range batch {
+				assert.NotContains(statement, "IDENTIFIES]-(i:Identifier)", "the existing identifiers shouldn't be read outside the write")
+			}
+		}
+	}
+}
+
+func TestDeprecateIdentifier(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	found, err := cypherDriver.DeprecateIdentifier(testFinancialInstrumentUUID, figiIdentifierLabel, "BBG0066578X7")
+	assert.NoError(err)
+	assert.False(found)
+
+	found, err = cypherDriver.DeprecateIdentifier(testFinancialInstrumentUUID, figiIdentifierLabel, figiCode)
+	assert.NoError(err)
+	assert.True(found)
+
+	deprecatedFinancialInstrument := testFinancialInstrument
+	deprecatedFinancialInstrument.DeprecatedIdentifiers = []string{figiCode}
+	readAndCompare(deprecatedFinancialInstrument, t, db)
+
+	fi, found, err := cypherDriver.ReadByIdentifier(figiIdentifierLabel, figiCode, false)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(testFinancialInstrumentUUID, fi.UUID)
+
+	_, found, err = cypherDriver.ReadByIdentifier(figiIdentifierLabel, figiCode, true)
+	assert.NoError(err)
+	assert.False(found)
+
+	_, found, err = cypherDriver.ReadByIdentifier(factsetIdentifierLabel, facsetIdentifier, true)
+	assert.NoError(err)
+	assert.True(found)
+}
+
+func TestDeprecateIdentifierOnlyUpdatesTheIdentifierAndHash(t *testing.T) {
+	assert := assert.New(t)
+
+	writes := [][]*neoism.CypherQuery{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			if strings.Contains(queries[0].Statement, "SET i.deprecated = true") {
+				writes = append(writes, queries)
+				setQueryResult(queries[0], `[{"uuid": "`+testFinancialInstrumentUUID+`"}]`)
+				return nil
+			}
+			setQueryResult(queries[0], testReadRow)
+			return nil
+		},
+	}
+	cache := NewLRUCache(10)
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithCache(cache))
+
+	// A stale cached version without the identifier mustn't stop it being deprecated
+	cache.Set(testFinancialInstrumentUUID, incompleteFinancialInstrument)
+
+	found, err := cypherDriver.DeprecateIdentifier(testFinancialInstrumentUUID, figiIdentifierLabel, figiCode)
+	assert.NoError(err)
+	assert.True(found)
+	if assert.Len(writes, 1) && assert.Len(writes[0], 1) {
+		query := writes[0][0]
+		assert.Contains(query.Statement, "SET i.deprecated = true, fi.hash = {hash}")
+		assert.NotContains(query.Statement, "lastModified")
+		assert.Equal(figiCode, query.Parameters["value"])
+
+		deprecated, _, err := cypherDriver.readStored(testFinancialInstrumentUUID)
+		assert.NoError(err)
+		deprecated.DeprecatedIdentifiers = []string{figiCode}
+		hash, err := hashOf(deprecated)
+		assert.NoError(err)
+		assert.Equal(hash, query.Parameters["hash"])
+	}
+	_, cached := cache.Get(testFinancialInstrumentUUID)
+	assert.False(cached)
+}
+
+func TestWriteWithUnknownDeprecatedIdentifierFails(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatal("No query should be run for an unknown deprecated identifier")
+			return nil
+		},
+	}
+
+	deprecatedFinancialInstrument := testFinancialInstrument
+	deprecatedFinancialInstrument.DeprecatedIdentifiers = []string{"BBG0066578X7"}
+
+	err := NewCypherFinancialInstrumentService(conn, conn).Write(deprecatedFinancialInstrument, test_trans_id)
+	assert.IsType(requestError{}, err)
+}
+
+func TestReadFields(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	fi, found, err := cypherDriver.ReadFields(testFinancialInstrumentUUID, []string{"prefLabel", "figiCode"})
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(map[string]interface{}{"uuid": testFinancialInstrumentUUID, "prefLabel": testFinancialInstrument.PrefLabel, "figiCode": figiCode}, fi)
+
+	fi, found, err = cypherDriver.ReadFields(testFinancialInstrumentUUID, []string{"issuedBy", "uuids", "tags", "currency"})
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(map[string]interface{}{"uuid": testFinancialInstrumentUUID, "issuedBy": orgUUID, "uuids": []interface{}{testFinancialInstrumentUUID}}, fi)
+
+	_, found, err = cypherDriver.ReadFields(testIncompleteFinancialInstrumentUUID, []string{"prefLabel"})
+	assert.NoError(err)
+	assert.False(found)
+}
+
+func TestReadFieldsQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	statements := []string{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			statements = append(statements, queries[0].Statement)
+			setQueryResult(queries[0], `[{"uuid": "`+testFinancialInstrumentUUID+`", "prefLabel": "GREENWICH", "figiCode": null, "uuids": []}]`)
+			return nil
+		},
+	}
+
+	fi, found, err := NewCypherFinancialInstrumentService(conn, conn, WithIdentifierLabel(figiIdentifierLabel, "FIGI")).ReadFields(testFinancialInstrumentUUID, []string{"uuids", "prefLabel", "figiCode"})
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(map[string]interface{}{"uuid": testFinancialInstrumentUUID, "prefLabel": "GREENWICH"}, fi, "Fields without a value should be left out")
+
+	assert.Len(statements, 1)
+	assert.Contains(statements[0], "OPTIONAL MATCH (v:UPPIdentifier)-[:IDENTIFIES]->(fi)")
+	assert.Contains(statements[0], "OPTIONAL MATCH (v:FIGI)-[:IDENTIFIES]->(fi)")
+	assert.Contains(statements[0], "RETURN fi.uuid as uuid, fi.prefLabel as prefLabel, uuids, figiCode")
+	assert.NotContains(statements[0], "currency")
+	assert.NotContains(statements[0], "ISSUED_BY")
+}
+
+func TestReadFieldsRejectsUnknownFields(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatalf("No query should be run for unknown fields, but ran %s", queries[0].Statement)
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	for _, fields := range [][]string{{"hash"}, {"prefLabel", "alternativeIdentifiers"}, {"prefLabel as x, fi.hash"}, {""}} {
+		_, _, err := cypherDriver.ReadFields(testFinancialInstrumentUUID, fields)
+		assert.IsType(requestError{}, err, "%v", fields)
+	}
+}
+
+func TestExportThenWriteStreamReproducesDataset(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	dataset := []financialInstrument{testFinancialInstrument, incompleteFinancialInstrument, specialCharactersFinancialInstrument}
+	for _, fi := range dataset {
+		assert.NoError(cypherDriver.Write(fi, test_trans_id), "Failed to create financial instrument")
+	}
+
+	dump := &bytes.Buffer{}
+	exported, err := cypherDriver.ExportAll(dump, true)
+	assert.NoError(err)
+	assert.Equal(3, exported)
+
+	cleanDB(db, assert)
+	written, err := cypherDriver.WriteStream(dump, test_trans_id, true)
+	assert.NoError(err)
+	assert.Equal(3, written)
+
+	for _, fi := range dataset {
+		readAndCompare(fi, t, db)
+	}
+}
+
+func TestExportAndWriteStreamRoundTripCompressed(t *testing.T) {
+	assert := assert.New(t)
+
+	exportConn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			// Only the first page has any financial instruments
+			if skip, paged := queries[0].Parameters["skip"]; !paged || skip == 0 {
+				setQueryResult(queries[0], testReadRow)
+			}
+			return nil
+		},
+	}
+	expected, found, err := NewCypherFinancialInstrumentService(exportConn, exportConn).ReadTyped(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+
+	for _, compressed := range []bool{true, false} {
+		dump := &bytes.Buffer{}
+		exported, err := NewCypherFinancialInstrumentService(exportConn, exportConn).ExportAll(dump, compressed)
+		assert.NoError(err)
+		assert.Equal(1, exported)
+
+		_, err = gzip.NewReader(bytes.NewReader(dump.Bytes()))
+		assert.Equal(compressed, err == nil, "The dump should be gzip compressed only when asked")
+
+		importConn := mockNeoConnection{
+			cypherBatch: func(queries []*neoism.CypherQuery) error {
+				return nil
+			},
+		}
+		sink := &recordingAuditSink{}
+		written, err := NewCypherFinancialInstrumentService(importConn, importConn, WithAuditSink(sink)).WriteStream(dump, test_trans_id, compressed)
+		assert.NoError(err)
+		assert.Equal(1, written)
+		assert.Len(sink.records, 1)
+		assert.Equal(expected, *sink.records[0].Payload)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	out := &bytes.Buffer{}
+	exported, err := cypherDriver.ExportCSV(out, []string{"prefLabel", "figiCode", "uuids"})
+	assert.NoError(err)
+	assert.Equal(2, exported)
+
+	rows, err := csv.NewReader(out).ReadAll()
+	assert.NoError(err)
+	assert.Equal([][]string{
+		{"uuid", "prefLabel", "figiCode", "uuids"},
+		{testFinancialInstrumentUUID, testFinancialInstrument.PrefLabel, figiCode, testFinancialInstrumentUUID},
+		{testIncompleteFinancialInstrumentUUID, "", "", testIncompleteFinancialInstrumentUUID},
+	}, rows)
+}
+
+func TestExportCSVFormatsValues(t *testing.T) {
+	assert := assert.New(t)
+
+	var statement string
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			statement = queries[0].Statement
+			if queries[0].Parameters["skip"] == 0 {
+				setQueryResult(queries[0], `[
+					{"uuid": "`+testFinancialInstrumentUUID+`", "prefLabel": "A, \"B\" & C", "uuids": ["`+testFinancialInstrumentUUID+`", "`+rekeyedFinancialInstrumentUUID+`"], "isTest": true, "trustLevel": 2},
+					{"uuid": "`+testIncompleteFinancialInstrumentUUID+`", "prefLabel": null, "uuids": [], "isTest": null, "trustLevel": null}]`)
+			}
+			return nil
+		},
+	}
+
+	out := &bytes.Buffer{}
+	exported, err := NewCypherFinancialInstrumentService(conn, conn, WithCSVListSeparator(";")).ExportCSV(out, []string{"prefLabel", "uuids", "isTest", "trustLevel"})
+	assert.NoError(err)
+	assert.Equal(2, exported)
+	assert.Equal("uuid,prefLabel,uuids,isTest,trustLevel\n"+
+		testFinancialInstrumentUUID+`,"A, ""B"" & C",`+testFinancialInstrumentUUID+";"+rekeyedFinancialInstrumentUUID+",true,2\n"+
+		testIncompleteFinancialInstrumentUUID+",,,,\n", out.String())
+	assert.Contains(statement, "WITH fi ORDER BY fi.uuid SKIP {skip} LIMIT {limit}")
+
+	_, err = NewCypherFinancialInstrumentService(conn, conn).ExportCSV(out, []string{"hash"})
+	assert.IsType(requestError{}, err)
+}
+
+func TestWriteStreamBatchesAndStopsAtInvalidInput(t *testing.T) {
+	assert := assert.New(t)
+
+	batches := 0
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			for _, query := range queries {
+				if _, ok := query.Parameters["props"]; ok {
+					batches++
+					break
+				}
+			}
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	stream := &bytes.Buffer{}
+	gz := gzip.NewWriter(stream)
+	enc := json.NewEncoder(gz)
+	for i := 0; i < streamBatchSize+1; i++ {
+		fi := financialInstrument{UUID: fmt.Sprintf("38431a92-dda3-4eb9-a367-%012d", i)}
+		fi.AlternativeIdentifiers.UUIDS = []string{fi.UUID}
+		assert.NoError(enc.Encode(fi))
+	}
+	assert.NoError(gz.Close())
+
+	written, err := cypherDriver.WriteStream(stream, test_trans_id, true)
+	assert.NoError(err)
+	assert.Equal(streamBatchSize+1, written)
+	assert.Equal(2, batches)
+
+	_, err = cypherDriver.WriteStream(strings.NewReader(`{"uuid": "`+testFinancialInstrumentUUID+`"}`), test_trans_id, true)
+	assert.IsType(requestError{}, err, "An uncompressed stream can't be read as compressed")
+
+	written, err = cypherDriver.WriteStream(strings.NewReader(`{"uuid": "`+testIncompleteFinancialInstrumentUUID+`", "alternativeIdentifiers": {"uuids": ["`+testIncompleteFinancialInstrumentUUID+`"]}}
+{"uuid": `), test_trans_id, false)
+	assert.IsType(requestError{}, err)
+	assert.Equal(0, written, "Nothing decoded before the invalid financial instrument should have been written yet")
+}
+
+func TestReadManyOrdered(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			assert.Len(queries, 1)
+			assert.Contains(queries[0].Statement, "WHERE fi.uuid IN {uuids}")
+			// Results come back in uuid order, not the order asked for
+			setQueryResult(queries[0], `[{"uuid": "`+testIncompleteFinancialInstrumentUUID+`", "prefLabel": "INCOMPLETE"},
+				{"uuid": "`+testFinancialInstrumentUUID+`", "prefLabel": "COMPLETE"}]`)
+			return nil
+		},
+	}
+
+	fis, err := NewCypherFinancialInstrumentService(conn, conn).ReadManyOrdered([]string{
+		testFinancialInstrumentUUID, rekeyedFinancialInstrumentUUID, testIncompleteFinancialInstrumentUUID, specialCharactersFinancialInstrumentUUID, testFinancialInstrumentUUID})
+	assert.NoError(err)
+	assert.Equal([]*financialInstrument{
+		{UUID: testFinancialInstrumentUUID, PrefLabel: "COMPLETE"},
+		nil,
+		{UUID: testIncompleteFinancialInstrumentUUID, PrefLabel: "INCOMPLETE"},
+		nil,
+		{UUID: testFinancialInstrumentUUID, PrefLabel: "COMPLETE"},
+	}, fis)
+}
+
+func TestReadManyOrderedWithoutUUIDs(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatal("No query should be run for no uuids")
+			return nil
+		},
+	}
+
+	fis, err := NewCypherFinancialInstrumentService(conn, conn).ReadManyOrdered([]string{})
+	assert.NoError(err)
+	assert.NotNil(fis)
+	assert.Empty(fis)
+
+	_, err = NewCypherFinancialInstrumentService(conn, conn).ReadManyOrdered([]string{"not-a-uuid"})
+	assert.IsType(requestError{}, err)
+}
+
+func TestWriteOnlyIfHigherTrustKeepsMoreTrustedVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	authoritative := testFinancialInstrument
+	authoritative.TrustLevel = 2
+	assert.NoError(cypherDriver.Write(authoritative, test_trans_id), "Failed to create financial instrument")
+
+	bestEffort := testFinancialInstrument
+	bestEffort.PrefLabel = "BEST EFFORT"
+	bestEffort.TrustLevel = 1
+	result, err := cypherDriver.WriteWithOptions(bestEffort, test_trans_id, WriteOptions{OnlyIfHigherTrust: true})
+	assert.NoError(err)
+	assert.Equal(WriteResult{Skipped: []string{testFinancialInstrumentUUID}}, result)
+	readAndCompare(authoritative, t, db)
+
+	bestEffort.TrustLevel = 2
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(bestEffort, test_trans_id, WriteOptions{OnlyIfHigherTrust: true})))
+	readAndCompare(bestEffort, t, db)
+}
+
+func TestWriteRequireExistingIssuerDoesNotCreateIssuer(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	_, err := cypherDriver.WriteWithOptions(testFinancialInstrument, test_trans_id, WriteOptions{RequireExistingIssuer: true})
+	assert.IsType(requestError{}, err)
+	_, found, err := cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.False(found)
+	_, labels, err := cypherDriver.ReadRaw(orgUUID)
+	assert.NoError(err)
+	assert.Nil(labels, "The issuer shouldn't have been created")
+
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(testFinancialInstrument, test_trans_id, WriteOptions{})), "Failed to create financial instrument")
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(testFinancialInstrument, test_trans_id, WriteOptions{RequireExistingIssuer: true})),
+		"The issuer created by the first write should now be found")
+	readAndCompare(testFinancialInstrument, t, db)
+}
+
+func TestWriteRequireExistingIssuerFindsIssuerWithoutIdentifier(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement:  `CREATE (:Thing {uuid:{uuid}})`,
+		Parameters: neoism.Props{"uuid": orgUUID},
+	}}))
+
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(testFinancialInstrument, test_trans_id, WriteOptions{RequireExistingIssuer: true})),
+		"An issuer that is a Thing without a UPPIdentifier still exists")
+	readAndCompare(testFinancialInstrument, t, db)
+
+	results := []struct {
+		Things int `json:"things"`
+	}{}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement:  `MATCH (t:Thing {uuid:{uuid}}) RETURN count(t) as things`,
+		Parameters: neoism.Props{"uuid": orgUUID},
+		Result:     &results,
+	}}))
+	assert.Equal([]struct {
+		Things int `json:"things"`
+	}{{1}}, results, "The issuer shouldn't have been duplicated")
+}
+
+func TestWriteWithSameIdempotencyKeyTwice(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	opts := WriteOptions{IdempotencyKey: "event-1"}
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(testFinancialInstrument, test_trans_id, opts)), "Failed to create financial instrument")
+
+	redelivered := testFinancialInstrument
+	redelivered.PrefLabel = "REDELIVERED"
+	result, err := cypherDriver.WriteWithOptions(redelivered, test_trans_id, opts)
+	assert.NoError(err)
+	assert.Equal(WriteResult{Duplicates: []string{testFinancialInstrumentUUID}}, result)
+	readAndCompare(testFinancialInstrument, t, db)
+
+	props, _, err := cypherDriver.ReadRaw(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Equal("event-1", props["idempotencyKey"])
+}
+
+func TestReadGroupedByIssuer(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	upToDateFinancialInstrument := specialCharactersFinancialInstrument
+	upToDateFinancialInstrument.IssuedBy = upToDateOrgUUID
+	otherFinancialInstrument := incompleteFinancialInstrument
+	otherFinancialInstrument.IssuedBy = orgUUID
+	for _, fi := range []financialInstrument{testFinancialInstrument, otherFinancialInstrument, upToDateFinancialInstrument} {
+		assert.NoError(cypherDriver.Write(fi, test_trans_id), "Failed to create financial instrument")
+	}
+
+	uuids := func(fis []financialInstrument) []string {
+		result := []string{}
+		for _, fi := range fis {
+			result = append(result, fi.UUID)
+		}
+		return result
+	}
+
+	unknownOrgUUID := "0ef60747-3ea7-4bc4-8a4c-8bd1c5e2a2cf"
+	grouped, err := cypherDriver.ReadGroupedByIssuer([]string{orgUUID, upToDateOrgUUID, unknownOrgUUID})
+	assert.NoError(err)
+	assert.Len(grouped, 3)
+	expected := []string{testFinancialInstrumentUUID, testIncompleteFinancialInstrumentUUID}
+	sort.Strings(expected)
+	assert.Equal(expected, uuids(grouped[orgUUID]))
+	assert.Equal([]string{specialCharactersFinancialInstrumentUUID}, uuids(grouped[upToDateOrgUUID]))
+	assert.Empty(grouped[unknownOrgUUID])
+}
+
+func TestReadSiblings(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(specialCharactersFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	siblings, err := cypherDriver.ReadSiblings(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Len(siblings, 1)
+	assert.Equal(specialCharactersFinancialInstrumentUUID, siblings[0].UUID)
+
+	siblings, err = cypherDriver.ReadSiblings(testIncompleteFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.NotNil(siblings)
+	assert.Empty(siblings)
+}
+
+func TestReadDetail(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(specialCharactersFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement:  `MATCH (org:Thing {uuid:{uuid}}) SET org.prefLabel = "Greenwich Capital"`,
+		Parameters: map[string]interface{}{"uuid": orgUUID},
+	}}))
+
+	detail, found, err := cypherDriver.ReadDetail(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(testFinancialInstrumentUUID, detail.FinancialInstrument.UUID)
+	assert.Equal(orgUUID, detail.FinancialInstrument.IssuedBy)
+	assert.Equal("Greenwich Capital", detail.IssuerPrefLabel)
+	assert.Equal(1, detail.SiblingCount)
+
+	detail, found, err = cypherDriver.ReadDetail(testIncompleteFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(testIncompleteFinancialInstrumentUUID, detail.FinancialInstrument.UUID)
+	assert.Empty(detail.IssuerPrefLabel)
+	assert.Equal(0, detail.SiblingCount)
+
+	_, found, err = cypherDriver.ReadDetail(rekeyedFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.False(found)
+}
+
+func TestReadDetailUsesOneBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	batches := 0
+	issuer := `[{"prefLabel": "Greenwich Capital"}]`
+	siblings := `[{"count": 2}]`
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			batches++
+			assert.Len(queries, 3)
+			setQueryResult(queries[0], `[{"uuid": "`+testFinancialInstrumentUUID+`", "prefLabel": "GREENWICH CAP ACCEPTANCE  1991-B B1"}]`)
+			setQueryResult(queries[1], issuer)
+			setQueryResult(queries[2], siblings)
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	detail, found, err := cypherDriver.ReadDetail(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(1, batches)
+	assert.Equal(testFinancialInstrumentUUID, detail.FinancialInstrument.UUID)
+	assert.Equal("Greenwich Capital", detail.IssuerPrefLabel)
+	assert.Equal(2, detail.SiblingCount)
+
+	// Without an issuer there is no issuer row, and its siblings are counted as 0
+	issuer = `[]`
+	siblings = `[{"count": 0}]`
+	detail, found, err = cypherDriver.ReadDetail(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Empty(detail.IssuerPrefLabel)
+	assert.Equal(0, detail.SiblingCount)
+}
+
+func TestReadWithRelated(t *testing.T) {
+	assert := assert.New(t)
+
+	underlyingUUID := "0b8fce67-2bd0-4a2c-8f47-0b9b2f3c8b51"
+	statements := []string{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			statements = append(statements, queries[0].Statement)
+			if len(statements) == 1 {
+				setQueryResult(queries[0], testReadRow)
+			} else {
+				setQueryResult(queries[0], `[{"uuid": "`+underlyingUUID+`", "prefLabel": "UNDERLYING"}]`)
+			}
+			return nil
+		},
+	}
+
+	fi, related, found, err := NewCypherFinancialInstrumentService(conn, conn).ReadWithRelated(testFinancialInstrumentUUID, 2)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(testFinancialInstrumentUUID, fi.UUID)
+	assert.Equal([]financialInstrument{{UUID: underlyingUUID, PrefLabel: "UNDERLYING"}}, related)
+	assert.Len(statements, 2)
+	assert.Contains(statements[1], "[:UNDERLIES|HAS_UNDERLYING*1..2]")
+}
+
+func TestReadWithRelatedNotFound(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			assert.Len(queries, 1)
+			assert.NotContains(queries[0].Statement, "UNDERLIES", "Related financial instruments should not be read when there is no financial instrument")
+			return nil
+		},
+	}
+
+	_, related, found, err := NewCypherFinancialInstrumentService(conn, conn).ReadWithRelated(testFinancialInstrumentUUID, 1)
+	assert.NoError(err)
+	assert.False(found)
+	assert.Nil(related)
+}
+
+func TestReadWithRelatedRejectsInvalidDepth(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatal("No query should be run for an invalid depth")
+			return nil
+		},
+	}
+
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+	for _, depth := range []int{-1, 0, maxRelatedDepth + 1, 100} {
+		_, _, _, err := cypherDriver.ReadWithRelated(testFinancialInstrumentUUID, depth)
+		assert.IsType(requestError{}, err, "depth %d", depth)
+	}
+}
+
+func TestReadIssuedBetween(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	earlyFinancialInstrument := testFinancialInstrument
+	earlyFinancialInstrument.IssueDate = "1991-01-01"
+	lateFinancialInstrument := specialCharactersFinancialInstrument
+	lateFinancialInstrument.IssueDate = "1991-12-31"
+	assert.NoError(cypherDriver.Write(earlyFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(lateFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	readAndCompare(earlyFinancialInstrument, t, db)
+
+	issuedBetween := func(from string, to string) []string {
+		fromDate, _ := time.Parse(issueDateLayout, from)
+		toDate, _ := time.Parse(issueDateLayout, to)
+		found, err := cypherDriver.ReadIssuedBetween(fromDate, toDate, 0, 10)
+		assert.NoError(err)
+		uuids := []string{}
+		for _, fi := range found {
+			uuids = append(uuids, fi.UUID)
+		}
+		return uuids
+	}
+
+	assert.Equal([]string{testFinancialInstrumentUUID, specialCharactersFinancialInstrumentUUID}, issuedBetween("1991-01-01", "1991-12-31"))
+	assert.Equal([]string{specialCharactersFinancialInstrumentUUID}, issuedBetween("1991-01-02", "1991-12-31"))
+	assert.Equal([]string{testFinancialInstrumentUUID}, issuedBetween("1990-01-01", "1991-12-30"))
+	assert.Empty(issuedBetween("1992-01-01", "1992-12-31"))
+}
+
+func TestWriteWithInvalidIssueDateFails(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatal("No query should be run for an invalid issue date")
+			return nil
+		},
+	}
+
+	for _, issueDate := range []string{"1991-13-01", "01/06/1991", "1991-06-01T00:00:00Z"} {
+		invalidFinancialInstrument := testFinancialInstrument
+		invalidFinancialInstrument.IssueDate = issueDate
+		assert.IsType(requestError{}, NewCypherFinancialInstrumentService(conn, conn).Write(invalidFinancialInstrument, test_trans_id), issueDate)
+	}
+}
+
+func TestCountChangedBetween(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	// Well in the past, so nothing else in the graph was modified at the same times
+	midnight := time.Date(1991, 6, 1, 0, 0, 0, 0, time.UTC)
+	justBeforeMidnight := midnight.Add(-time.Millisecond)
+
+	atMidnightFinancialInstrument := testFinancialInstrument
+	atMidnightFinancialInstrument.LastModified = &midnight
+	beforeMidnightFinancialInstrument := incompleteFinancialInstrument
+	beforeMidnightFinancialInstrument.LastModified = &justBeforeMidnight
+	assert.NoError(cypherDriver.Write(atMidnightFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(beforeMidnightFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	countChangedBetween := func(from time.Time, to time.Time) int {
+		count, err := cypherDriver.CountChangedBetween(from, to)
+		assert.NoError(err)
+		return count
+	}
+
+	day := 24 * time.Hour
+	assert.Equal(1, countChangedBetween(midnight, midnight.Add(day)))
+	assert.Equal(1, countChangedBetween(midnight.Add(-day), midnight))
+	assert.Equal(2, countChangedBetween(justBeforeMidnight, midnight.Add(time.Millisecond)))
+	assert.Equal(0, countChangedBetween(midnight.Add(time.Millisecond), midnight.Add(day)))
+}
+
+func TestPatchSingleField(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	found, err := cypherDriver.Patch(testFinancialInstrumentUUID, map[string]interface{}{"prefLabel": "PATCHED"})
+	assert.NoError(err)
+	assert.False(found)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	found, err = cypherDriver.Patch(testFinancialInstrumentUUID, map[string]interface{}{"prefLabel": "PATCHED"})
+	assert.NoError(err)
+	assert.True(found)
+
+	patched := testFinancialInstrument
+	patched.PrefLabel = "PATCHED"
+	readAndCompare(patched, t, db)
+
+	expectedHash, err := writeHash(patched)
+	assert.NoError(err)
+	hash, _, err := cypherDriver.ReadHash(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Equal(expectedHash, hash)
+}
+
+func TestPatchReadsAroundTheCache(t *testing.T) {
+	assert := assert.New(t)
+
+	patches := []*neoism.CypherQuery{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			if strings.Contains(queries[0].Statement, "SET fi += {props}") {
+				patches = append(patches, queries[0])
+				return nil
+			}
+			setQueryResult(queries[0], testReadRow)
+			return nil
+		},
+	}
+	cache := NewLRUCache(10)
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithCache(cache))
+
+	stale := testFinancialInstrument
+	stale.Currency = "USD"
+	cache.Set(testFinancialInstrumentUUID, stale)
+
+	found, err := cypherDriver.Patch(testFinancialInstrumentUUID, map[string]interface{}{"prefLabel": "PATCHED"})
+	assert.NoError(err)
+	assert.True(found)
+	if assert.Len(patches, 1) {
+		patched, _, err := cypherDriver.readStored(testFinancialInstrumentUUID)
+		assert.NoError(err)
+		patched.PrefLabel = "PATCHED"
+		hash, err := hashOf(patched)
+		assert.NoError(err)
+		assert.Equal(hash, patches[0].Parameters["props"].(map[string]interface{})["hash"], "The hash should be of the stored, not the cached, version")
+	}
+}
+
+func TestPatchRejectsUUIDAndUnknownProperties(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			if len(queries) == 1 && queries[0].Result != nil {
+				setQueryResult(queries[0], `[{"uuid": "`+testFinancialInstrumentUUID+`"}]`)
+				return nil
+			}
+			t.Fatal("An invalid patch should not be written")
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	_, err := cypherDriver.Patch(testFinancialInstrumentUUID, map[string]interface{}{"uuid": testIncompleteFinancialInstrumentUUID})
+	assert.IsType(requestError{}, err)
+
+	_, err = cypherDriver.Patch(testFinancialInstrumentUUID, map[string]interface{}{"issuedBy": orgUUID})
+	assert.IsType(requestError{}, err)
+
+	_, err = cypherDriver.Patch(testFinancialInstrumentUUID, map[string]interface{}{"prefLabel": 42})
+	assert.IsType(requestError{}, err)
+
+	_, err = cypherDriver.Patch(testFinancialInstrumentUUID, map[string]interface{}{"currency": "XYZ"})
+	assert.IsType(requestError{}, err)
+}
+
 func TestWriteFinancialInstrumentsWithSameFacsetIdentifierFails(t *testing.T) {
 	assert := assert.New(t)
 
@@ -148,91 +2530,3287 @@ func TestWriteFinancialInstrumentsWithSameFacsetIdentifierFails(t *testing.T) {
 	cypherDriver := getCypherDriver(db)
 	defer cleanDB(db, assert)
 
-	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
-
-	duplicateFinancialInstrument := financialInstrument{
-		UUID:      duplicateFinancialInstrumentUUID,
-		PrefLabel: testFinancialInstrument.PrefLabel,
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	duplicateFinancialInstrument := financialInstrument{
+		UUID:      duplicateFinancialInstrumentUUID,
+		PrefLabel: testFinancialInstrument.PrefLabel,
+		AlternativeIdentifiers: alternativeIdentifiers{
+			UUIDS:             []string{duplicateFinancialInstrumentUUID},
+			FactsetIdentifier: testFinancialInstrument.AlternativeIdentifiers.FactsetIdentifier,
+		},
+	}
+	err := cypherDriver.Write(duplicateFinancialInstrument, test_trans_id)
+	assert.Error(err)
+	assert.IsType(rwapi.ConstraintOrTransactionError{}, err)
+}
+
+func TestWriteFinancialInstrumentsWithSameFigiCodesFails(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	duplicateFinancialInstrument := financialInstrument{
+		UUID:      duplicateFinancialInstrumentUUID,
+		PrefLabel: testFinancialInstrument.PrefLabel,
+		AlternativeIdentifiers: alternativeIdentifiers{
+			UUIDS:    []string{duplicateFinancialInstrumentUUID},
+			FIGICode: testFinancialInstrument.AlternativeIdentifiers.FIGICode,
+		},
+	}
+	err := cypherDriver.Write(duplicateFinancialInstrument, test_trans_id)
+	assert.Error(err)
+	assert.IsType(rwapi.ConstraintOrTransactionError{}, err)
+}
+
+func TestWriteWithAlternativeUUIDOfAnotherFinancialInstrumentFails(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	collidingFinancialInstrument := financialInstrument{
+		UUID: testIncompleteFinancialInstrumentUUID,
+		AlternativeIdentifiers: alternativeIdentifiers{
+			UUIDS: []string{testIncompleteFinancialInstrumentUUID, testFinancialInstrumentUUID},
+		},
+	}
+	err := cypherDriver.Write(collidingFinancialInstrument, test_trans_id)
+	assert.IsType(ConflictError{}, err)
+
+	_, found, err := cypherDriver.Read(testIncompleteFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.False(found)
+}
+
+func TestWriteBatchWithAlternativeUUIDOfAnotherFinancialInstrumentInTheBatchFails(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatal("No query should be run for a conflicting batch")
+			return nil
+		},
+	}
+
+	collidingFinancialInstrument := financialInstrument{
+		UUID: testIncompleteFinancialInstrumentUUID,
+		AlternativeIdentifiers: alternativeIdentifiers{
+			UUIDS: []string{testIncompleteFinancialInstrumentUUID, testFinancialInstrumentUUID},
+		},
+	}
+	_, err := NewCypherFinancialInstrumentService(conn, conn).WriteBatch([]financialInstrument{testFinancialInstrument, collidingFinancialInstrument}, test_trans_id, WriteOptions{})
+	assert.IsType(ConflictError{}, err)
+}
+
+func TestWriteSelfIssuedFinancialInstrumentFails(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatal("No query should be run for a self issued financial instrument")
+			return nil
+		},
+	}
+
+	selfIssuedFinancialInstrument := testFinancialInstrument
+	selfIssuedFinancialInstrument.IssuedBy = testFinancialInstrumentUUID
+
+	err := NewCypherFinancialInstrumentService(conn, conn).Write(selfIssuedFinancialInstrument, test_trans_id)
+	assert.IsType(requestError{}, err)
+}
+
+func TestWriteWithInvalidIssuerFails(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatal("No query should be run for an invalid issuer")
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	for _, issuedBy := range []string{" ", "\t\n", "not-a-uuid", orgUUID + "0"} {
+		invalidIssuerFinancialInstrument := testFinancialInstrument
+		invalidIssuerFinancialInstrument.IssuedBy = issuedBy
+		assert.IsType(requestError{}, cypherDriver.Write(invalidIssuerFinancialInstrument, test_trans_id), issuedBy)
+	}
+}
+
+func TestWriteDedupesAlternativeUUIDs(t *testing.T) {
+	assert := assert.New(t)
+
+	uppIdentifierWrites := 0
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			for _, query := range queries {
+				if strings.Contains(query.Statement, "UPPIdentifier") && query.Parameters["value"] == testFinancialInstrumentUUID {
+					uppIdentifierWrites++
+				}
+			}
+			return nil
+		},
+	}
+
+	duplicatedFinancialInstrument := testFinancialInstrument
+	duplicatedFinancialInstrument.AlternativeIdentifiers.UUIDS = []string{testFinancialInstrumentUUID, testFinancialInstrumentUUID}
+	assert.NoError(NewCypherFinancialInstrumentService(conn, conn).Write(duplicatedFinancialInstrument, test_trans_id))
+	assert.Equal(1, uppIdentifierWrites)
+}
+
+func TestWriteWithDuplicateAlternativeUUIDCreatesOneIdentifier(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	duplicatedFinancialInstrument := testFinancialInstrument
+	duplicatedFinancialInstrument.AlternativeIdentifiers.UUIDS = []string{testFinancialInstrumentUUID, testFinancialInstrumentUUID}
+	duplicatedFinancialInstrument.Aliases = []string{"GCA 1991-B B1", "GCA 1991-B B1"}
+	assert.NoError(cypherDriver.Write(duplicatedFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	results := []struct {
+		Count int `json:"count"`
+	}{}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement:  `MATCH (i:UPPIdentifier {value:{uuid}}) RETURN count(i) as count`,
+		Parameters: map[string]interface{}{"uuid": testFinancialInstrumentUUID},
+		Result:     &results,
+	}}))
+	assert.Equal(1, results[0].Count)
+
+	expected := testFinancialInstrument
+	expected.Aliases = []string{"GCA 1991-B B1"}
+	readAndCompare(expected, t, db)
+}
+
+func TestWriteTrimsIssuer(t *testing.T) {
+	assert := assert.New(t)
+
+	orgUUIDs := []interface{}{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			for _, query := range queries {
+				if orgUUID, ok := query.Parameters["orgUuid"]; ok {
+					orgUUIDs = append(orgUUIDs, orgUUID)
+				}
+			}
+			return nil
+		},
+	}
+
+	paddedIssuerFinancialInstrument := testFinancialInstrument
+	paddedIssuerFinancialInstrument.IssuedBy = " " + orgUUID + "\n"
+	assert.NoError(NewCypherFinancialInstrumentService(conn, conn).Write(paddedIssuerFinancialInstrument, test_trans_id))
+	assert.Equal([]interface{}{orgUUID}, orgUUIDs)
+}
+
+func TestWriteWithIssuerLabelRejectsIssuersIdentifyingOtherThings(t *testing.T) {
+	assert := assert.New(t)
+
+	const personUUID = "d3b3a1a2-9c3f-4a6e-8d55-21b5c4a1f0e7"
+	writeBatches := 0
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			if _, ok := queries[0].Parameters["values"]; ok {
+				assert.Contains(queries[0].Statement, "org:`Organisation` as isIssuer")
+				setQueryResult(queries[0], `[{"value": "`+orgUUID+`", "uuid": "`+personUUID+`", "isIssuer": false}]`)
+				return nil
+			}
+			if queries[0].Result == nil {
+				writeBatches++
+			}
+			return nil
+		},
+	}
+
+	err := NewCypherFinancialInstrumentService(conn, conn, WithIssuerLabel("Organisation")).Write(testFinancialInstrument, test_trans_id)
+	assert.IsType(requestError{}, err)
+	assert.Equal(0, writeBatches)
+}
+
+func TestWriteWithIssuerLabelLinksToIssuersWithTheLabel(t *testing.T) {
+	assert := assert.New(t)
+
+	const personUUID = "d3b3a1a2-9c3f-4a6e-8d55-21b5c4a1f0e7"
+	linkedTo := []interface{}{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			if _, ok := queries[0].Parameters["values"]; ok {
+				setQueryResult(queries[0], `[{"value": "`+orgUUID+`", "uuid": "`+personUUID+`", "isIssuer": false},
+					{"value": "`+orgUUID+`", "uuid": "`+upToDateOrgUUID+`", "isIssuer": true}]`)
+				return nil
+			}
+			for _, query := range queries {
+				if orgUUID, ok := query.Parameters["orgUuid"]; ok {
+					assert.Contains(query.Statement, "o:`Organisation`")
+					linkedTo = append(linkedTo, orgUUID)
+				}
+			}
+			return nil
+		},
+	}
+
+	assert.NoError(NewCypherFinancialInstrumentService(conn, conn, WithIssuerLabel("Organisation")).Write(testFinancialInstrument, test_trans_id))
+	assert.Equal([]interface{}{upToDateOrgUUID}, linkedTo)
+}
+
+func TestWithIssuerLabelIsQuoted(t *testing.T) {
+	assert := assert.New(t)
+
+	statements := []string{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			for _, query := range queries {
+				statements = append(statements, query.Statement)
+			}
+			return nil
+		},
+	}
+
+	label := "Organisation`) DETACH DELETE o //"
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithIssuerLabel(label))
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id))
+	_, err := cypherDriver.ReadByIssuerIdentifier(uppIdentifierLabel, orgUUID, 0, 10)
+	assert.NoError(err)
+	_, err = cypherDriver.RepointIssuers(map[string]string{orgUUID: upToDateOrgUUID})
+	assert.NoError(err)
+
+	quoted := 0
+	for _, statement := range statements {
+		assert.NotContains(strings.Replace(statement, "``", "", -1), "`) DETACH DELETE", statement)
+		if strings.Contains(statement, "`Organisation``) DETACH DELETE o //`") {
+			quoted++
+		}
+	}
+	assert.Equal(5, quoted, "The label should be quoted wherever it is used")
+}
+
+func TestWithIdentifierLabelRejectsLabelsThatAreNotPlain(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, label := range []string{"", "UPP Identifier", "UPPId) DETACH DELETE (fi", "`UPPId`", "1UPPId", "UPPId:Identifier"} {
+		assert.Panics(func() { WithIdentifierLabel(uppIdentifierLabel, label) }, label)
+	}
+	for _, label := range []string{"UPPId", "FIGI", "Legacy_UPP_Identifier2"} {
+		assert.NotPanics(func() { WithIdentifierLabel(uppIdentifierLabel, label) }, label)
+	}
+}
+
+func TestVerifyIdentifierCardinality(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{createNewIdentifierQuery(testFinancialInstrumentUUID, figiIdentifierLabel, "BBG0066578X7")}))
+
+	type violation struct {
+		identifierType string
+		values         []string
+	}
+	violations := map[string]violation{}
+	assert.NoError(cypherDriver.VerifyIdentifierCardinality(func(uuid string, identifierType string, values []string) (bool, error) {
+		violations[uuid] = violation{identifierType, values}
+		return true, nil
+	}))
+
+	assert.Equal(violation{figiIdentifierLabel, []string{"BBG0066578X7", figiCode}}, violations[testFinancialInstrumentUUID])
+	assert.NotContains(violations, testIncompleteFinancialInstrumentUUID)
+}
+
+func TestFindMislabelledIdentifiers(t *testing.T) {
+	assert := assert.New(t)
+
+	rows := map[string]string{
+		uppIdentifierLabel:     `[{"uuid": "` + testFinancialInstrumentUUID + `", "value": "` + testFinancialInstrumentUUID + `"}]`,
+		factsetIdentifierLabel: `[{"uuid": "` + testFinancialInstrumentUUID + `", "value": "` + facsetIdentifier + `"}]`,
+		figiIdentifierLabel: `[{"uuid": "` + testFinancialInstrumentUUID + `", "value": "` + figiCode + `"},
+			{"uuid": "` + testIncompleteFinancialInstrumentUUID + `", "value": "US0378331005"}]`,
+		wsodIdentifierLabel: `[{"uuid": "` + testFinancialInstrumentUUID + `", "value": "B000BB-S"}]`,
+	}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			if queries[0].Parameters["skip"] != 0 {
+				return nil
+			}
+			for identifierType, typeRows := range rows {
+				if strings.Contains(queries[0].Statement, "(i:"+identifierType+")") {
+					setQueryResult(queries[0], typeRows)
+				}
+			}
+			return nil
+		},
+	}
+
+	found := []string{}
+	err := NewCypherFinancialInstrumentService(conn, conn).FindMislabelledIdentifiers(func(uuid string, label string, value string) (bool, error) {
+		found = append(found, uuid+" "+label+" "+value)
+		return true, nil
+	})
+	assert.NoError(err)
+	assert.Equal([]string{
+		testIncompleteFinancialInstrumentUUID + " " + figiIdentifierLabel + " US0378331005",
+		testFinancialInstrumentUUID + " " + wsodIdentifierLabel + " B000BB-S",
+	}, found)
+}
+
+func TestFindDuplicateByIdentifier(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			assert.Contains(queries[0].Statement, "(i:FIGIIdentifier)")
+			if queries[0].Parameters["skip"] == 0 {
+				setQueryResult(queries[0], `[{"value": "`+figiCode+`", "uuids": ["`+testIncompleteFinancialInstrumentUUID+`", "`+testFinancialInstrumentUUID+`"]},
+					{"value": "BBG0066578X7", "uuids": ["`+testFinancialInstrumentUUID+`", "`+specialCharactersFinancialInstrumentUUID+`"]}]`)
+			}
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	duplicates := map[string][]string{}
+	assert.NoError(cypherDriver.FindDuplicateByIdentifier(figiIdentifierLabel, func(value string, uuids []string) (bool, error) {
+		duplicates[value] = uuids
+		return true, nil
+	}))
+	assert.Len(duplicates, 2)
+	assert.True(sort.StringsAreSorted(duplicates[figiCode]))
+
+	calls := 0
+	assert.NoError(cypherDriver.FindDuplicateByIdentifier(figiIdentifierLabel, func(value string, uuids []string) (bool, error) {
+		calls++
+		return false, nil
+	}))
+	assert.Equal(1, calls)
+
+	assert.IsType(requestError{}, cypherDriver.FindDuplicateByIdentifier("ISIN", func(value string, uuids []string) (bool, error) {
+		return true, nil
+	}))
+}
+
+func TestFindSelfIssued(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	corrupt := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument {uuid:{uuid}}) MERGE (fi)-[:ISSUED_BY]->(fi)`,
+		Parameters: neoism.Props{
+			"uuid": testIncompleteFinancialInstrumentUUID,
+		},
+	}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{corrupt}))
+
+	found := []string{}
+	err := cypherDriver.FindSelfIssued(func(uuid string) (bool, error) {
+		found = append(found, uuid)
+		return true, nil
+	})
+	assert.NoError(err)
+	assert.Contains(found, testIncompleteFinancialInstrumentUUID)
+	assert.NotContains(found, testFinancialInstrumentUUID)
+}
+
+func TestFindSelfIssuedFindsAllWhileRepairing(t *testing.T) {
+	assert := assert.New(t)
+
+	selfIssued := []string{}
+	for i := 0; i < batchSize+2; i++ {
+		selfIssued = append(selfIssued, fmt.Sprintf("%08d-0000-0000-0000-000000000000", i))
+	}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			assert.NotContains(queries[0].Statement, "SKIP")
+			lastUUID := queries[0].Parameters["lastUUID"].(string)
+			rows := []string{}
+			for _, uuid := range selfIssued {
+				if uuid > lastUUID && len(rows) < batchSize {
+					rows = append(rows, `{"uuid": "`+uuid+`"}`)
+				}
+			}
+			setQueryResult(queries[0], "["+strings.Join(rows, ",")+"]")
+			return nil
+		},
+	}
+
+	found := []string{}
+	err := NewCypherFinancialInstrumentService(conn, conn).FindSelfIssued(func(uuid string) (bool, error) {
+		found = append(found, uuid)
+		// Repairing a financial instrument means it is no longer found
+		for i, selfIssuedUUID := range selfIssued {
+			if selfIssuedUUID == uuid {
+				selfIssued = append(selfIssued[:i], selfIssued[i+1:]...)
+				break
+			}
+		}
+		return true, nil
+	})
+	assert.NoError(err)
+	assert.Len(found, batchSize+2)
+	assert.Empty(selfIssued)
+}
+
+func TestFindIssuedByMissingOrg(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	upToDateFinancialInstrument := specialCharactersFinancialInstrument
+	upToDateFinancialInstrument.IssuedBy = upToDateOrgUUID
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(upToDateFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	// Only the up to date organisation has been written by its own writer
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement:  `MATCH (org:Thing {uuid:{uuid}}) SET org:Concept:Organisation`,
+		Parameters: map[string]interface{}{"uuid": upToDateOrgUUID},
+	}}))
+
+	found := map[string]string{}
+	assert.NoError(cypherDriver.FindIssuedByMissingOrg(func(instrumentUUID string, orgUUID string) (bool, error) {
+		found[instrumentUUID] = orgUUID
+		return true, nil
+	}))
+	assert.Equal(map[string]string{testFinancialInstrumentUUID: orgUUID}, found)
+}
+
+func TestFindIdentifierResolutionLoops(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(specialCharactersFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	identify := func(value string, uuid string) *neoism.CypherQuery {
+		return &neoism.CypherQuery{
+			Statement:  `MATCH (i:UPPIdentifier {value:{value}}), (fi:FinancialInstrument {uuid:{uuid}}) MERGE (i)-[:IDENTIFIES]->(fi)`,
+			Parameters: map[string]interface{}{"value": value, "uuid": uuid},
+		}
+	}
+	// The test and special characters financial instruments each list the other's uuid, the incomplete one only lists the test one's
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{
+		identify(specialCharactersFinancialInstrumentUUID, testFinancialInstrumentUUID),
+		identify(testFinancialInstrumentUUID, specialCharactersFinancialInstrumentUUID),
+		identify(testFinancialInstrumentUUID, testIncompleteFinancialInstrumentUUID),
+	}))
+
+	loops := [][]string{}
+	assert.NoError(cypherDriver.FindIdentifierResolutionLoops(func(a string, b string) (bool, error) {
+		loops = append(loops, []string{a, b})
+		return true, nil
+	}))
+	expected := []string{testFinancialInstrumentUUID, specialCharactersFinancialInstrumentUUID}
+	sort.Strings(expected)
+	assert.Equal([][]string{expected}, loops)
+}
+
+func TestRekey(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	assert.NoError(cypherDriver.Rekey(testFinancialInstrumentUUID, rekeyedFinancialInstrumentUUID))
+
+	_, found, err := cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.False(found)
+
+	rekeyed := testFinancialInstrument
+	rekeyed.UUID = rekeyedFinancialInstrumentUUID
+	rekeyed.AlternativeIdentifiers.UUIDS = []string{rekeyedFinancialInstrumentUUID}
+	readAndCompare(rekeyed, t, db)
+
+	uuid, found, err := cypherDriver.ResolveUUID(figiIdentifierLabel, figiCode)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(rekeyedFinancialInstrumentUUID, uuid)
+}
+
+func TestRekeyToUUIDInUseFails(t *testing.T) {
+	assert := assert.New(t)
+
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+
+	err := cypherDriver.Rekey(testFinancialInstrumentUUID, testIncompleteFinancialInstrumentUUID)
+	assert.IsType(ConflictError{}, err)
+
+	readAndCompare(testFinancialInstrument, t, db)
+	readAndCompare(incompleteFinancialInstrument, t, db)
+}
+
+func TestRekeyRejectsAnInvalidNewUUID(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatalf("An invalid uuid should be rejected before running %s", queries[0].Statement)
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	for _, newUUID := range []string{"", "not-a-uuid", rekeyedFinancialInstrumentUUID + "x"} {
+		assert.IsType(requestError{}, cypherDriver.Rekey(testFinancialInstrumentUUID, newUUID), newUUID)
+	}
+}
+
+func TestRekeyChecksTheNewUUIDIsFreeInTheRekey(t *testing.T) {
+	assert := assert.New(t)
+
+	rekeyed := ""
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			if strings.Contains(queries[0].Statement, "SET fi.uuid = {newUUID}") {
+				setQueryResult(queries[0], rekeyed)
+				return nil
+			}
+			setQueryResult(queries[0], testReadRow)
+			return nil
+		},
+	}
+	cache := NewLRUCache(10)
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithCache(cache))
+
+	rekeyed = `[{"inUse": true}]`
+	assert.IsType(ConflictError{}, cypherDriver.Rekey(testFinancialInstrumentUUID, rekeyedFinancialInstrumentUUID))
+
+	rekeyed = `[]`
+	assert.IsType(requestError{}, cypherDriver.Rekey(testFinancialInstrumentUUID, rekeyedFinancialInstrumentUUID), "A financial instrument deleted before the rekey should not be reported as rekeyed")
+
+	rekeyed = `[{"inUse": false}]`
+	assert.NoError(cypherDriver.Rekey(testFinancialInstrumentUUID, rekeyedFinancialInstrumentUUID))
+}
+
+func TestRekeyReadsAroundTheCache(t *testing.T) {
+	assert := assert.New(t)
+
+	rekeys := []*neoism.CypherQuery{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			if strings.Contains(queries[0].Statement, "SET fi.uuid = {newUUID}") {
+				rekeys = append(rekeys, queries[0])
+				setQueryResult(queries[0], `[{"inUse": false}]`)
+				return nil
+			}
+			setQueryResult(queries[0], testReadRow)
+			return nil
+		},
+	}
+	cache := NewLRUCache(10)
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithCache(cache))
+
+	stale := testFinancialInstrument
+	stale.Currency = "USD"
+	cache.Set(testFinancialInstrumentUUID, stale)
+
+	assert.NoError(cypherDriver.Rekey(testFinancialInstrumentUUID, rekeyedFinancialInstrumentUUID))
+	if assert.Len(rekeys, 1) {
+		rekeyed, _, err := cypherDriver.readStored(testFinancialInstrumentUUID)
+		assert.NoError(err)
+		rekeyed.UUID = rekeyedFinancialInstrumentUUID
+		rekeyed.AlternativeIdentifiers.UUIDS = []string{rekeyedFinancialInstrumentUUID}
+		hash, err := hashOf(rekeyed)
+		assert.NoError(err)
+		assert.Equal(hash, rekeys[0].Parameters["hash"], "The hash should be of the stored, not the cached, version")
+	}
+}
+
+func TestDeletingNotExistingFinancialInstrument(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	defer cleanDB(db, assert)
+
+	cypherDriver := getCypherDriver(db)
+	res, err := cypherDriver.Delete(testFinancialInstrumentUUID, test_trans_id)
+
+	assert.NoError(err)
+	assert.False(res)
+}
+
+func TestDelete(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write person")
+
+	found, err := cypherDriver.Delete(testFinancialInstrumentUUID, test_trans_id)
+	assert.True(found, "Failed to delete financial instrument with uuid: %s", testFinancialInstrumentUUID)
+	assert.NoError(err, "Error occurred while trying to delete financial instrument with uuid: %s", testFinancialInstrumentUUID)
+
+	fi, found, err := cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id)
+	assert.Equal(financialInstrument{}, fi, "The financial instrument with uuid: %s should have been deleted.", testFinancialInstrumentUUID)
+	assert.False(found, "Found financial instrument for uuid: %s which should have been deleted", testFinancialInstrumentUUID)
+	assert.NoError(err, "Error trying to find financial instrument for uuid: %s", testFinancialInstrumentUUID)
+
+}
+
+func TestDeleteKeepsOtherConcept(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement:  `MATCH (t:FinancialInstrument {uuid:{uuid}}) SET t:Organisation`,
+		Parameters: map[string]interface{}{"uuid": testFinancialInstrumentUUID},
+	}}))
+
+	found, err := cypherDriver.Delete(testFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.True(found)
+
+	props, labels, err := cypherDriver.ReadRaw(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Equal(testFinancialInstrumentUUID, props["uuid"])
+	assert.Equal(testFinancialInstrument.PrefLabel, props["prefLabel"])
+	assert.Nil(props["hash"])
+	assert.Nil(props["currency"])
+	assert.Contains(labels, "Concept")
+	assert.Contains(labels, "Organisation")
+	assert.NotContains(labels, "FinancialInstrument")
+
+	_, found, err = cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.False(found)
+}
+
+//writeReferencedFinancialInstrument writes testFinancialInstrument and has the topic mention it,
+//so that its Thing is still referenced once the financial instrument is deleted
+func writeReferencedFinancialInstrument(cypherDriver service, db neoutils.CypherRunner, assert *assert.Assertions) {
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement: `MATCH (fi:FinancialInstrument {uuid:{uuid}})
+				MERGE (topic:Thing {uuid:{topicUuid}})
+				MERGE (topic)-[:MENTIONS]->(fi)`,
+		Parameters: map[string]interface{}{"uuid": testFinancialInstrumentUUID, "topicUuid": topicUUID},
+	}}))
+}
+
+func TestDeleteWithOptionsKeepsReferencedThing(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	writeReferencedFinancialInstrument(cypherDriver, db, assert)
+
+	stats, err := cypherDriver.DeleteWithOptions(testFinancialInstrumentUUID, test_trans_id, DeleteOptions{})
+	assert.NoError(err)
+	assert.True(stats.Found)
+	assert.False(stats.NodeDeleted)
+	assert.True(stats.RelationshipsDeleted > 0)
+
+	props, labels, err := cypherDriver.ReadRaw(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Equal(map[string]interface{}{"uuid": testFinancialInstrumentUUID}, props)
+	assert.Equal([]string{"Thing"}, labels)
+
+	_, found, err := cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.False(found)
+}
+
+func TestDeleteWithOptionsForceDeletesReferencedThing(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	writeReferencedFinancialInstrument(cypherDriver, db, assert)
+
+	stats, err := cypherDriver.DeleteWithOptions(testFinancialInstrumentUUID, test_trans_id, DeleteOptions{Force: true})
+	assert.NoError(err)
+	assert.True(stats.Found)
+	assert.True(stats.NodeDeleted)
+	assert.True(stats.RelationshipsDeleted > 0)
+
+	props, labels, err := cypherDriver.ReadRaw(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Nil(props)
+	assert.Nil(labels)
+
+	props, _, err = cypherDriver.ReadRaw(topicUUID)
+	assert.NoError(err)
+	assert.Equal(topicUUID, props["uuid"], "the referencing node should be left")
+
+	stats, err = cypherDriver.DeleteWithOptions(testFinancialInstrumentUUID, test_trans_id, DeleteOptions{Force: true})
+	assert.NoError(err)
+	assert.Equal(DeleteStats{}, stats)
+}
+
+func TestReadRaw(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+
+	props, labels, err := cypherDriver.ReadRaw(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Equal(testFinancialInstrumentUUID, props["uuid"])
+	assert.Equal(testFinancialInstrument.PrefLabel, props["prefLabel"])
+	assert.NotEmpty(props["hash"])
+	assert.Contains(labels, "Thing")
+	assert.Contains(labels, "Concept")
+	assert.Contains(labels, "FinancialInstrument")
+}
+
+func TestVerify(t *testing.T) {
+	assert := assert.New(t)
+
+	props := `{"uuid": "` + testFinancialInstrumentUUID + `", "prefLabel": "GREENWICH CAP ACCEPTANCE  1991-B B1", "aliases": ["GCA 1991-B B1"],
+		"currency": "GBP", "source": "factset", "trustLevel": null, "issueDate": "1991-06-01", "hash": "hash"}`
+	identifiers := `[{"labels": ["Identifier", "UPPIdentifier"], "value": "` + testFinancialInstrumentUUID + `"},
+		{"labels": ["Identifier", "FactsetIdentifier"], "value": "` + facsetIdentifier + `"},
+		{"labels": ["Identifier", "FIGIIdentifier"], "value": "` + figiCode + `"}]`
+	relationships := `[{"type": "ISSUED_BY", "uuid": "` + orgUUID + `"}]`
+	batches := 0
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			batches++
+			for _, query := range queries {
+				switch {
+				case strings.Contains(query.Statement, "properties(fi) as props"):
+					setQueryResult(query, `[{"props": `+props+`}]`)
+				case strings.Contains(query.Statement, "labels(i) as labels"):
+					setQueryResult(query, identifiers)
+				case strings.Contains(query.Statement, "type(r) as type"):
+					setQueryResult(query, relationships)
+				default:
+					setQueryResult(query, testReadRow)
+				}
+			}
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	discrepancies, found, err := cypherDriver.Verify(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Empty(discrepancies)
+	assert.Equal(1, batches, "Everything should be read in one transaction")
+
+	props = strings.Replace(props, `"hash": "hash"`, `"hash": "hash", "currency": "USD", "figiCode": "BBG0066578X7"`, 1)
+	identifiers = strings.TrimSuffix(identifiers, `]`) + `, {"labels": ["Identifier", "FIGIIdentifier"], "value": "BBG0066578X7"}]`
+	relationships = strings.TrimSuffix(relationships, `]`) + `, {"type": "ISSUED_BY", "uuid": "` + upToDateOrgUUID + `"}, {"type": "TAGGED_WITH", "uuid": "` + topicUUID + `"}]`
+	discrepancies, found, err = cypherDriver.Verify(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal([]Discrepancy{
+		{"currency", "USD", "GBP"},
+		{"figiCode", []string{figiCode, "BBG0066578X7"}, []string{figiCode}},
+		{"figiCode", "BBG0066578X7", []string{figiCode}},
+		{"issuedBy", []string{orgUUID, upToDateOrgUUID}, []string{orgUUID}},
+		{"tags", []string{topicUUID}, []string{}},
+	}, discrepancies)
+}
+
+func TestVerifyNotExistingFinancialInstrument(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			return nil
+		},
+	}
+
+	discrepancies, found, err := NewCypherFinancialInstrumentService(conn, conn).Verify(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.False(found)
+	assert.Nil(discrepancies)
+}
+
+func TestRepairBaseLabels(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+
+	breakLabels := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument {uuid:{uuid}}) REMOVE fi:Thing:Concept`,
+		Parameters: neoism.Props{
+			"uuid": testFinancialInstrumentUUID,
+		},
+	}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{breakLabels}))
+
+	repaired, err := cypherDriver.RepairBaseLabels(0, 100)
+	assert.NoError(err)
+	assert.Equal(1, repaired)
+
+	_, labels, err := cypherDriver.ReadRaw(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Contains(labels, "Thing")
+	assert.Contains(labels, "Concept")
+
+	repaired, err = cypherDriver.RepairBaseLabels(0, 100)
+	assert.NoError(err)
+	assert.Equal(0, repaired)
+}
+
+func TestRepairIdentifierLabels(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+
+	legacyIdentifier := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument {uuid:{uuid}})
+				CREATE (fi)<-[:IDENTIFIES]-(:WSODIdentifier {value:{value}})`,
+		Parameters: neoism.Props{
+			"uuid":  testFinancialInstrumentUUID,
+			"value": "legacy-wsod",
+		},
+	}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{legacyIdentifier}))
+
+	repaired, err := cypherDriver.RepairIdentifierLabels(0, 100)
+	assert.NoError(err)
+	assert.Equal(1, repaired)
+
+	repaired, err = cypherDriver.RepairIdentifierLabels(0, 100)
+	assert.NoError(err)
+	assert.Equal(0, repaired)
+
+	deleted, err := cypherDriver.Delete(testFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.True(deleted)
+
+	results := []struct {
+		Count int `json:"count"`
+	}{}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement:  `MATCH (i:WSODIdentifier {value:{value}}) RETURN count(i) as count`,
+		Parameters: neoism.Props{"value": "legacy-wsod"},
+		Result:     &results,
+	}}))
+	assert.Equal(0, results[0].Count, "The repaired identifier should have been deleted with the financial instrument")
+}
+
+func TestRepairIdentifierLabelsUsesIdentifierLabels(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			assert.Contains(queries[0].Statement, "i:UPPIdentifier OR i:FactsetIdentifier OR i:FIGIIdentifier OR i:WSODIdentifier")
+			assert.Contains(queries[0].Statement, "NOT i:Identifier")
+			setQueryResult(queries[0], `[{"count": 2}]`)
+			return nil
+		},
+	}
+
+	repaired, err := NewCypherFinancialInstrumentService(conn, conn).RepairIdentifierLabels(0, 10)
+	assert.NoError(err)
+	assert.Equal(2, repaired)
+}
+
+func TestVerifyAndRepairCanonicalIdentifier(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	alternativeOnlyFinancialInstrument := specialCharactersFinancialInstrument
+	alternativeOnlyFinancialInstrument.AlternativeIdentifiers.UUIDS = []string{rekeyedFinancialInstrumentUUID}
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+	assert.NoError(cypherDriver.Write(alternativeOnlyFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+
+	removeCanonical := &neoism.CypherQuery{
+		Statement: `MATCH (i:UPPIdentifier {value:{uuid}}) DETACH DELETE i`,
+		Parameters: neoism.Props{
+			"uuid": testIncompleteFinancialInstrumentUUID,
+		},
+	}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{removeCanonical}))
+
+	missing := map[string]bool{}
+	verify := func(uuid string, found bool) (bool, error) {
+		missing[uuid] = found
+		return true, nil
+	}
+	assert.NoError(cypherDriver.VerifyCanonicalIdentifier(verify))
+	assert.Equal(map[string]bool{testIncompleteFinancialInstrumentUUID: false, specialCharactersFinancialInstrumentUUID: true}, missing)
+
+	repaired, err := cypherDriver.RepairCanonicalIdentifier(0, 100)
+	assert.NoError(err)
+	assert.Equal(2, repaired)
+
+	missing = map[string]bool{}
+	assert.NoError(cypherDriver.VerifyCanonicalIdentifier(verify))
+	assert.Empty(missing)
+
+	readAndCompare(incompleteFinancialInstrument, t, db)
+	fi, _, err := cypherDriver.ReadTyped(specialCharactersFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Equal([]string{rekeyedFinancialInstrumentUUID, specialCharactersFinancialInstrumentUUID}, sortedValues(fi.AlternativeIdentifiers.UUIDS))
+	expectedHash, err := hashOf(fi)
+	assert.NoError(err)
+	hash, _, err := cypherDriver.ReadHash(specialCharactersFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Equal(expectedHash, hash)
+
+	repaired, err = cypherDriver.RepairCanonicalIdentifier(0, 100)
+	assert.NoError(err)
+	assert.Equal(0, repaired)
+}
+
+func TestVerifyCanonicalIdentifierStopsWhenAsked(t *testing.T) {
+	assert := assert.New(t)
+
+	queries := 0
+	conn := mockNeoConnection{
+		cypherBatch: func(batch []*neoism.CypherQuery) error {
+			queries++
+			assert.Contains(batch[0].Statement, "WHERE NOT fi.uuid IN uuids")
+			setQueryResult(batch[0], `[{"uuid": "`+testIncompleteFinancialInstrumentUUID+`", "found": false}, {"uuid": "`+testFinancialInstrumentUUID+`", "found": true}]`)
+			return nil
+		},
+	}
+
+	yielded := []string{}
+	err := NewCypherFinancialInstrumentService(conn, conn).VerifyCanonicalIdentifier(func(uuid string, found bool) (bool, error) {
+		yielded = append(yielded, uuid)
+		return false, nil
+	})
+	assert.NoError(err)
+	assert.Equal([]string{testIncompleteFinancialInstrumentUUID}, yielded)
+	assert.Equal(1, queries)
+}
+
+func TestVerifyCanonicalIdentifierFindsAllWhileRepairing(t *testing.T) {
+	assert := assert.New(t)
+
+	missing := []string{}
+	for i := 0; i < batchSize+2; i++ {
+		missing = append(missing, fmt.Sprintf("%08d-0000-0000-0000-000000000000", i))
+	}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			assert.NotContains(queries[0].Statement, "SKIP")
+			lastUUID := queries[0].Parameters["lastUUID"].(string)
+			rows := []string{}
+			for _, uuid := range missing {
+				if uuid > lastUUID && len(rows) < batchSize {
+					rows = append(rows, `{"uuid": "`+uuid+`", "found": false}`)
+				}
+			}
+			setQueryResult(queries[0], "["+strings.Join(rows, ",")+"]")
+			return nil
+		},
+	}
+
+	verified := []string{}
+	err := NewCypherFinancialInstrumentService(conn, conn).VerifyCanonicalIdentifier(func(uuid string, found bool) (bool, error) {
+		verified = append(verified, uuid)
+		// Repairing a financial instrument means it is no longer found
+		for i, missingUUID := range missing {
+			if missingUUID == uuid {
+				missing = append(missing[:i], missing[i+1:]...)
+				break
+			}
+		}
+		return true, nil
+	})
+	assert.NoError(err)
+	assert.Len(verified, batchSize+2)
+	assert.Empty(missing)
+}
+
+func TestIdentifierCoverage(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	coverage, err := cypherDriver.IdentifierCoverage()
+	assert.NoError(err)
+	assert.Equal(map[string]float64{uppIdentifierLabel: 0, factsetIdentifierLabel: 0, figiIdentifierLabel: 0, wsodIdentifierLabel: 0}, coverage)
+
+	unidentifiedFinancialInstrument := specialCharactersFinancialInstrument
+	unidentifiedFinancialInstrument.AlternativeIdentifiers = alternativeIdentifiers{UUIDS: []string{specialCharactersFinancialInstrumentUUID}}
+	wsodFinancialInstrument := incompleteFinancialInstrument
+	wsodFinancialInstrument.AlternativeIdentifiers = alternativeIdentifiers{UUIDS: []string{testIncompleteFinancialInstrumentUUID}, WSODIdentifier: "wsod"}
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+	assert.NoError(cypherDriver.Write(unidentifiedFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+	assert.NoError(cypherDriver.Write(wsodFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+	assert.NoError(cypherDriver.Write(financialInstrument{UUID: rekeyedFinancialInstrumentUUID}, test_trans_id), "Failed to write financial instrument")
+
+	coverage, err = cypherDriver.IdentifierCoverage()
+	assert.NoError(err)
+	assert.Equal(map[string]float64{uppIdentifierLabel: 0.75, factsetIdentifierLabel: 0.25, figiIdentifierLabel: 0.25, wsodIdentifierLabel: 0.25}, coverage)
+}
+
+func TestIdentifierCoverageQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			assert.Len(queries, 1)
+			assert.Contains(queries[0].Statement, "(fi)<-[:IDENTIFIES]-(:FIGI)")
+			setQueryResult(queries[0], `[{"total": 8, "covered": [8, 4, 2, 0]}]`)
+			return nil
+		},
+	}
+
+	coverage, err := NewCypherFinancialInstrumentService(conn, conn, WithIdentifierLabel(figiIdentifierLabel, "FIGI")).IdentifierCoverage()
+	assert.NoError(err)
+	assert.Equal(map[string]float64{uppIdentifierLabel: 1, factsetIdentifierLabel: 0.5, figiIdentifierLabel: 0.25, wsodIdentifierLabel: 0}, coverage)
+}
+
+func TestReadRawNotExistingFinancialInstrument(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	props, labels, err := cypherDriver.ReadRaw(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Nil(props)
+	assert.Nil(labels)
+}
+
+func TestReadWithETag(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	_, _, found, err := cypherDriver.ReadWithETag(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.False(found)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+
+	fi, etag, found, err := cypherDriver.ReadWithETag(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(testFinancialInstrument, fi)
+
+	hash, _, err := cypherDriver.ReadHash(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Equal(`"`+hash+`"`, etag)
+	assert.True(ETagMatches(etag, etag))
+}
+
+func TestETagMatches(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(ETagMatches(`"abc"`, `"abc"`))
+	assert.True(ETagMatches(`"xyz", W/"abc"`, `"abc"`))
+	assert.True(ETagMatches(`*`, `"abc"`))
+	assert.False(ETagMatches(`"xyz"`, `"abc"`))
+	assert.False(ETagMatches(``, `"abc"`))
+	assert.False(ETagMatches(`*`, ``))
+}
+
+func TestReadHash(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	hash, found, err := cypherDriver.ReadHash(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.False(found)
+	assert.Empty(hash)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+
+	expectedHash, err := writeHash(testFinancialInstrument)
+	assert.NoError(err)
+
+	hash, found, err = cypherDriver.ReadHash(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(expectedHash, hash)
+}
+
+func TestReadWriteMetadata(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	_, found, err := cypherDriver.ReadWriteMetadata(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.False(found)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement:  `MATCH (t:FinancialInstrument {uuid:{uuid}}) REMOVE t:FinancialInstrument`,
+		Parameters: map[string]interface{}{"uuid": testFinancialInstrumentUUID},
+	}}))
+
+	expectedHash, err := writeHash(testFinancialInstrument)
+	assert.NoError(err)
+
+	metadata, found, err := cypherDriver.ReadWriteMetadata(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(expectedHash, metadata.Hash)
+	assert.Equal(testFinancialInstrument.Source, metadata.Source)
+	assert.NotNil(metadata.LastModified)
+	assert.Empty(metadata.Version)
+	assert.NotContains(metadata.Labels, "FinancialInstrument")
+}
+
+func TestReadWriteMetadataDecodesLastModified(t *testing.T) {
+	assert := assert.New(t)
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			setQueryResult(queries[0], `[{"hash": "hash", "lastModified": 1500000000000, "source": "factset", "version": "2", "labels": ["Thing"]}]`)
+			return nil
+		},
+	}
+
+	metadata, found, err := NewCypherFinancialInstrumentService(conn, conn).ReadWriteMetadata(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal("hash", metadata.Hash)
+	assert.Equal("factset", metadata.Source)
+	assert.Equal("2", metadata.Version)
+	assert.Equal([]string{"Thing"}, metadata.Labels)
+	if assert.NotNil(metadata.LastModified) {
+		assert.Equal(int64(1500000000000), lastModified(*metadata.LastModified))
+	}
+}
+
+func TestResolveUUID(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+
+	uuid, found, err := cypherDriver.ResolveUUID(figiIdentifierLabel, figiCode)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(testFinancialInstrumentUUID, uuid)
+
+	uuid, found, err = cypherDriver.ResolveUUID(factsetIdentifierLabel, "XXXXXX-S")
+	assert.NoError(err)
+	assert.False(found)
+	assert.Empty(uuid)
+}
+
+func TestResolveUUIDWithUnknownIdentifierType(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatal("No query should be run for an unknown identifier type")
+			return nil
+		},
+	}
+
+	_, found, err := NewCypherFinancialInstrumentService(conn, conn).ResolveUUID("ISBNIdentifier", "0-19-852663-6")
+	assert.False(found)
+	assert.IsType(requestError{}, err)
+}
+
+func TestDeleteBySource(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	factsetFinancialInstrument := testFinancialInstrument
+	factsetFinancialInstrument.Source = "factset"
+	otherFactsetFinancialInstrument := incompleteFinancialInstrument
+	otherFactsetFinancialInstrument.AlternativeIdentifiers.FactsetIdentifier = "LQ6FS3-S"
+	otherFactsetFinancialInstrument.Source = "factset"
+	otherSourceFinancialInstrument := specialCharactersFinancialInstrument
+	otherSourceFinancialInstrument.AlternativeIdentifiers.FactsetIdentifier = "QX6S54-S"
+	otherSourceFinancialInstrument.AlternativeIdentifiers.FIGICode = "BBG0066578X7"
+	otherSourceFinancialInstrument.Source = "wsod"
+
+	assert.NoError(cypherDriver.Write(factsetFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+	assert.NoError(cypherDriver.Write(otherFactsetFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+	assert.NoError(cypherDriver.Write(otherSourceFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+
+	deleted, err := cypherDriver.DeleteBySource("factset")
+	assert.NoError(err)
+	assert.Equal(2, deleted)
+
+	deleted, err = cypherDriver.DeleteBySource("factset")
+	assert.NoError(err)
+	assert.Equal(0, deleted)
+
+	_, found, err := cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.False(found)
+	readAndCompare(otherSourceFinancialInstrument, t, db)
+}
+
+func TestPruneOlderThan(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	snapshot := time.Now()
+	beforeSnapshot := snapshot.Add(-time.Hour)
+
+	staleFactsetFinancialInstrument := testFinancialInstrument
+	staleFactsetFinancialInstrument.Source = "factset"
+	staleFactsetFinancialInstrument.LastModified = &beforeSnapshot
+	currentFactsetFinancialInstrument := incompleteFinancialInstrument
+	currentFactsetFinancialInstrument.AlternativeIdentifiers.FactsetIdentifier = "LQ6FS3-S"
+	currentFactsetFinancialInstrument.Source = "factset"
+	currentFactsetFinancialInstrument.LastModified = &snapshot
+	staleOtherSourceFinancialInstrument := specialCharactersFinancialInstrument
+	staleOtherSourceFinancialInstrument.AlternativeIdentifiers.FactsetIdentifier = "QX6S54-S"
+	staleOtherSourceFinancialInstrument.AlternativeIdentifiers.FIGICode = "BBG0066578X7"
+	staleOtherSourceFinancialInstrument.Source = "wsod"
+	staleOtherSourceFinancialInstrument.LastModified = &beforeSnapshot
+
+	for _, fi := range []financialInstrument{staleFactsetFinancialInstrument, currentFactsetFinancialInstrument, staleOtherSourceFinancialInstrument} {
+		assert.NoError(cypherDriver.Write(fi, test_trans_id), "Failed to write financial instrument")
+	}
+
+	pruned, err := cypherDriver.PruneOlderThan("factset", snapshot)
+	assert.NoError(err)
+	assert.Equal(1, pruned)
+
+	pruned, err = cypherDriver.PruneOlderThan("factset", snapshot)
+	assert.NoError(err)
+	assert.Equal(0, pruned)
+
+	_, found, err := cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.False(found)
+	_, found, err = cypherDriver.Read(testIncompleteFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.True(found)
+	_, found, err = cypherDriver.Read(specialCharactersFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.True(found)
+}
+
+func TestPruneOlderThanQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	snapshot := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	statements := []string{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			query := queries[0]
+			statements = append(statements, query.Statement)
+			assert.Equal("factset", query.Parameters["source"])
+			assert.Equal(lastModified(snapshot), query.Parameters["before"])
+			return nil
+		},
+	}
+
+	pruned, err := NewCypherFinancialInstrumentService(conn, conn).PruneOlderThan("factset", snapshot)
+	assert.NoError(err)
+	assert.Equal(0, pruned)
+	if assert.Len(statements, 1) {
+		assert.Contains(statements[0], "MATCH (fi:FinancialInstrument {source:{source}})")
+		assert.Contains(statements[0], "WHERE fi.lastModified < {before}")
+	}
+
+	_, err = NewCypherFinancialInstrumentService(conn, conn).PruneOlderThan("", snapshot)
+	assert.IsType(requestError{}, err)
+}
+
+func TestCount(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write person")
+	incompleteFinancialInstrument.AlternativeIdentifiers.FactsetIdentifier = "LQ6FS3-S"
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to write person")
+
+	count, err := cypherDriver.Count()
+	assert.NoError(err, "Error trying to find the number of financial instruments")
+	assert.Equal(count, 2, "Expeting two results but got %d", count)
+}
+
+func TestCheckTimesOutWhenNeo4jHangs(t *testing.T) {
+	assert := assert.New(t)
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			<-unblock
+			return nil
+		},
+	}
+
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithCheckTimeout(10*time.Millisecond))
+
+	start := time.Now()
+	err := cypherDriver.Check()
+	assert.Error(err)
+	assert.True(time.Since(start) < time.Second, "Check should have failed fast")
+}
+
+func TestCheckReturnsRunnerResultWithinTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			return nil
+		},
+	}
+
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithCheckTimeout(time.Second))
+	assert.NoError(cypherDriver.Check())
+}
+
+func TestInstrumentCountsAreCachedWithinTTL(t *testing.T) {
+	assert := assert.New(t)
+
+	calls := 0
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			calls++
+			assert.Len(queries, 2)
+			setQueryResult(queries[0], `[{"count": 3}]`)
+			setQueryResult(queries[1], `[{"type": "Equity", "count": 2}, {"type": "Bond", "count": 1}]`)
+			return nil
+		},
+	}
+
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithCountsCacheTTL(time.Hour))
+
+	for i := 0; i < 2; i++ {
+		counts, err := cypherDriver.InstrumentCounts()
+		assert.NoError(err)
+		assert.Equal(InstrumentCounts{Total: 3, ByType: map[string]int{"Equity": 2, "Bond": 1}}, counts)
+	}
+	assert.Equal(1, calls, "Expected the second call to be served from the cache")
+}
+
+func TestInstrumentCountsWithoutCacheQueryEveryTime(t *testing.T) {
+	assert := assert.New(t)
+
+	calls := 0
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			calls++
+			setQueryResult(queries[0], `[{"count": 0}]`)
+			return nil
+		},
+	}
+
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithCountsCacheTTL(0))
+
+	for i := 0; i < 2; i++ {
+		_, err := cypherDriver.InstrumentCounts()
+		assert.NoError(err)
+	}
+	assert.Equal(2, calls)
+}
+
+func TestInitialiseIndexesQueriedProperties(t *testing.T) {
+	assert := assert.New(t)
+
+	indexed := []string{}
+	conn := mockNeoConnection{
+		ensureIndexes: func(indexes map[string]string) error {
+			for label, property := range indexes {
+				indexed = append(indexed, label+"."+property)
+			}
+			return nil
+		},
+	}
+
+	assert.NoError(NewCypherFinancialInstrumentService(conn, conn).Initialise())
+	assert.Equal([]string{
+		"Identifier.value",
+		"FinancialInstrument.prefLabel",
+		"FinancialInstrument.source",
+		"FinancialInstrument.currency",
+		"FinancialInstrument.lastModified",
+		"FinancialInstrument.issueDate",
+		"FinancialInstrument.status",
+		"FinancialInstrument.micCode",
+		"FinancialInstrument.sector",
+		"FinancialInstrument.countryOfRisk",
+	}, indexed)
+}
+
+func TestInitialiseOrderIsDeterministic(t *testing.T) {
+	assert := assert.New(t)
+
+	initialise := func() []string {
+		created := []string{}
+		record := func(kind string) func(map[string]string) error {
+			return func(m map[string]string) error {
+				assert.Len(m, 1, "Each %s should be created on its own", kind)
+				for label, property := range m {
+					created = append(created, kind+" "+label+"."+property)
+				}
+				return nil
+			}
+		}
+		conn := mockNeoConnection{
+			ensureConstraints: record("constraint"),
+			ensureIndexes:     record("index"),
+		}
+		assert.NoError(NewCypherFinancialInstrumentService(conn, conn).Initialise())
+		return created
+	}
+
+	first := initialise()
+	assert.Equal([]string{
+		"constraint Thing.uuid",
+		"constraint Concept.uuid",
+		"constraint FinancialInstrument.uuid",
+		"constraint UPPIdentifier.value",
+		"constraint FactsetIdentifier.value",
+		"constraint FIGIIdentifier.value",
+		"index Identifier.value",
+	}, first[:7])
+	for i := 0; i < 10; i++ {
+		assert.Equal(first, initialise())
+	}
+}
+
+func TestInitialiseRetriesIdentifierIndex(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(delay time.Duration) { initialiseRetryDelay = delay }(initialiseRetryDelay)
+	initialiseRetryDelay = 0
+
+	attempts, failures := 0, 1
+	conn := mockNeoConnection{
+		ensureIndexes: func(indexes map[string]string) error {
+			if _, ok := indexes["Identifier"]; ok {
+				attempts++
+				if attempts <= failures {
+					return errors.New("constraint being created")
+				}
+			}
+			return nil
+		},
+	}
+	assert.NoError(NewCypherFinancialInstrumentService(conn, conn).Initialise())
+	assert.Equal(2, attempts)
+
+	attempts, failures = 0, 2
+	assert.Error(NewCypherFinancialInstrumentService(conn, conn).Initialise())
+	assert.Equal(2, attempts)
+}
+
+func TestSchemaDriftReportsMissingConstraint(t *testing.T) {
+	assert := assert.New(t)
+
+	indexes := []map[string]string{
+		{"description": "INDEX ON :Identifier(value)", "type": "node_label_property"},
+		{"description": "INDEX ON :Thing(uuid)", "type": "node_unique_property"},
+		{"description": "INDEX ON :Organisation(prefLabel)", "type": "node_label_property"},
+		{"description": "INDEX ON :FinancialInstrument(legacyCode)", "type": "node_label_property"},
+	}
+	for _, property := range indexedProperties {
+		indexes = append(indexes, map[string]string{"description": "INDEX ON :FinancialInstrument(" + property + ")", "type": "node_label_property"})
+	}
+	indexRows, err := json.Marshal(indexes)
+	assert.NoError(err)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			assert.Len(queries, 2)
+			assert.Contains(queries[0].Statement, "db.constraints()")
+			setQueryResult(queries[0], `[
+				{"description": "CONSTRAINT ON ( thing:Thing ) ASSERT thing.uuid IS UNIQUE"},
+				{"description": "CONSTRAINT ON ( concept:Concept ) ASSERT concept.uuid IS UNIQUE"},
+				{"description": "CONSTRAINT ON ( financialinstrument:FinancialInstrument ) ASSERT financialinstrument.uuid IS UNIQUE"},
+				{"description": "CONSTRAINT ON ( uppidentifier:UPPIdentifier ) ASSERT uppidentifier.value IS UNIQUE"},
+				{"description": "CONSTRAINT ON ( figiidentifier:FIGIIdentifier ) ASSERT figiidentifier.value IS UNIQUE"},
+				{"description": "CONSTRAINT ON ( organisation:Organisation ) ASSERT organisation.uuid IS UNIQUE"}
+			]`)
+			assert.Contains(queries[1].Statement, "db.indexes()")
+			setQueryResult(queries[1], string(indexRows))
+			return nil
+		},
+	}
+
+	drift, err := NewCypherFinancialInstrumentService(conn, nil, WithReadOnly()).SchemaDrift()
+	assert.NoError(err)
+	assert.Equal([]string{
+		"missing constraint on :FactsetIdentifier(value)",
+		"unexpected index on :FinancialInstrument(legacyCode)",
+	}, drift)
+}
+
+func TestSchemaDriftReportsNoDriftForInitialisedSchema(t *testing.T) {
+	assert := assert.New(t)
+
+	constraints := []map[string]string{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			rows, err := json.Marshal(constraints)
+			assert.NoError(err)
+			setQueryResult(queries[0], string(rows))
+			setQueryResult(queries[1], `[{"description": "INDEX ON :Identifier(value)", "type": "node_label_property"}]`)
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithIdentifierLabel(uppIdentifierLabel, "UPPId"))
+	for _, constraint := range cypherDriver.constraints() {
+		constraints = append(constraints, map[string]string{
+			"description": fmt.Sprintf("CONSTRAINT ON ( n:%s ) ASSERT n.%s IS UNIQUE", constraint.label, constraint.property),
+		})
+	}
+
+	drift, err := cypherDriver.SchemaDrift()
+	assert.NoError(err)
+	assert.Len(drift, len(indexedProperties), "only the FinancialInstrument property indexes should be missing")
+	assert.Equal("missing index on :FinancialInstrument(prefLabel)", drift[0])
+}
+
+func TestReadSorted(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	earlier := testFinancialInstrument
+	lastHour := time.Now().Add(-time.Hour)
+	earlier.LastModified = &lastHour
+	assert.NoError(cypherDriver.Write(earlier, test_trans_id), "Failed to write financial instrument")
+	assert.NoError(cypherDriver.Write(specialCharactersFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+
+	uuids := func(fis []financialInstrument) []string {
+		result := []string{}
+		for _, fi := range fis {
+			result = append(result, fi.UUID)
+		}
+		return result
+	}
+
+	// "A&B GEOSCIENCE'S CORP.  COM" sorts before "GREENWICH CAP ACCEPTANCE  1991-B B1"
+	fis, err := cypherDriver.ReadSorted("prefLabel", false, 0, 10)
+	assert.NoError(err)
+	assert.Equal([]string{specialCharactersFinancialInstrumentUUID, testFinancialInstrumentUUID}, uuids(fis))
+
+	fis, err = cypherDriver.ReadSorted("prefLabel", true, 0, 10)
+	assert.NoError(err)
+	assert.Equal([]string{testFinancialInstrumentUUID, specialCharactersFinancialInstrumentUUID}, uuids(fis))
+
+	fis, err = cypherDriver.ReadSorted("prefLabel", true, 1, 1)
+	assert.NoError(err)
+	assert.Equal([]string{specialCharactersFinancialInstrumentUUID}, uuids(fis))
+
+	fis, err = cypherDriver.ReadSorted("lastModified", true, 0, 1)
+	assert.NoError(err)
+	assert.Equal([]string{specialCharactersFinancialInstrumentUUID}, uuids(fis))
+}
+
+func TestReadPageWithTotal(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+	assert.NoError(cypherDriver.Write(specialCharactersFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+
+	count, err := cypherDriver.Count()
+	assert.NoError(err)
+
+	seen := 0
+	for skip := 0; skip <= count; skip++ {
+		fis, total, err := cypherDriver.ReadPageWithTotal(skip, 1)
+		assert.NoError(err)
+		assert.Equal(count, total, "The total should be the same for every page")
+		seen += len(fis)
+	}
+	assert.Equal(count, seen)
+}
+
+func TestReadPageWithTotalReadsBothInOneBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	batches := 0
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			batches++
+			assert.Len(queries, 2)
+			setQueryResult(queries[0], `[{"uuid": "`+testFinancialInstrumentUUID+`", "uuids": ["`+testFinancialInstrumentUUID+`"]}]`)
+			setQueryResult(queries[1], `[{"total": 7}]`)
+			return nil
+		},
+	}
+
+	fis, total, err := NewCypherFinancialInstrumentService(conn, conn).ReadPageWithTotal(3, 1)
+	assert.NoError(err)
+	assert.Equal(1, batches)
+	assert.Equal(7, total)
+	if assert.Len(fis, 1) {
+		assert.Equal(testFinancialInstrumentUUID, fis[0].UUID)
+	}
+
+	_, _, err = NewCypherFinancialInstrumentService(conn, conn).ReadPageWithTotal(-1, 1)
+	assert.IsType(requestError{}, err)
+	assert.Equal(1, batches)
+}
+
+func TestReadAfter(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	written := []string{testFinancialInstrumentUUID, testIncompleteFinancialInstrumentUUID, specialCharactersFinancialInstrumentUUID}
+	for _, fi := range []financialInstrument{testFinancialInstrument, incompleteFinancialInstrument, specialCharactersFinancialInstrument} {
+		assert.NoError(cypherDriver.Write(fi, test_trans_id), "Failed to write financial instrument")
+	}
+	sort.Strings(written)
+
+	read := []string{}
+	for lastUUID := ""; ; {
+		fis, err := cypherDriver.ReadAfter(lastUUID, 2)
+		assert.NoError(err)
+		if len(fis) == 0 {
+			break
+		}
+		for _, fi := range fis {
+			read = append(read, fi.UUID)
+		}
+		lastUUID = fis[len(fis)-1].UUID
+	}
+	assert.Equal(written, read, "Every financial instrument should be read once, in uuid order")
+}
+
+func TestReadAfterPagesWithoutOverlapOrGaps(t *testing.T) {
+	assert := assert.New(t)
+
+	stored := []string{}
+	for i := 0; i < 7; i++ {
+		stored = append(stored, fmt.Sprintf("00000000-0000-0000-0000-00000000000%d", i))
+	}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			query := queries[0]
+			assert.Contains(query.Statement, "WHERE fi.uuid > {lastUUID}")
+			assert.Equal(0, query.Parameters["skip"])
+			rows := []string{}
+			for _, uuid := range stored {
+				if uuid > query.Parameters["lastUUID"].(string) && len(rows) < query.Parameters["limit"].(int) {
+					rows = append(rows, `{"uuid": "`+uuid+`"}`)
+				}
+			}
+			setQueryResult(query, "["+strings.Join(rows, ",")+"]")
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	read := []string{}
+	pages := 0
+	for lastUUID := ""; ; pages++ {
+		fis, err := cypherDriver.ReadAfter(lastUUID, 3)
+		assert.NoError(err)
+		if len(fis) == 0 {
+			break
+		}
+		for _, fi := range fis {
+			read = append(read, fi.UUID)
+		}
+		lastUUID = fis[len(fis)-1].UUID
+	}
+	assert.Equal(stored, read)
+	assert.Equal(3, pages)
+
+	_, err := cypherDriver.ReadAfter("", 0)
+	assert.IsType(requestError{}, err)
+}
+
+func TestReadGroupedByIssuerQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	queries := 0
+	conn := mockNeoConnection{
+		cypherBatch: func(q []*neoism.CypherQuery) error {
+			queries++
+			assert.Contains(q[0].Statement, "WHERE issuer.uuid IN {orgUUIDs}")
+			assert.Equal([]string{orgUUID, upToDateOrgUUID}, q[0].Parameters["orgUUIDs"])
+			setQueryResult(q[0], `[
+				{"uuid": "`+testFinancialInstrumentUUID+`", "issuedBy": "`+orgUUID+`", "uuids": ["`+testFinancialInstrumentUUID+`"]},
+				{"uuid": "`+specialCharactersFinancialInstrumentUUID+`", "issuedBy": "`+upToDateOrgUUID+`", "uuids": ["`+specialCharactersFinancialInstrumentUUID+`"]}]`)
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	grouped, err := cypherDriver.ReadGroupedByIssuer([]string{orgUUID, upToDateOrgUUID})
+	assert.NoError(err)
+	assert.Equal(1, queries)
+	if assert.Len(grouped[orgUUID], 1) {
+		assert.Equal(testFinancialInstrumentUUID, grouped[orgUUID][0].UUID)
+	}
+	if assert.Len(grouped[upToDateOrgUUID], 1) {
+		assert.Equal(specialCharactersFinancialInstrumentUUID, grouped[upToDateOrgUUID][0].UUID)
+	}
+
+	_, err = cypherDriver.ReadGroupedByIssuer([]string{orgUUID, "not a uuid"})
+	assert.IsType(requestError{}, err)
+	tooMany := make([]string, maxGroupedIssuers+1)
+	for i := range tooMany {
+		tooMany[i] = orgUUID
+	}
+	_, err = cypherDriver.ReadGroupedByIssuer(tooMany)
+	assert.IsType(requestError{}, err)
+	assert.Equal(1, queries)
+}
+
+func TestReadSortedOrdersPageAndResults(t *testing.T) {
+	assert := assert.New(t)
+
+	statement := ""
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			statement = queries[0].Statement
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	_, err := cypherDriver.ReadSorted("lastModified", true, 0, 10)
+	assert.NoError(err)
+	assert.Contains(statement, "WITH fi ORDER BY fi.lastModified DESC, fi.uuid SKIP {skip} LIMIT {limit}")
+	assert.Contains(statement, "ORDER BY sortKey DESC, uuid")
+
+	_, err = cypherDriver.ReadSorted("prefLabel", false, 0, 10)
+	assert.NoError(err)
+	assert.Contains(statement, "WITH fi ORDER BY fi.prefLabel, fi.uuid SKIP {skip} LIMIT {limit}")
+	assert.Contains(statement, "ORDER BY sortKey, uuid")
+
+	statement = ""
+	_, err = cypherDriver.ReadSorted("currency", false, 0, 10)
+	assert.IsType(requestError{}, err)
+	_, err = cypherDriver.ReadSorted("prefLabel; MATCH (n) DETACH DELETE n", false, 0, 10)
+	assert.IsType(requestError{}, err)
+	assert.Empty(statement)
+}
+
+func TestReadWithoutIndexManager(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			setQueryResult(queries[0], `[{"uuid": "`+testFinancialInstrumentUUID+`", "prefLabel": "GREENWICH CAP ACCEPTANCE  1991-B B1", "uuids": ["`+testFinancialInstrumentUUID+`"]}]`)
+			return nil
+		},
+	}
+
+	cypherDriver := NewCypherFinancialInstrumentService(conn, nil)
+	assert.Error(cypherDriver.Initialise())
+
+	fi, found, err := cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(testFinancialInstrumentUUID, fi.(financialInstrument).UUID)
+}
+
+func TestCountIdentifiers(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	before, err := cypherDriver.CountIdentifiers()
+	assert.NoError(err)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+
+	after, err := cypherDriver.CountIdentifiers()
+	assert.NoError(err)
+	// The issuer's UPPIdentifier is counted as well as the financial instrument's own
+	assert.Equal(before[uppIdentifierLabel]+2, after[uppIdentifierLabel])
+	assert.Equal(before[factsetIdentifierLabel]+1, after[factsetIdentifierLabel])
+	assert.Equal(before[figiIdentifierLabel]+1, after[figiIdentifierLabel])
+	assert.Equal(before[wsodIdentifierLabel], after[wsodIdentifierLabel])
+	assert.NotContains(after, "Identifier")
+}
+
+func TestRelationshipCounts(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	before, err := cypherDriver.RelationshipCounts()
+	assert.NoError(err)
+
+	taggedFinancialInstrument := testFinancialInstrument
+	taggedFinancialInstrument.Tags = []string{topicUUID, otherTopicUUID}
+	assert.NoError(cypherDriver.Write(taggedFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+
+	after, err := cypherDriver.RelationshipCounts()
+	assert.NoError(err)
+	assert.Equal(before["ISSUED_BY"]+1, after["ISSUED_BY"])
+	assert.Equal(before["IDENTIFIES"]+3, after["IDENTIFIES"])
+	assert.Equal(before["TAGGED_WITH"]+2, after["TAGGED_WITH"])
+	assert.Equal(before["WAS_IDENTIFIER_OF"], after["WAS_IDENTIFIER_OF"])
+	assert.Len(after, len(managedRelationships))
+}
+
+func TestPagedCount(t *testing.T) {
+	assert := assert.New(t)
+
+	pages := []string{
+		`[{"scanned": 2, "last": "` + testIncompleteFinancialInstrumentUUID + `", "count": 2}]`,
+		`[{"scanned": 2, "last": "` + testFinancialInstrumentUUID + `", "count": 1}]`,
+		`[{"scanned": 0, "last": null, "count": 0}]`,
+	}
+	afters := []interface{}{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			afters = append(afters, queries[0].Parameters["after"])
+			assert.Equal(2, queries[0].Parameters["limit"])
+			setQueryResult(queries[0], pages[len(afters)-1])
+			return nil
+		},
+	}
+
+	count, err := NewCypherFinancialInstrumentService(conn, conn, WithPagedCount(2)).Count()
+	assert.NoError(err)
+	assert.Equal(3, count)
+	assert.Equal([]interface{}{"", testIncompleteFinancialInstrumentUUID, testFinancialInstrumentUUID}, afters)
+}
+
+func TestReadOnlyServiceRejectsMutations(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			for _, query := range queries {
+				if query.Result == nil {
+					t.Fatalf("No query should change the graph when read-only, but ran %s", query.Statement)
+				}
+			}
+			setQueryResult(queries[0], testReadRow)
+			return nil
+		},
+		ensureConstraints: func(constraints map[string]string) error {
+			t.Fatalf("No constraint should be created when read-only, but created %v", constraints)
+			return nil
+		},
+		ensureIndexes: func(indexes map[string]string) error {
+			t.Fatalf("No index should be created when read-only, but created %v", indexes)
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithReadOnly())
+
+	assert.IsType(ReadOnlyError{}, cypherDriver.Initialise())
+	assert.IsType(ReadOnlyError{}, cypherDriver.Write(testFinancialInstrument, test_trans_id))
+	assert.IsType(ReadOnlyError{}, writeErr(cypherDriver.WriteBatch([]financialInstrument{testFinancialInstrument}, test_trans_id, WriteOptions{})))
+	assert.Equal(0, cypherDriver.WriteEach([]financialInstrument{testFinancialInstrument}, test_trans_id, WriteOptions{}))
+	_, err := cypherDriver.Patch(testFinancialInstrumentUUID, map[string]interface{}{"prefLabel": "PATCHED"})
+	assert.IsType(ReadOnlyError{}, err)
+	assert.IsType(ReadOnlyError{}, cypherDriver.Rekey(testFinancialInstrumentUUID, rekeyedFinancialInstrumentUUID))
+	_, err = cypherDriver.Delete(testFinancialInstrumentUUID, test_trans_id)
+	assert.IsType(ReadOnlyError{}, err)
+	_, err = cypherDriver.DeleteWithOptions(testFinancialInstrumentUUID, test_trans_id, DeleteOptions{Force: true})
+	assert.IsType(ReadOnlyError{}, err)
+	_, err = cypherDriver.DeleteBySource("factset")
+	assert.IsType(ReadOnlyError{}, err)
+	_, err = cypherDriver.PruneOlderThan("factset", time.Now())
+	assert.IsType(ReadOnlyError{}, err)
+	_, err = cypherDriver.DeprecateIdentifier(testFinancialInstrumentUUID, figiIdentifierLabel, figiCode)
+	assert.IsType(ReadOnlyError{}, err)
+	_, err = cypherDriver.RepairBaseLabels(0, 10)
+	assert.IsType(ReadOnlyError{}, err)
+	_, err = cypherDriver.RepairIdentifierLabels(0, 10)
+	assert.IsType(ReadOnlyError{}, err)
+	_, err = cypherDriver.RepairCanonicalIdentifier(0, 10)
+	assert.IsType(ReadOnlyError{}, err)
+	_, err = cypherDriver.RecomputeHashes(0, 10)
+	assert.IsType(ReadOnlyError{}, err)
+	_, err = cypherDriver.BackfillHashes(0, 10)
+	assert.IsType(ReadOnlyError{}, err)
+	_, err = cypherDriver.RepointIssuers(map[string]string{orgUUID: upToDateOrgUUID})
+	assert.IsType(ReadOnlyError{}, err)
+	assert.IsType(ReadOnlyError{}, cypherDriver.ReplaceIdentifiers(testFinancialInstrumentUUID, testFinancialInstrument.AlternativeIdentifiers))
+	_, err = cypherDriver.Replay(strings.NewReader(`{"operation": "delete", "uuid": "` + testFinancialInstrumentUUID + `"}`))
+	assert.IsType(ReadOnlyError{}, err)
+
+	_, found, err := cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.True(found)
+}
+
+func TestErrorsAreClassified(t *testing.T) {
+	assert := assert.New(t)
+
+	unexpected := errors.New("unexpected")
+	classifications := []struct {
+		runnerErr error
+		expected  error
+	}{
+		{&url.Error{Op: "Post", URL: "http://localhost:7474/db/data/transaction/commit", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, UnavailableError{}},
+		{neoism.TxErrorList{{Code: "Neo.TransientError.Transaction.DeadlockDetected"}}, UnavailableError{}},
+		{neoism.TxErrorList{{Code: "Neo.ClientError.Schema.ConstraintValidationFailed", Message: "already exists"}}, ConflictError{}},
+		{neoism.TxErrorList{{Code: "Neo.ClientError.Statement.SyntaxError"}}, neoism.TxErrorList{}},
+		{rwapi.ConstraintOrTransactionError{Message: "Error executing query", Details: []string{"Neo.TransientError.Transaction.DeadlockDetected: deadlock"}}, UnavailableError{}},
+		{rwapi.ConstraintOrTransactionError{Message: "Error executing query", Details: []string{"Node(1) already exists with label `Thing` and property `uuid`"}}, ConflictError{}},
+		{rwapi.ConstraintOrTransactionError{Message: "Error executing query", Details: []string{"Invalid input"}}, rwapi.ConstraintOrTransactionError{}},
+		{unexpected, unexpected},
+	}
+
+	for _, classification := range classifications {
+		runnerErr := classification.runnerErr
+		conn := mockNeoConnection{
+			cypherBatch: func(queries []*neoism.CypherQuery) error {
+				return runnerErr
+			},
+		}
+		_, _, err := NewCypherFinancialInstrumentService(conn, conn).ReadTyped(testFinancialInstrumentUUID)
+		assert.IsType(classification.expected, err, "%v", runnerErr)
+	}
+}
+
+func TestDrainWaitsForWritesStartedBeforeItAndRejectsLaterOnes(t *testing.T) {
+	assert := assert.New(t)
+
+	// Only the first write blocks, until it is released
+	first := make(chan bool, 1)
+	first <- true
+	writing := make(chan bool, 1)
+	release := make(chan bool)
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			if queries[0].Result != nil {
+				return nil
+			}
+			select {
+			case <-first:
+				writing <- true
+				<-release
+			default:
+			}
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	written := make(chan error)
+	go func() {
+		written <- cypherDriver.Write(testFinancialInstrument, test_trans_id)
+	}()
+	<-writing
+
+	drained := make(chan error)
+	go func() {
+		drained <- cypherDriver.Drain(context.Background())
+	}()
+
+	// Drain marks the service as draining in its own goroutine, so wait for that
+	var err error
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if err = cypherDriver.Write(testFinancialInstrument, test_trans_id); err != nil {
+			break
+		}
+	}
+	assert.IsType(DrainingError{}, err, "Writes started after Drain should be rejected")
+	select {
+	case <-drained:
+		t.Fatal("Drain returned before the write started before it finished")
+	default:
+	}
+
+	close(release)
+	assert.NoError(<-written)
+	assert.NoError(<-drained)
+}
+
+//fakeClock is a clock for a rateLimiter that only moves when it sleeps, recording each delay it was asked to sleep for
+type fakeClock struct {
+	time   time.Time
+	delays []time.Duration
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.time
+}
+
+func (c *fakeClock) sleep(ctx context.Context, delay time.Duration) error {
+	c.delays = append(c.delays, delay)
+	c.time = c.time.Add(delay)
+	return nil
+}
+
+func TestQueryRateLimitSpacesBatches(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := &fakeClock{time: time.Unix(0, 0)}
+	batches := []time.Time{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			batches = append(batches, clock.now())
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithQueryRateLimit(context.Background(), 20))
+	cypherDriver.rateLimiter.now, cypherDriver.rateLimiter.sleep, cypherDriver.rateLimiter.last = clock.now, clock.sleep, clock.now()
+
+	// The first second's worth are allowed in a burst, after which batches are spaced 50ms apart
+	for i := 0; i < 25; i++ {
+		_, _, err := cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id)
+		assert.NoError(err)
+	}
+
+	assert.Len(batches, 25)
+	assert.Equal(batches[0], batches[19], "The burst should not be spaced out")
+	if assert.Len(clock.delays, 5) {
+		for _, delay := range clock.delays {
+			assert.InDelta(float64(50*time.Millisecond), float64(delay), float64(time.Microsecond))
+		}
+	}
+	for i := 20; i < len(batches); i++ {
+		assert.InDelta(float64(50*time.Millisecond), float64(batches[i].Sub(batches[i-1])), float64(time.Microsecond), "Batch %d", i)
+	}
+
+	// A quiet spell refills the bucket, but only up to a second's worth
+	clock.time = clock.time.Add(time.Hour)
+	clock.delays = nil
+	for i := 0; i < 20; i++ {
+		_, _, err := cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id)
+		assert.NoError(err)
+	}
+	assert.Empty(clock.delays)
+	_, _, err := cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.Len(clock.delays, 1)
+}
+
+func TestQueryRateLimitStopsWaitingWhenContextIsDone(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			return nil
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithQueryRateLimit(ctx, 1))
+
+	_, _, err := cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+
+	start := time.Now()
+	_, _, err = cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id)
+	assert.Equal(UnavailableError{context.DeadlineExceeded}, err)
+	assert.True(time.Since(start) < 500*time.Millisecond, "The wait should end when the context is done")
+}
+
+func TestDrainReturnsWhenContextIsDone(t *testing.T) {
+	assert := assert.New(t)
+
+	writing := make(chan bool, 1)
+	release := make(chan bool)
+	defer close(release)
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			if queries[0].Result == nil {
+				writing <- true
+				<-release
+			}
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	go cypherDriver.Write(testFinancialInstrument, test_trans_id)
+	<-writing
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, cypherDriver.Drain(ctx))
+}
+
+func TestSecondaryFailureDoesNotFailWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	primaryBatches := 0
+	primary := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			primaryBatches++
+			return nil
+		},
+	}
+	mirrored := []*neoism.CypherQuery{}
+	secondary := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			mirrored = append(mirrored, queries...)
+			return errors.New("secondary unavailable")
+		},
+	}
+
+	cypherDriver := NewCypherFinancialInstrumentService(primary, primary, WithSecondary(secondary))
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id))
+	assert.NotEmpty(mirrored)
+	for _, query := range mirrored {
+		assert.Nil(query.Result)
+	}
+
+	batches := primaryBatches
+	mirrored = mirrored[:0]
+	_, _, err := cypherDriver.ReadTyped(testIncompleteFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.Equal(batches+1, primaryBatches)
+	assert.Empty(mirrored)
+}
+
+func TestEmptyWritesRunNoQueries(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatal("No batch should be run for an empty write")
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithSecondary(conn))
+
+	assert.NoError(writeErr(cypherDriver.WriteBatch(nil, test_trans_id, WriteOptions{})))
+	assert.NoError(writeErr(cypherDriver.WriteBatch([]financialInstrument{}, test_trans_id, WriteOptions{PreserveRelationships: true})))
+	assert.Equal(0, cypherDriver.WriteEach(nil, test_trans_id, WriteOptions{}))
+	assert.NoError(cypherDriver.cypherBatch(nil))
+}
+
+func TestWriteEachGivesFailedWritesToDeadLetterSink(t *testing.T) {
+	assert := assert.New(t)
+
+	writeFailure := errors.New("write failed")
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			for _, query := range queries {
+				if query.Parameters["uuid"] == specialCharactersFinancialInstrumentUUID {
+					return writeFailure
+				}
+			}
+			return nil
+		},
+	}
+	auditSink := &recordingAuditSink{}
+	deadLetterSink := &recordingDeadLetterSink{}
+
+	invalidFinancialInstrument := incompleteFinancialInstrument
+	invalidFinancialInstrument.Currency = "XXXX"
+
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithAuditSink(auditSink), WithDeadLetterSink(deadLetterSink))
+	written := cypherDriver.WriteEach([]financialInstrument{testFinancialInstrument, invalidFinancialInstrument, specialCharactersFinancialInstrument}, test_trans_id, WriteOptions{})
+
+	assert.Equal(1, written)
+	assert.Len(auditSink.records, 1)
+	assert.Equal(testFinancialInstrumentUUID, auditSink.records[0].UUID)
+
+	assert.Len(deadLetterSink.deadLetters, 2)
+	assert.Equal(invalidFinancialInstrument, deadLetterSink.deadLetters[0].fi)
+	assert.IsType(requestError{}, deadLetterSink.deadLetters[0].err)
+	assert.Equal(specialCharactersFinancialInstrument, deadLetterSink.deadLetters[1].fi)
+	assert.Equal(writeFailure, deadLetterSink.deadLetters[1].err)
+
+	deadLetterSink.deadLetters = nil
+	stream := &bytes.Buffer{}
+	enc := json.NewEncoder(stream)
+	for _, fi := range []financialInstrument{testFinancialInstrument, invalidFinancialInstrument, specialCharactersFinancialInstrument} {
+		assert.NoError(enc.Encode(fi))
+	}
+
+	written, err := cypherDriver.WriteStream(stream, test_trans_id, false)
+	assert.NoError(err, "A stream shouldn't stop at financial instruments given to the sink")
+	assert.Equal(1, written)
+
+	assert.Len(deadLetterSink.deadLetters, 2)
+	assert.Equal(testIncompleteFinancialInstrumentUUID, deadLetterSink.deadLetters[0].fi.UUID)
+	assert.IsType(requestError{}, deadLetterSink.deadLetters[0].err)
+	assert.Equal(specialCharactersFinancialInstrumentUUID, deadLetterSink.deadLetters[1].fi.UUID)
+	assert.Equal(writeFailure, deadLetterSink.deadLetters[1].err)
+}
+
+//trustedConn simulates the queries writing financial instruments with the trust levels they are stored with in trustLevels,
+//recording which were written
+func trustedConn(trustLevels map[string]int, written *[]string) mockNeoConnection {
+	return guardedConn(
+		func(query *neoism.CypherQuery, guard map[string]interface{}) string {
+			if trustLevels[guard["uuid"].(string)] > guard["trustLevel"].(int) {
+				return skippedLessTrusted
+			}
+			return ""
+		},
+		func(uuid string, props map[string]interface{}) {
+			*written = append(*written, uuid)
+			trustLevel, _ := props["trustLevel"].(int)
+			trustLevels[uuid] = trustLevel
+		})
+}
+
+func TestWriteOnlyIfHigherTrust(t *testing.T) {
+	assert := assert.New(t)
+
+	written := []string{}
+	conn := trustedConn(map[string]int{testFinancialInstrumentUUID: 2}, &written)
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+	opts := WriteOptions{OnlyIfHigherTrust: true}
+
+	lessTrusted := testFinancialInstrument
+	lessTrusted.TrustLevel = 1
+	result, err := cypherDriver.WriteWithOptions(lessTrusted, test_trans_id, opts)
+	assert.NoError(err, "Skipping a less trusted write isn't an error")
+	assert.Equal(WriteResult{Skipped: []string{testFinancialInstrumentUUID}}, result)
+	assert.Empty(written)
+
+	// Without the option trust is ignored, and the stored trust level is lowered to 1
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(lessTrusted, test_trans_id, WriteOptions{})))
+	assert.Equal([]string{testFinancialInstrumentUUID}, written)
+
+	written = written[:0]
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(lessTrusted, test_trans_id, opts)), "An equally trusted write should be applied")
+	assert.Equal([]string{testFinancialInstrumentUUID}, written)
+
+	written = written[:0]
+	moreTrusted := testFinancialInstrument
+	moreTrusted.TrustLevel = 3
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(moreTrusted, test_trans_id, opts)))
+	assert.Equal([]string{testFinancialInstrumentUUID}, written)
+
+	written = written[:0]
+	result, err = cypherDriver.WriteWithOptions(lessTrusted, test_trans_id, opts)
+	assert.NoError(err)
+	assert.Equal(WriteResult{Skipped: []string{testFinancialInstrumentUUID}}, result)
+	assert.Empty(written)
+}
+
+func TestWriteRequireExistingIssuer(t *testing.T) {
+	assert := assert.New(t)
+
+	written := 0
+	issuers := `[]`
+	things := `[]`
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			if strings.Contains(queries[0].Statement, "RETURN i.value as value, org.uuid as uuid") {
+				setQueryResult(queries[0], issuers)
+				setQueryResult(queries[1], things)
+				return nil
+			}
+			written++
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	_, err := cypherDriver.WriteWithOptions(testFinancialInstrument, test_trans_id, WriteOptions{RequireExistingIssuer: true})
+	if assert.IsType(requestError{}, err) {
+		assert.Contains(err.(requestError).InvalidRequestDetails(), orgUUID)
+	}
+	assert.Equal(0, written)
+
+	_, err = cypherDriver.WriteBatch([]financialInstrument{incompleteFinancialInstrument, testFinancialInstrument}, test_trans_id, WriteOptions{RequireExistingIssuer: true})
+	assert.IsType(requestError{}, err)
+	assert.Equal(0, written)
+
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(incompleteFinancialInstrument, test_trans_id, WriteOptions{RequireExistingIssuer: true})),
+		"A financial instrument without an issuer doesn't need one to exist")
+	assert.Equal(1, written)
+
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(testFinancialInstrument, test_trans_id, WriteOptions{})), "By default an unknown issuer is created")
+	assert.Equal(2, written)
+
+	things = `[{"value": "` + orgUUID + `", "uuid": "` + orgUUID + `"}]`
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(testFinancialInstrument, test_trans_id, WriteOptions{RequireExistingIssuer: true})),
+		"An issuer that is a Thing without an identifier exists")
+	assert.Equal(3, written)
+
+	issuers = `[{"value": "` + orgUUID + `", "uuid": "` + orgUUID + `"}]`
+	things = `[]`
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(testFinancialInstrument, test_trans_id, WriteOptions{RequireExistingIssuer: true})))
+	assert.Equal(4, written)
+}
+
+func TestWriteBatchOnlyIfHigherTrustWritesTheRest(t *testing.T) {
+	assert := assert.New(t)
+
+	written := []string{}
+	auditSink := &recordingAuditSink{}
+	deadLetterSink := &recordingDeadLetterSink{}
+	conn := trustedConn(map[string]int{testFinancialInstrumentUUID: 2}, &written)
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithAuditSink(auditSink), WithDeadLetterSink(deadLetterSink))
+	opts := WriteOptions{OnlyIfHigherTrust: true}
+
+	result, err := cypherDriver.WriteBatch([]financialInstrument{testFinancialInstrument, incompleteFinancialInstrument}, test_trans_id, opts)
+	assert.NoError(err)
+	assert.Equal(WriteResult{Skipped: []string{testFinancialInstrumentUUID}}, result)
+	assert.Equal([]string{testIncompleteFinancialInstrumentUUID}, written)
+	assert.Len(auditSink.records, 1)
+	assert.Equal(testIncompleteFinancialInstrumentUUID, auditSink.records[0].UUID)
+
+	written = written[:0]
+	result, err = cypherDriver.WriteBatch([]financialInstrument{testFinancialInstrument}, test_trans_id, opts)
+	assert.NoError(err)
+	assert.Equal(WriteResult{Skipped: []string{testFinancialInstrumentUUID}}, result)
+	assert.Empty(written)
+
+	assert.Equal(1, cypherDriver.WriteEach([]financialInstrument{testFinancialInstrument, incompleteFinancialInstrument}, test_trans_id, opts))
+	assert.Empty(deadLetterSink.deadLetters, "Skipped financial instruments haven't failed")
+}
+
+//keyedConn simulates the queries writing financial instruments with the idempotency keys they were last written with in keys,
+//recording which were written
+func keyedConn(keys map[string]string, written *[]string) mockNeoConnection {
+	return guardedConn(
+		func(query *neoism.CypherQuery, guard map[string]interface{}) string {
+			if key, ok := keys[guard["uuid"].(string)]; ok && key == query.Parameters["idempotencyKey"] {
+				return skippedDuplicate
+			}
+			return ""
+		},
+		func(uuid string, props map[string]interface{}) {
+			*written = append(*written, uuid)
+			key, _ := props["idempotencyKey"].(string)
+			keys[uuid] = key
+		})
+}
+
+//guardedConn simulates the writeGuard's queries, skipping the write of each financial instrument that decide gives a reason for,
+//and calls write with the properties of each financial instrument that is written
+func guardedConn(decide func(query *neoism.CypherQuery, guard map[string]interface{}) string, write func(uuid string, props map[string]interface{})) mockNeoConnection {
+	return mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			skipped := map[string]string{}
+			for _, query := range queries {
+				switch {
+				case strings.Contains(query.Statement, "SET t.writeSkipped = CASE"):
+					for _, guard := range query.Parameters["guards"].([]map[string]interface{}) {
+						if reason := decide(query, guard); reason != "" {
+							skipped[guard["uuid"].(string)] = reason
+						}
+					}
+				case strings.Contains(query.Statement, "REMOVE t.writeSkipped"):
+					rows := []string{}
+					for _, uuid := range query.Parameters["uuids"].([]string) {
+						if reason, ok := skipped[uuid]; ok {
+							rows = append(rows, fmt.Sprintf(`{"uuid": "%s", "reason": "%s"}`, uuid, reason))
+						}
+					}
+					setQueryResult(query, "["+strings.Join(rows, ",")+"]")
+				default:
+					if guardUUID, ok := query.Parameters["guardUuid"].(string); ok && skipped[guardUUID] != "" {
+						continue
+					}
+					if props, ok := query.Parameters["props"].(map[string]interface{}); ok {
+						write(query.Parameters["uuid"].(string), props)
+					}
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func TestWriteWithIdempotencyKey(t *testing.T) {
+	assert := assert.New(t)
+
+	written := []string{}
+	keys := map[string]string{}
+	conn := keyedConn(keys, &written)
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+	opts := WriteOptions{IdempotencyKey: "event-1"}
+
+	result, err := cypherDriver.WriteWithOptions(testFinancialInstrument, test_trans_id, opts)
+	assert.NoError(err)
+	assert.Empty(result.Duplicates)
+	assert.Equal([]string{testFinancialInstrumentUUID}, written)
+	assert.Equal("event-1", keys[testFinancialInstrumentUUID])
+
+	written = written[:0]
+	result, err = cypherDriver.WriteWithOptions(testFinancialInstrument, test_trans_id, opts)
+	assert.NoError(err, "A duplicate has already been written, so isn't an error")
+	assert.Equal(WriteResult{Duplicates: []string{testFinancialInstrumentUUID}}, result)
+	assert.Empty(written)
+
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(testFinancialInstrument, test_trans_id, WriteOptions{IdempotencyKey: "event-2"})))
+	assert.Equal([]string{testFinancialInstrumentUUID}, written)
+
+	written = written[:0]
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(testFinancialInstrument, test_trans_id, WriteOptions{})), "A write without a key is always applied")
+	assert.Equal([]string{testFinancialInstrumentUUID}, written)
+	assert.Empty(keys[testFinancialInstrumentUUID])
+}
+
+func TestWriteWithIdempotencyKeyComparesKeyInTheWriteTransaction(t *testing.T) {
+	assert := assert.New(t)
+
+	batches := [][]*neoism.CypherQuery{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			batches = append(batches, queries)
+			if strings.Contains(queries[len(queries)-1].Statement, "REMOVE t.writeSkipped") {
+				setQueryResult(queries[len(queries)-1], `[]`)
+			}
+			return nil
+		},
+	}
+
+	assert.NoError(writeErr(NewCypherFinancialInstrumentService(conn, conn).WriteWithOptions(testFinancialInstrument, test_trans_id, WriteOptions{IdempotencyKey: "event-1"})))
+	if assert.NotEmpty(batches) {
+		write := batches[len(batches)-1]
+		assert.Contains(write[0].Statement, "coalesce(t.idempotencyKey, '') = {idempotencyKey}")
+		for _, query := range write[1 : len(write)-1] {
+			assert.Equal(testFinancialInstrumentUUID, query.Parameters["guardUuid"], "every write query should be guarded: %s", query.Statement)
+		}
+		for _, batch := range batches[:len(batches)-1] {
+			for _, query := range batch {
+				assert.NotContains(query.Statement, "idempotencyKey", "the key shouldn't be compared outside the write")
+			}
+		}
+	}
+}
+
+func TestWriteBatchWithIdempotencyKeyWritesTheRest(t *testing.T) {
+	assert := assert.New(t)
+
+	written := []string{}
+	conn := keyedConn(map[string]string{testFinancialInstrumentUUID: "event-1"}, &written)
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+	opts := WriteOptions{IdempotencyKey: "event-1"}
+
+	result, err := cypherDriver.WriteBatch([]financialInstrument{testFinancialInstrument, incompleteFinancialInstrument}, test_trans_id, opts)
+	assert.NoError(err)
+	assert.Equal(WriteResult{Duplicates: []string{testFinancialInstrumentUUID}}, result)
+	assert.Equal([]string{testIncompleteFinancialInstrumentUUID}, written)
+
+	written = written[:0]
+	result, err = cypherDriver.WriteBatch([]financialInstrument{testFinancialInstrument, incompleteFinancialInstrument}, test_trans_id, opts)
+	assert.NoError(err)
+	assert.Equal(WriteResult{Duplicates: []string{testFinancialInstrumentUUID, testIncompleteFinancialInstrumentUUID}}, result)
+	assert.Empty(written)
+
+	assert.Equal(0, cypherDriver.WriteEach([]financialInstrument{testFinancialInstrument}, test_trans_id, opts),
+		"A duplicate isn't written, or dead lettered")
+}
+
+func TestWriteWithNegativeTrustLevelFails(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			t.Fatalf("No queries should be run for an invalid financial instrument, but ran %s", queries[0].Statement)
+			return nil
+		},
+	}
+
+	untrusted := testFinancialInstrument
+	untrusted.TrustLevel = -1
+	assert.IsType(requestError{}, NewCypherFinancialInstrumentService(conn, conn).Write(untrusted, test_trans_id))
+}
+
+func TestWriteIsRecordedToAuditSinkAndCanBeReplayed(t *testing.T) {
+	assert := assert.New(t)
+
+	batches := 0
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			batches++
+			return nil
+		},
+	}
+	sink := &recordingAuditSink{}
+
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithAuditSink(sink))
+	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id))
+	assert.NoError(writeErr(cypherDriver.WriteWithOptions(testFinancialInstrument, test_trans_id, WriteOptions{PreserveRelationships: true})))
+
+	assert.Len(sink.records, 2)
+	assert.Equal(auditWrite, sink.records[0].Operation)
+	assert.Equal(testIncompleteFinancialInstrumentUUID, sink.records[0].UUID)
+	assert.Equal(incompleteFinancialInstrument, *sink.records[0].Payload)
+	assert.True(sink.records[1].Options.PreserveRelationships)
+
+	log := &bytes.Buffer{}
+	enc := json.NewEncoder(log)
+	for _, record := range sink.records {
+		assert.NoError(enc.Encode(record))
+	}
+
+	// testFinancialInstrument has an issuer, so needs an extra batch to look it up
+	batches = 0
+	applied, err := cypherDriver.Replay(log)
+	assert.NoError(err)
+	assert.Equal(2, applied)
+	assert.Equal(3, batches)
+	assert.Len(sink.records, 2, "Replayed writes should not be recorded again")
+}
+
+func TestReplayStopsAtUnknownOperation(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			return nil
+		},
+	}
+
+	log := strings.NewReader(`{"operation": "write", "uuid": "` + testIncompleteFinancialInstrumentUUID + `", "payload": {"uuid": "` + testIncompleteFinancialInstrumentUUID + `"}}
+{"operation": "merge", "uuid": "` + testFinancialInstrumentUUID + `"}`)
+
+	applied, err := NewCypherFinancialInstrumentService(conn, conn).Replay(log)
+	assert.Error(err)
+	assert.Equal(1, applied)
+}
+
+func TestDeleteIsRecordedToAuditSink(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	sink := &recordingAuditSink{}
+	cypherDriver := NewCypherFinancialInstrumentService(db, db, WithAuditSink(sink))
+	cypherDriver.Initialise()
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+	found, err := cypherDriver.Delete(testFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.True(found)
+
+	assert.Len(sink.records, 2)
+	assert.Equal(AuditRecord{Operation: auditDelete, UUID: testFinancialInstrumentUUID, TransactionID: test_trans_id}, sink.records[1])
+}
+
+func TestWriteAndDeleteNotifyChangeHandler(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	changes := []string{}
+	cypherDriver := NewCypherFinancialInstrumentService(db, db, WithChangeHandler(func(uuid string, kind string) {
+		changes = append(changes, kind+" "+uuid)
+	}))
+	cypherDriver.Initialise()
+	defer cleanDB(db, assert)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+	found, err := cypherDriver.Delete(testFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.True(found)
+	found, err = cypherDriver.Delete(testFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.False(found)
+
+	assert.Equal([]string{ChangeWrite + " " + testFinancialInstrumentUUID, ChangeDelete + " " + testFinancialInstrumentUUID}, changes)
+}
+
+func TestWriteNotifiesChangeHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	failing := false
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			if failing {
+				return errors.New("write failed")
+			}
+			return nil
+		},
+	}
+	changes := []string{}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithChangeHandler(func(uuid string, kind string) {
+		changes = append(changes, kind+" "+uuid)
+	}))
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id))
+	assert.NoError(writeErr(cypherDriver.WriteBatch([]financialInstrument{incompleteFinancialInstrument, specialCharactersFinancialInstrument}, test_trans_id, WriteOptions{})))
+	failing = true
+	assert.Error(cypherDriver.Write(testFinancialInstrument, test_trans_id))
+
+	assert.Equal([]string{
+		ChangeWrite + " " + testFinancialInstrumentUUID,
+		ChangeWrite + " " + testIncompleteFinancialInstrumentUUID,
+		ChangeWrite + " " + specialCharactersFinancialInstrumentUUID,
+	}, changes, "Only successful writes should be notified, in order")
+}
+
+func TestChangeHandlerPanicDoesNotFailWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			return nil
+		},
+	}
+	sink := &recordingAuditSink{}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithAuditSink(sink), WithChangeHandler(func(uuid string, kind string) {
+		panic("handler failed")
+	}))
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id))
+	assert.Len(sink.records, 1)
+}
+
+func TestReadUnidentified(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	cypherDriver := getCypherDriver(db)
+	defer cleanDB(db, assert)
+
+	unidentifiedFinancialInstrument := financialInstrument{
+		UUID:      testIncompleteFinancialInstrumentUUID,
+		PrefLabel: "UNIDENTIFIED",
 		AlternativeIdentifiers: alternativeIdentifiers{
-			UUIDS:             []string{duplicateFinancialInstrumentUUID},
-			FactsetIdentifier: testFinancialInstrument.AlternativeIdentifiers.FactsetIdentifier,
+			UUIDS: []string{testIncompleteFinancialInstrumentUUID},
 		},
 	}
-	err := cypherDriver.Write(duplicateFinancialInstrument, test_trans_id)
-	assert.Error(err)
-	assert.IsType(rwapi.ConstraintOrTransactionError{}, err)
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+	assert.NoError(cypherDriver.Write(unidentifiedFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+
+	found := []financialInstrument{}
+	for skip := 0; ; skip += 100 {
+		page, err := cypherDriver.ReadUnidentified(skip, 100)
+		assert.NoError(err)
+		if len(page) == 0 {
+			break
+		}
+		found = append(found, page...)
+	}
+
+	uuids := map[string]financialInstrument{}
+	for _, fi := range found {
+		uuids[fi.UUID] = fi
+	}
+	assert.Equal(unidentifiedFinancialInstrument, uuids[testIncompleteFinancialInstrumentUUID])
+	assert.NotContains(uuids, testFinancialInstrumentUUID)
 }
 
-func TestWriteFinancialInstrumentsWithSameFigiCodesFails(t *testing.T) {
+func TestCountAndIDsCanExcludeTestData(t *testing.T) {
 	assert := assert.New(t)
-
 	db := getDatabaseConnectionAndCheckClean(t, assert)
 	cypherDriver := getCypherDriver(db)
 	defer cleanDB(db, assert)
 
-	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to create financial instrument")
+	testDataFinancialInstrument := incompleteFinancialInstrument
+	testDataFinancialInstrument.IsTest = true
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+	assert.NoError(cypherDriver.Write(testDataFinancialInstrument, test_trans_id), "Failed to write financial instrument")
+	readAndCompare(testDataFinancialInstrument, t, db)
 
-	duplicateFinancialInstrument := financialInstrument{
-		UUID:      duplicateFinancialInstrumentUUID,
-		PrefLabel: testFinancialInstrument.PrefLabel,
-		AlternativeIdentifiers: alternativeIdentifiers{
-			UUIDS:    []string{duplicateFinancialInstrumentUUID},
-			FIGICode: testFinancialInstrument.AlternativeIdentifiers.FIGICode,
+	all, err := cypherDriver.Count()
+	assert.NoError(err)
+	withoutTestData, err := cypherDriver.CountWithOptions(ListOptions{ExcludeTest: true})
+	assert.NoError(err)
+	assert.Equal(all-1, withoutTestData)
+
+	ids := map[string]bool{}
+	err = cypherDriver.IDsWithOptions(ListOptions{ExcludeTest: true}, func(id rwapi.IDEntry) (bool, error) {
+		ids[id.ID] = true
+		return true, nil
+	})
+	assert.NoError(err)
+	assert.True(ids[testFinancialInstrumentUUID])
+	assert.False(ids[testIncompleteFinancialInstrumentUUID])
+
+	testData, err := cypherDriver.ReadTestData(0, 10)
+	assert.NoError(err)
+	assert.Len(testData, 1)
+	assert.Equal(testIncompleteFinancialInstrumentUUID, testData[0].UUID)
+}
+
+func TestWriteBatchResolvesSharedIssuersInOneQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	resolutionQueries := 0
+	writeBatches := 0
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			if _, ok := queries[0].Parameters["values"]; ok {
+				resolutionQueries++
+				assert.ElementsMatch([]string{orgUUID, upToDateOrgUUID}, queries[0].Parameters["values"])
+				setQueryResult(queries[0], `[{"value": "`+orgUUID+`", "uuid": "`+orgUUID+`"}]`)
+				return nil
+			}
+			writeBatches++
+			return nil
 		},
 	}
-	err := cypherDriver.Write(duplicateFinancialInstrument, test_trans_id)
-	assert.Error(err)
-	assert.IsType(rwapi.ConstraintOrTransactionError{}, err)
+
+	secondFinancialInstrument := specialCharactersFinancialInstrument
+	secondFinancialInstrument.AlternativeIdentifiers.FactsetIdentifier = "LQ6FS3-S"
+	secondFinancialInstrument.AlternativeIdentifiers.FIGICode = "BBG0066578X7"
+	thirdFinancialInstrument := incompleteFinancialInstrument
+	thirdFinancialInstrument.IssuedBy = upToDateOrgUUID
+
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+	_, err := cypherDriver.WriteBatch([]financialInstrument{testFinancialInstrument, secondFinancialInstrument, thirdFinancialInstrument}, test_trans_id, WriteOptions{})
+	assert.NoError(err)
+	assert.Equal(1, resolutionQueries)
+	assert.Equal(1, writeBatches)
 }
 
-func TestDeletingNotExistingFinancialInstrument(t *testing.T) {
+func periodicCommitFinancialInstruments() []financialInstrument {
+	secondFinancialInstrument := specialCharactersFinancialInstrument
+	secondFinancialInstrument.AlternativeIdentifiers.FactsetIdentifier = "LQ6FS3-S"
+	secondFinancialInstrument.AlternativeIdentifiers.FIGICode = "BBG0066578X7"
+	thirdFinancialInstrument := incompleteFinancialInstrument
+	thirdFinancialInstrument.AlternativeIdentifiers.FactsetIdentifier = "B0YBKJ-S"
+	return []financialInstrument{testFinancialInstrument, secondFinancialInstrument, thirdFinancialInstrument}
+}
+
+func TestWriteBatchWithPeriodicCommitFallsBackWithoutAPOC(t *testing.T) {
 	assert := assert.New(t)
-	db := getDatabaseConnectionAndCheckClean(t, assert)
-	defer cleanDB(db, assert)
 
-	cypherDriver := getCypherDriver(db)
-	res, err := cypherDriver.Delete(testFinancialInstrumentUUID, test_trans_id)
+	// The error Neo4j returns without APOC, as neoism returns it and as neoutils' runners return it
+	notFoundErrs := []error{
+		neoism.TxErrorList{{Code: "Neo.ClientError.Procedure.ProcedureNotFound", Message: "There is no procedure with the name `apoc.periodic.iterate` registered for this database instance."}},
+		rwapi.ConstraintOrTransactionError{Message: "Transaction failed", Details: []string{"Neo.ClientError.Procedure.ProcedureNotFound: There is no procedure with the name `apoc.periodic.iterate` registered for this database instance."}},
+	}
+	for _, notFoundErr := range notFoundErrs {
+		apocCalls := 0
+		identifierBatches := [][]interface{}{}
+		conn := mockNeoConnection{
+			cypherBatch: func(queries []*neoism.CypherQuery) error {
+				if strings.Contains(queries[0].Statement, "apoc.periodic.iterate") {
+					apocCalls++
+					return notFoundErr
+				}
+				identified := []interface{}{}
+				for _, query := range queries {
+					if _, ok := query.Parameters["value"]; ok && strings.Contains(query.Statement, "IDENTIFIES") {
+						identified = append(identified, query.Parameters["uuid"])
+					}
+				}
+				if len(identified) > 0 {
+					identifierBatches = append(identifierBatches, identified)
+				}
+				return nil
+			},
+		}
 
-	assert.NoError(err)
-	assert.False(res)
+		cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithPeriodicCommit(2))
+		assert.NoError(writeErr(cypherDriver.WriteBatch(periodicCommitFinancialInstruments(), test_trans_id, WriteOptions{})), "%T", notFoundErr)
+		assert.Equal(1, apocCalls)
+		assert.Equal([][]interface{}{
+			{testFinancialInstrumentUUID, testFinancialInstrumentUUID, testFinancialInstrumentUUID,
+				specialCharactersFinancialInstrumentUUID, specialCharactersFinancialInstrumentUUID, specialCharactersFinancialInstrumentUUID},
+			{testIncompleteFinancialInstrumentUUID, testIncompleteFinancialInstrumentUUID},
+		}, identifierBatches, "%T", notFoundErr)
+	}
 }
 
-func TestDelete(t *testing.T) {
+func TestWriteBatchWithPeriodicCommitUsesAPOC(t *testing.T) {
 	assert := assert.New(t)
-	db := getDatabaseConnectionAndCheckClean(t, assert)
-	cypherDriver := getCypherDriver(db)
-	defer cleanDB(db, assert)
 
-	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write person")
+	iterated := map[string]int{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			for _, query := range queries {
+				if strings.Contains(query.Statement, "apoc.periodic.iterate") {
+					assert.Equal(2, query.Parameters["batchSize"])
+					iterated[query.Parameters["inner"].(string)] += len(query.Parameters["rows"].([]map[string]interface{}))
+					setQueryResult(query, `[{"failedBatches": 0, "errorMessages": {}}]`)
+					continue
+				}
+				if _, ok := query.Parameters["value"]; ok && strings.Contains(query.Statement, "IDENTIFIES") {
+					t.Fatalf("Identifiers should be created by APOC, but ran %s", query.Statement)
+				}
+			}
+			return nil
+		},
+	}
 
-	found, err := cypherDriver.Delete(testFinancialInstrumentUUID, test_trans_id)
-	assert.True(found, "Failed to delete financial instrument with uuid: %s", testFinancialInstrumentUUID)
-	assert.NoError(err, "Error occurred while trying to delete financial instrument with uuid: %s", testFinancialInstrumentUUID)
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithPeriodicCommit(2))
+	assert.NoError(writeErr(cypherDriver.WriteBatch(periodicCommitFinancialInstruments(), test_trans_id, WriteOptions{})))
+	counts := []int{}
+	for inner, count := range iterated {
+		assert.Contains(inner, "CREATE (i:Identifier:")
+		counts = append(counts, count)
+	}
+	sort.Ints(counts)
+	assert.Equal([]int{2, 3, 3}, counts)
+}
 
-	fi, found, err := cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id)
-	assert.Equal(financialInstrument{}, fi, "The financial instrument with uuid: %s should have been deleted.", testFinancialInstrumentUUID)
-	assert.False(found, "Found financial instrument for uuid: %s which should have been deleted", testFinancialInstrumentUUID)
-	assert.NoError(err, "Error trying to find financial instrument for uuid: %s", testFinancialInstrumentUUID)
+func TestWriteBatchWithPeriodicCommitReportsFailedBatches(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			if strings.Contains(queries[0].Statement, "apoc.periodic.iterate") {
+				setQueryResult(queries[0], `[{"failedBatches": 1, "errorMessages": {"Node already exists": 1}}]`)
+			}
+			return nil
+		},
+	}
 
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithPeriodicCommit(2))
+	assert.Error(writeErr(cypherDriver.WriteBatch(periodicCommitFinancialInstruments(), test_trans_id, WriteOptions{})))
 }
 
-func TestCount(t *testing.T) {
+func TestWriteBatch(t *testing.T) {
 	assert := assert.New(t)
 	db := getDatabaseConnectionAndCheckClean(t, assert)
 	cypherDriver := getCypherDriver(db)
 	defer cleanDB(db, assert)
 
-	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id), "Failed to write person")
-	incompleteFinancialInstrument.AlternativeIdentifiers.FactsetIdentifier = "LQ6FS3-S"
-	assert.NoError(cypherDriver.Write(incompleteFinancialInstrument, test_trans_id), "Failed to write person")
+	secondFinancialInstrument := incompleteFinancialInstrument
+	secondFinancialInstrument.AlternativeIdentifiers.FactsetIdentifier = "LQ6FS3-S"
+	secondFinancialInstrument.IssuedBy = orgUUID
 
-	count, err := cypherDriver.Count()
-	assert.NoError(err, "Error trying to find the number of financial instruments")
-	assert.Equal(count, 2, "Expeting two results but got %d", count)
+	_, err := cypherDriver.WriteBatch([]financialInstrument{testFinancialInstrument, secondFinancialInstrument}, test_trans_id, WriteOptions{})
+	assert.NoError(err)
+
+	readAndCompare(testFinancialInstrument, t, db)
+	readAndCompare(secondFinancialInstrument, t, db)
+}
+
+func TestConfiguredIdentifierLabelsAreUsedForConstraintsAndWrites(t *testing.T) {
+	assert := assert.New(t)
+
+	statements := []string{}
+	constraints := map[string]string{}
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			for _, query := range queries {
+				statements = append(statements, query.Statement)
+			}
+			return nil
+		},
+		ensureConstraints: func(c map[string]string) error {
+			for label, property := range c {
+				constraints[label] = property
+			}
+			return nil
+		},
+	}
+
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn,
+		WithIdentifierLabel(uppIdentifierLabel, "UPPId"),
+		WithIdentifierLabel(figiIdentifierLabel, "FIGI"))
+
+	assert.NoError(cypherDriver.Initialise())
+	assert.Equal("value", constraints["UPPId"])
+	assert.Equal("value", constraints["FIGI"])
+	assert.Equal("value", constraints[factsetIdentifierLabel])
+	assert.NotContains(constraints, uppIdentifierLabel)
+	assert.NotContains(constraints, figiIdentifierLabel)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id))
+	_, _, err := cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+
+	all := strings.Join(statements, "\n")
+	assert.Contains(all, ":UPPId")
+	assert.Contains(all, ":FIGI")
+	assert.NotContains(all, uppIdentifierLabel)
+	assert.NotContains(all, figiIdentifierLabel)
+}
+
+func TestReadIsCachedUntilWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	prefLabel := "BEFORE"
+	reads := 0
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			if len(queries) == 1 && queries[0].Result != nil {
+				reads++
+				setQueryResult(queries[0], `[{"uuid": "`+testIncompleteFinancialInstrumentUUID+`", "prefLabel": "`+prefLabel+`"}]`)
+			}
+			return nil
+		},
+	}
+
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithCache(NewLRUCache(10)))
+
+	for i := 0; i < 2; i++ {
+		fi, found, err := cypherDriver.Read(testIncompleteFinancialInstrumentUUID, test_trans_id)
+		assert.NoError(err)
+		assert.True(found)
+		assert.Equal("BEFORE", fi.(financialInstrument).PrefLabel)
+	}
+	assert.Equal(1, reads)
+
+	updated := incompleteFinancialInstrument
+	updated.PrefLabel = "AFTER"
+	assert.NoError(cypherDriver.Write(updated, test_trans_id))
+	prefLabel = "AFTER"
+
+	fi, found, err := cypherDriver.Read(testIncompleteFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal("AFTER", fi.(financialInstrument).PrefLabel, "Read after Write should not return the stale cached value")
+	assert.Equal(2, reads)
+}
+
+func TestReadIgnoresCachedValueOfAnotherType(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			setQueryResult(queries[0], testReadRow)
+			return nil
+		},
+	}
+	cache := NewLRUCache(10)
+	cache.Set(testFinancialInstrumentUUID, "not a financial instrument")
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithCache(cache))
+
+	fi, found, err := cypherDriver.ReadTyped(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(testFinancialInstrumentUUID, fi.UUID)
+
+	cached, _ := cache.Get(testFinancialInstrumentUUID)
+	assert.Equal(fi, cached, "The value read should replace the one of another type")
+}
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	assert := assert.New(t)
+
+	cache := NewLRUCache(2)
+	cache.Set("a", financialInstrument{UUID: "a"})
+	cache.Set("b", financialInstrument{UUID: "b"})
+	_, found := cache.Get("a")
+	assert.True(found)
+
+	cache.Set("c", financialInstrument{UUID: "c"})
+
+	_, found = cache.Get("b")
+	assert.False(found, "b was least recently used so should have been evicted")
+	fi, found := cache.Get("a")
+	assert.True(found)
+	assert.Equal(financialInstrument{UUID: "a"}, fi)
+	_, found = cache.Get("c")
+	assert.True(found)
+
+	cache.Delete("c")
+	_, found = cache.Get("c")
+	assert.False(found)
+}
+
+const testReadRow = `[{"uuid": "` + testFinancialInstrumentUUID + `", "prefLabel": "GREENWICH CAP ACCEPTANCE  1991-B B1",
+	"aliases": ["GCA 1991-B B1"], "currency": "GBP", "micCode": null, "sector": null, "countryOfRisk": null, "isTest": null, "source": "factset", "trustLevel": null, "issueDate": "1991-06-01", "status": null, "primaryIdentifierType": null, "issuedBy": "` + orgUUID + `", "tags": [],
+	"uuids": ["` + testFinancialInstrumentUUID + `"], "figiCode": "` + figiCode + `", "factsetIdentifier": "` + facsetIdentifier + `", "wsodIdentifier": null,
+	"deprecatedIdentifiers": []}]`
+
+func TestReadWithAllIdentifierColumnsNull(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			setQueryResult(queries[0], `[{"uuid": "`+testFinancialInstrumentUUID+`", "prefLabel": null, "aliases": null, "currency": null, "micCode": null, "sector": null, "countryOfRisk": null,
+				"isTest": null, "source": null, "trustLevel": null, "issueDate": null, "status": null, "primaryIdentifierType": null, "issuedBy": null, "tags": [], "uuids": null, "figiCode": null, "factsetIdentifier": null, "wsodIdentifier": null, "deprecatedIdentifiers": []}]`)
+			return nil
+		},
+	}
+
+	fi, found, err := NewCypherFinancialInstrumentService(conn, conn).Read(testFinancialInstrumentUUID, test_trans_id)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(financialInstrument{UUID: testFinancialInstrumentUUID}, fi)
+}
+
+func TestFastDecodeReadsTheSameAsDefaultDecode(t *testing.T) {
+	assert := assert.New(t)
+
+	rows := []string{
+		testReadRow,
+		`[]`,
+		`[{"uuid": "` + testFinancialInstrumentUUID + `", "prefLabel": null, "aliases": null, "isTest": null, "trustLevel": null, "tags": [], "uuids": null, "deprecatedIdentifiers": []}]`,
+		`[{"uuid": "` + testFinancialInstrumentUUID + `", "prefLabel": "GREENWICH \"CAP\" ACCEPTANCE \u00e9\/\\", "aliases": ["\u2603", "B1"],
+			"isTest": true, "trustLevel": -12, "tags": ["` + orgUUID + `"], "extra": {"nested": [1, "]", {"a": null}]},
+			"relationshipProperties": {"ISSUED_BY": {"confidence": 0.9, "roles": ["issuer"]}}}]`,
+		" [ { \"uuid\" : \"" + testFinancialInstrumentUUID + "\" , \"isTest\" : false , \"trustLevel\" : 3 } ] ",
+	}
+	for _, row := range rows {
+		conn := mockNeoConnection{
+			cypherBatch: func(queries []*neoism.CypherQuery) error {
+				setQueryResult(queries[0], row)
+				return nil
+			},
+		}
+
+		expected, expectedFound, err := NewCypherFinancialInstrumentService(conn, conn, WithRelationshipProperties()).readStored(testFinancialInstrumentUUID)
+		assert.NoError(err, row)
+		actual, found, err := NewCypherFinancialInstrumentService(conn, conn, WithRelationshipProperties(), WithFastDecode()).readStored(testFinancialInstrumentUUID)
+		assert.NoError(err, row)
+		assert.Equal(expectedFound, found, row)
+		assert.Equal(expected, actual, row)
+	}
+}
+
+func TestFastDecodeRejectsWhatDefaultDecodeRejects(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, row := range []string{
+		`{}`,
+		`[{"uuid": 1}]`,
+		`[{"tags": "` + orgUUID + `"}]`,
+		`[{"tags": [1]}]`,
+		`[{"isTest": "true"}]`,
+		`[{"trustLevel": "high"}]`,
+		`[{"trustLevel": 1.5}]`,
+	} {
+		assert.Error(json.Unmarshal([]byte(row), &[]financialInstrumentRow{}), row)
+		assert.Error(json.Unmarshal([]byte(row), &fastRows{}), row)
+	}
+}
+
+func BenchmarkReadDecode(b *testing.B) {
+	// Rows of distinct financial instruments, as encoding/json caches the strings it decodes
+	rows := make([]string, 1000)
+	for i := range rows {
+		id := fmt.Sprintf("%08d", i)
+		rows[i] = strings.NewReplacer(testFinancialInstrumentUUID[:8], id, figiCode[4:], id, facsetIdentifier[:6], id[2:], "1991-B", id).Replace(testReadRow)
+	}
+	reads := 0
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			setQueryResult(queries[0], rows[reads%len(rows)])
+			reads++
+			return nil
+		},
+	}
+
+	benchmarks := []struct {
+		name         string
+		cypherDriver service
+	}{
+		{"reflection", NewCypherFinancialInstrumentService(conn, conn)},
+		{"fast", NewCypherFinancialInstrumentService(conn, conn, WithFastDecode())},
+	}
+	for _, benchmark := range benchmarks {
+		cypherDriver := benchmark.cypherDriver
+		b.Run(benchmark.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReadWithoutUUIDColumnFails(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			setQueryResult(queries[0], strings.Replace(testReadRow, `"uuid": "`+testFinancialInstrumentUUID+`", `, "", 1))
+			return nil
+		},
+	}
+
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+	_, found, err := cypherDriver.Read(testFinancialInstrumentUUID, test_trans_id)
+	assert.Error(err)
+	assert.False(found)
+
+	_, etag, found, err := cypherDriver.ReadWithETag(testFinancialInstrumentUUID)
+	assert.Error(err)
+	assert.False(found)
+	assert.Empty(etag)
+
+	_, err = cypherDriver.ReadByCurrency("GBP", 0, 10)
+	assert.Error(err)
+}
+
+func TestReadWithRelationshipProperties(t *testing.T) {
+	assert := assert.New(t)
+
+	issuedByProperties := `{"ISSUED_BY": {"confidence": 0.9}}`
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			assert.Contains(queries[0].Statement, "{ISSUED_BY: properties(issuedBy)} as relationshipProperties")
+			setQueryResult(queries[0], strings.Replace(testReadRow, `"deprecatedIdentifiers": []`, `"deprecatedIdentifiers": [], "relationshipProperties": `+issuedByProperties, 1))
+			return nil
+		},
+	}
+
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn, WithRelationshipProperties())
+	fi, found, err := cypherDriver.ReadTyped(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(map[string]map[string]interface{}{"ISSUED_BY": {"confidence": 0.9}}, fi.RelationshipProperties)
+
+	issuedByProperties = `{"ISSUED_BY": null}`
+	fi, found, err = cypherDriver.ReadTyped(testFinancialInstrumentUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Nil(fi.RelationshipProperties)
+}
+
+func TestRelationshipPropertiesAreNotHashed(t *testing.T) {
+	assert := assert.New(t)
+
+	withRelationshipProperties := testFinancialInstrument
+	withRelationshipProperties.RelationshipProperties = map[string]map[string]interface{}{"ISSUED_BY": {}}
+
+	expected, err := hashOf(testFinancialInstrument)
+	assert.NoError(err)
+	actual, err := hashOf(withRelationshipProperties)
+	assert.NoError(err)
+	assert.Equal(expected, actual)
+}
+
+func TestHashOfIgnoresLastModifiedAndListOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	lastModified := time.Now()
+	written := testFinancialInstrument
+	written.LastModified = &lastModified
+	written.Aliases = []string{"b", "a"}
+	written.Tags = []string{topicUUID, otherTopicUUID}
+	written.AlternativeIdentifiers.UUIDS = []string{testFinancialInstrumentUUID, rekeyedFinancialInstrumentUUID}
+
+	read := testFinancialInstrument
+	read.Aliases = []string{"a", "b"}
+	read.Tags = []string{otherTopicUUID, topicUUID}
+	read.AlternativeIdentifiers.UUIDS = []string{rekeyedFinancialInstrumentUUID, testFinancialInstrumentUUID}
+
+	expected, err := hashOf(written)
+	assert.NoError(err)
+	actual, err := hashOf(read)
+	assert.NoError(err)
+	assert.Equal(expected, actual)
+	assert.Equal([]string{"b", "a"}, written.Aliases, "hashOf shouldn't reorder the caller's lists")
+}
+
+func TestHashOfIgnoresEmptyListEntries(t *testing.T) {
+	assert := assert.New(t)
+
+	written := testFinancialInstrument
+	written.Aliases = []string{"", "a"}
+	written.Tags = []string{topicUUID, ""}
+	written.AlternativeIdentifiers.UUIDS = []string{testFinancialInstrumentUUID, ""}
+
+	read := testFinancialInstrument
+	read.Aliases = []string{"a"}
+	read.Tags = []string{topicUUID}
+	read.AlternativeIdentifiers.UUIDS = []string{testFinancialInstrumentUUID}
+
+	expected, err := hashOf(written)
+	assert.NoError(err)
+	actual, err := hashOf(read)
+	assert.NoError(err)
+	assert.Equal(expected, actual)
+}
+
+func TestDecodeJSONAcceptsIssuedByWithProperties(t *testing.T) {
+	assert := assert.New(t)
+
+	cypherDriver := NewCypherFinancialInstrumentService(nil, nil)
+	decode := func(issuedBy string) financialInstrument {
+		thing, uuid, err := cypherDriver.DecodeJSON(json.NewDecoder(strings.NewReader(`{"uuid": "` + testFinancialInstrumentUUID + `", "issuedBy": ` + issuedBy + `}`)))
+		assert.NoError(err)
+		assert.Equal(testFinancialInstrumentUUID, uuid)
+		return thing.(financialInstrument)
+	}
+
+	fi := decode(`"` + orgUUID + `"`)
+	assert.Equal(orgUUID, fi.IssuedBy)
+	assert.Nil(fi.RelationshipProperties)
+
+	fi = decode(`{"uuid": "` + orgUUID + `", "properties": {"role": "guarantor", "percentage": 40}}`)
+	assert.Equal(orgUUID, fi.IssuedBy)
+	assert.Equal(map[string]map[string]interface{}{"ISSUED_BY": {"role": "guarantor", "percentage": float64(40)}}, fi.RelationshipProperties)
+
+	fi = decode(`{"uuid": "` + orgUUID + `"}`)
+	assert.Equal(orgUUID, fi.IssuedBy)
+	assert.Nil(fi.RelationshipProperties)
+
+	fi = decode(`null`)
+	assert.Empty(fi.IssuedBy)
+
+	_, _, err := cypherDriver.DecodeJSON(json.NewDecoder(strings.NewReader(`{"uuid": "` + testFinancialInstrumentUUID + `", "issuedBy": 7}`)))
+	assert.Error(err)
+}
+
+func TestWriteSetsIssuedByProperties(t *testing.T) {
+	assert := assert.New(t)
+
+	var issuerQuery *neoism.CypherQuery
+	conn := mockNeoConnection{
+		cypherBatch: func(queries []*neoism.CypherQuery) error {
+			for _, query := range queries {
+				if strings.Contains(query.Statement, "ISSUED_BY]->(o)") {
+					issuerQuery = query
+				}
+			}
+			return nil
+		},
+	}
+	cypherDriver := NewCypherFinancialInstrumentService(conn, conn)
+
+	assert.NoError(cypherDriver.Write(testFinancialInstrument, test_trans_id))
+	assert.NotContains(issuerQuery.Statement, "SET issuedBy", "The properties of the relationship shouldn't be touched unless given")
+
+	withProperties := testFinancialInstrument
+	withProperties.RelationshipProperties = map[string]map[string]interface{}{"ISSUED_BY": {"role": "guarantor", "percentage": 40.0}}
+	assert.NoError(cypherDriver.Write(withProperties, test_trans_id))
+	assert.Contains(issuerQuery.Statement, "SET issuedBy = {issuedByProperties}")
+	assert.Equal(map[string]interface{}{"role": "guarantor", "percentage": 40.0}, issuerQuery.Parameters["issuedByProperties"])
+
+	invalid := []map[string]map[string]interface{}{
+		{"ISSUED_BY": {"role": map[string]interface{}{"nested": true}}},
+		{"ISSUED_BY": {"roles": []interface{}{[]interface{}{"nested"}}}},
+		{"TAGGED_WITH": {"role": "guarantor"}},
+	}
+	for _, relationshipProperties := range invalid {
+		withProperties.RelationshipProperties = relationshipProperties
+		assert.IsType(requestError{}, cypherDriver.Write(withProperties, test_trans_id), "%v", relationshipProperties)
+	}
+
+	withoutIssuer := incompleteFinancialInstrument
+	withoutIssuer.RelationshipProperties = map[string]map[string]interface{}{"ISSUED_BY": {"role": "guarantor"}}
+	assert.IsType(requestError{}, cypherDriver.Write(withoutIssuer, test_trans_id))
 }
 
 func readAndCompare(expectedValue financialInstrument, t *testing.T, db neoutils.NeoConnection) {
 	sort.Strings(expectedValue.AlternativeIdentifiers.UUIDS)
+	sort.Strings(expectedValue.Tags)
 
 	dbValue, found, err := getCypherDriver(db).Read(expectedValue.UUID, test_trans_id)
 	assert.NoError(t, err)
@@ -240,6 +5818,7 @@ func readAndCompare(expectedValue financialInstrument, t *testing.T, db neoutils
 
 	foundValue := dbValue.(financialInstrument)
 	sort.Strings(foundValue.AlternativeIdentifiers.UUIDS)
+	sort.Strings(foundValue.Tags)
 
 	assert.EqualValues(t, expectedValue, foundValue)
 }
@@ -268,8 +5847,11 @@ func cleanDB(db neoutils.CypherRunner, assert *assert.Assertions) {
 	qs := []*neoism.CypherQuery{}
 
 	for _, uuid := range uuidsToBeDeleted {
+		// History nodes are only found through the Thing, so they have to be deleted first
+		qs = append(qs, &neoism.CypherQuery{Statement: fmt.Sprintf("MATCH (h:IdentifierHistory)-[:WAS_IDENTIFIER_OF]->(:Thing {uuid: '%v'}) DETACH DELETE h", uuid)})
 		qs = append(qs, &neoism.CypherQuery{Statement: fmt.Sprintf("MATCH (org:Thing {uuid: '%v'})<-[:IDENTIFIES*0..]-(i:Identifier) DETACH DELETE org, i", uuid)})
 		qs = append(qs, &neoism.CypherQuery{Statement: fmt.Sprintf("MATCH (org:Thing {uuid: '%v'}) DETACH DELETE org", uuid)})
+		qs = append(qs, &neoism.CypherQuery{Statement: fmt.Sprintf("MATCH (fi:FinancialInstrument {uuid: '%v'}) DETACH DELETE fi", uuid)})
 	}
 
 	err := db.CypherBatch(qs)
@@ -301,7 +5883,64 @@ func checkDbClean(db neoutils.CypherRunner, t *testing.T) {
 }
 
 func getCypherDriver(db neoutils.NeoConnection) service {
-	cr := NewCypherFinancialInstrumentService(db)
+	cr := NewCypherFinancialInstrumentService(db, db)
 	cr.Initialise()
 	return cr
 }
+
+type mockNeoConnection struct {
+	cypherBatch       func(queries []*neoism.CypherQuery) error
+	ensureConstraints func(constraints map[string]string) error
+	ensureIndexes     func(indexes map[string]string) error
+}
+
+func (m mockNeoConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	return m.cypherBatch(queries)
+}
+
+func (m mockNeoConnection) EnsureConstraints(constraints map[string]string) error {
+	if m.ensureConstraints == nil {
+		return nil
+	}
+	return m.ensureConstraints(constraints)
+}
+
+func (m mockNeoConnection) EnsureIndexes(indexes map[string]string) error {
+	if m.ensureIndexes == nil {
+		return nil
+	}
+	return m.ensureIndexes(indexes)
+}
+
+//writeErr returns just the error of a write, for asserting on without its WriteResult
+func writeErr(_ WriteResult, err error) error {
+	return err
+}
+
+func setQueryResult(query *neoism.CypherQuery, rows string) {
+	if err := json.Unmarshal([]byte(rows), query.Result); err != nil {
+		panic(err)
+	}
+}
+
+type deadLetter struct {
+	fi  financialInstrument
+	err error
+}
+
+type recordingDeadLetterSink struct {
+	deadLetters []deadLetter
+}
+
+func (r *recordingDeadLetterSink) DeadLetter(fi financialInstrument, err error) {
+	r.deadLetters = append(r.deadLetters, deadLetter{fi, err})
+}
+
+type recordingAuditSink struct {
+	records []AuditRecord
+}
+
+func (r *recordingAuditSink) Record(record AuditRecord) error {
+	r.records = append(r.records, record)
+	return nil
+}
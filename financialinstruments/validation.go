@@ -0,0 +1,343 @@
+package financialinstruments
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+//countryCodes are the ISO 3166-1 alpha-2 country codes
+var countryCodes = map[string]bool{
+	"AD": true, "AE": true, "AF": true, "AG": true, "AI": true, "AL": true, "AM": true, "AO": true, "AQ": true, "AR": true, "AS": true, "AT": true,
+	"AU": true, "AW": true, "AX": true, "AZ": true, "BA": true, "BB": true, "BD": true, "BE": true, "BF": true, "BG": true, "BH": true, "BI": true,
+	"BJ": true, "BL": true, "BM": true, "BN": true, "BO": true, "BQ": true, "BR": true, "BS": true, "BT": true, "BV": true, "BW": true, "BY": true,
+	"BZ": true, "CA": true, "CC": true, "CD": true, "CF": true, "CG": true, "CH": true, "CI": true, "CK": true, "CL": true, "CM": true, "CN": true,
+	"CO": true, "CR": true, "CU": true, "CV": true, "CW": true, "CX": true, "CY": true, "CZ": true, "DE": true, "DJ": true, "DK": true, "DM": true,
+	"DO": true, "DZ": true, "EC": true, "EE": true, "EG": true, "EH": true, "ER": true, "ES": true, "ET": true, "FI": true, "FJ": true, "FK": true,
+	"FM": true, "FO": true, "FR": true, "GA": true, "GB": true, "GD": true, "GE": true, "GF": true, "GG": true, "GH": true, "GI": true, "GL": true,
+	"GM": true, "GN": true, "GP": true, "GQ": true, "GR": true, "GS": true, "GT": true, "GU": true, "GW": true, "GY": true, "HK": true, "HM": true,
+	"HN": true, "HR": true, "HT": true, "HU": true, "ID": true, "IE": true, "IL": true, "IM": true, "IN": true, "IO": true, "IQ": true, "IR": true,
+	"IS": true, "IT": true, "JE": true, "JM": true, "JO": true, "JP": true, "KE": true, "KG": true, "KH": true, "KI": true, "KM": true, "KN": true,
+	"KP": true, "KR": true, "KW": true, "KY": true, "KZ": true, "LA": true, "LB": true, "LC": true, "LI": true, "LK": true, "LR": true, "LS": true,
+	"LT": true, "LU": true, "LV": true, "LY": true, "MA": true, "MC": true, "MD": true, "ME": true, "MF": true, "MG": true, "MH": true, "MK": true,
+	"ML": true, "MM": true, "MN": true, "MO": true, "MP": true, "MQ": true, "MR": true, "MS": true, "MT": true, "MU": true, "MV": true, "MW": true,
+	"MX": true, "MY": true, "MZ": true, "NA": true, "NC": true, "NE": true, "NF": true, "NG": true, "NI": true, "NL": true, "NO": true, "NP": true,
+	"NR": true, "NU": true, "NZ": true, "OM": true, "PA": true, "PE": true, "PF": true, "PG": true, "PH": true, "PK": true, "PL": true, "PM": true,
+	"PN": true, "PR": true, "PS": true, "PT": true, "PW": true, "PY": true, "QA": true, "RE": true, "RO": true, "RS": true, "RU": true, "RW": true,
+	"SA": true, "SB": true, "SC": true, "SD": true, "SE": true, "SG": true, "SH": true, "SI": true, "SJ": true, "SK": true, "SL": true, "SM": true,
+	"SN": true, "SO": true, "SR": true, "SS": true, "ST": true, "SV": true, "SX": true, "SY": true, "SZ": true, "TC": true, "TD": true, "TF": true,
+	"TG": true, "TH": true, "TJ": true, "TK": true, "TL": true, "TM": true, "TN": true, "TO": true, "TR": true, "TT": true, "TV": true, "TW": true,
+	"TZ": true, "UA": true, "UG": true, "UM": true, "US": true, "UY": true, "UZ": true, "VA": true, "VC": true, "VE": true, "VG": true, "VI": true,
+	"VN": true, "VU": true, "WF": true, "WS": true, "YE": true, "YT": true, "ZA": true, "ZM": true, "ZW": true,
+}
+
+//currencyCodes are the active ISO 4217 currency codes
+var currencyCodes = map[string]bool{
+	"AED": true, "AFN": true, "ALL": true, "AMD": true, "ANG": true, "AOA": true, "ARS": true, "AUD": true, "AWG": true, "AZN": true,
+	"BAM": true, "BBD": true, "BDT": true, "BGN": true, "BHD": true, "BIF": true, "BMD": true, "BND": true, "BOB": true, "BOV": true,
+	"BRL": true, "BSD": true, "BTN": true, "BWP": true, "BYN": true, "BZD": true, "CAD": true, "CDF": true, "CHE": true, "CHF": true,
+	"CHW": true, "CLF": true, "CLP": true, "CNY": true, "COP": true, "COU": true, "CRC": true, "CUC": true, "CUP": true, "CVE": true,
+	"CZK": true, "DJF": true, "DKK": true, "DOP": true, "DZD": true, "EGP": true, "ERN": true, "ETB": true, "EUR": true, "FJD": true,
+	"FKP": true, "GBP": true, "GEL": true, "GHS": true, "GIP": true, "GMD": true, "GNF": true, "GTQ": true, "GYD": true, "HKD": true,
+	"HNL": true, "HRK": true, "HTG": true, "HUF": true, "IDR": true, "ILS": true, "INR": true, "IQD": true, "IRR": true, "ISK": true,
+	"JMD": true, "JOD": true, "JPY": true, "KES": true, "KGS": true, "KHR": true, "KMF": true, "KPW": true, "KRW": true, "KWD": true,
+	"KYD": true, "KZT": true, "LAK": true, "LBP": true, "LKR": true, "LRD": true, "LSL": true, "LYD": true, "MAD": true, "MDL": true,
+	"MGA": true, "MKD": true, "MMK": true, "MNT": true, "MOP": true, "MRU": true, "MUR": true, "MVR": true, "MWK": true, "MXN": true,
+	"MXV": true, "MYR": true, "MZN": true, "NAD": true, "NGN": true, "NIO": true, "NOK": true, "NPR": true, "NZD": true, "OMR": true,
+	"PAB": true, "PEN": true, "PGK": true, "PHP": true, "PKR": true, "PLN": true, "PYG": true, "QAR": true, "RON": true, "RSD": true,
+	"RUB": true, "RWF": true, "SAR": true, "SBD": true, "SCR": true, "SDG": true, "SEK": true, "SGD": true, "SHP": true, "SLL": true,
+	"SOS": true, "SRD": true, "SSP": true, "STN": true, "SVC": true, "SYP": true, "SZL": true, "THB": true, "TJS": true, "TMT": true,
+	"TND": true, "TOP": true, "TRY": true, "TTD": true, "TWD": true, "TZS": true, "UAH": true, "UGX": true, "USD": true, "USN": true,
+	"UYI": true, "UYU": true, "UZS": true, "VEF": true, "VND": true, "VUV": true, "WST": true, "XAF": true, "XAG": true, "XAU": true,
+	"XBA": true, "XBB": true, "XBC": true, "XBD": true, "XCD": true, "XDR": true, "XOF": true, "XPD": true, "XPF": true, "XPT": true,
+	"XSU": true, "XTS": true, "XUA": true, "XXX": true, "YER": true, "ZAR": true, "ZMW": true, "ZWL": true,
+}
+
+//uuidPattern is the format of a uuid, in either case
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+//labelPattern is the format of the labels identifiers can be written with, which are put into statements unquoted
+var labelPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+//micPattern is the format of an ISO 10383 market identifier code
+var micPattern = regexp.MustCompile(`^[0-9A-Z]{4}$`)
+
+//identifierFormats are deliberately loose patterns that identifiers of each type match, keyed by identifier type.
+//They are for spotting identifiers written with the wrong type, e.g. an ISIN as a FIGI, not for validating them,
+//so only a value that can't be of the type fails to match.
+var identifierFormats = map[string]*regexp.Regexp{
+	uppIdentifierLabel:     uuidPattern,
+	factsetIdentifierLabel: regexp.MustCompile(`^[0-9A-Z]{6}-[A-Z]$`),
+	figiIdentifierLabel:    regexp.MustCompile(`^[A-Z]{2}G[0-9A-Z]{9}$`),
+	wsodIdentifierLabel:    regexp.MustCompile(`^[0-9]+$`),
+}
+
+//validateRelationshipProperties checks the relationship properties of fi are only for its ISSUED_BY relationship,
+//and that each can be stored as a Neo4j property, i.e. is a string, number, boolean or list of them
+func validateRelationshipProperties(fi financialInstrument) error {
+	for relationshipType, properties := range fi.RelationshipProperties {
+		if relationshipType != "ISSUED_BY" {
+			return requestError{fmt.Sprintf("Cannot write properties of %s relationships, only ISSUED_BY", relationshipType)}
+		}
+		if len(properties) > 0 && fi.IssuedBy == "" {
+			return requestError{fmt.Sprintf("Financial instrument %s has ISSUED_BY properties but no issuedBy", fi.UUID)}
+		}
+		for name, value := range properties {
+			if !isPropertyValue(value, true) {
+				return requestError{fmt.Sprintf("Invalid ISSUED_BY property %s, must be a string, number, boolean or list of them", name)}
+			}
+		}
+	}
+	return nil
+}
+
+func isPropertyValue(value interface{}, listAllowed bool) bool {
+	switch value := value.(type) {
+	case string, float64, int, int64, bool:
+		return true
+	case []interface{}:
+		if !listAllowed {
+			return false
+		}
+		for _, v := range value {
+			if !isPropertyValue(v, false) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+//validate checks the parts of a financial instrument that Neo4j would otherwise store without complaint.
+//An IssuedBy surrounded by whitespace is accepted, as it is trimmed before it is written.
+func validate(fi financialInstrument) error {
+	if fi.IssuedBy != "" {
+		issuedBy := strings.TrimSpace(fi.IssuedBy)
+		if err := validateUUID(issuedBy); err != nil {
+			return requestError{fmt.Sprintf("Invalid issuedBy %q, must be a uuid", fi.IssuedBy)}
+		}
+		if issuedBy == fi.UUID {
+			return requestError{fmt.Sprintf("Financial instrument %s cannot be issued by itself", fi.UUID)}
+		}
+	}
+	if err := validateRelationshipProperties(fi); err != nil {
+		return err
+	}
+	if fi.Currency != "" {
+		if err := validateCurrency(fi.Currency); err != nil {
+			return err
+		}
+	}
+	if fi.MICCode != "" {
+		if err := validateMIC(fi.MICCode); err != nil {
+			return err
+		}
+	}
+	if fi.CountryOfRisk != "" {
+		if err := validateCountry(fi.CountryOfRisk); err != nil {
+			return err
+		}
+	}
+	if fi.IssueDate != "" {
+		if _, err := time.Parse(issueDateLayout, fi.IssueDate); err != nil {
+			return requestError{fmt.Sprintf("Invalid issueDate %q, must be a date in the form %s", fi.IssueDate, issueDateLayout)}
+		}
+	}
+	if fi.PrimaryIdentifierType != "" {
+		if err := validateIdentifierType(fi.PrimaryIdentifierType); err != nil {
+			return err
+		}
+		if len(identifierValues(fi, fi.PrimaryIdentifierType)) == 0 {
+			return requestError{fmt.Sprintf("Primary identifier type %s has no value in financial instrument %s", fi.PrimaryIdentifierType, fi.UUID)}
+		}
+	}
+	if fi.Status != "" {
+		if err := validateStatus(fi.Status); err != nil {
+			return err
+		}
+	}
+	if fi.TrustLevel < 0 {
+		return requestError{fmt.Sprintf("Invalid trustLevel %d, must not be negative", fi.TrustLevel)}
+	}
+	if len(fi.DeprecatedIdentifiers) > 0 {
+		identifiers := map[string]bool{}
+		for _, identifierType := range identifierTypes {
+			for _, value := range identifierValues(fi, identifierType) {
+				identifiers[value] = true
+			}
+		}
+		for _, deprecated := range fi.DeprecatedIdentifiers {
+			if !identifiers[deprecated] {
+				return requestError{fmt.Sprintf("Deprecated identifier %q is not one of the alternative identifiers of financial instrument %s", deprecated, fi.UUID)}
+			}
+		}
+	}
+	return nil
+}
+
+func validateUUID(uuid string) error {
+	if !uuidPattern.MatchString(uuid) {
+		return requestError{fmt.Sprintf("Invalid uuid %q", uuid)}
+	}
+	return nil
+}
+
+func validateCurrency(code string) error {
+	if !currencyCodes[code] {
+		return requestError{fmt.Sprintf("Invalid currency %q, must be an ISO 4217 currency code", code)}
+	}
+	return nil
+}
+
+//validateCountry checks code is one of countryCodes, as a countryOfRisk must be
+func validateCountry(code string) error {
+	if !countryCodes[code] {
+		return requestError{fmt.Sprintf("Invalid countryOfRisk %q, must be an ISO 3166-1 alpha-2 country code", code)}
+	}
+	return nil
+}
+
+//figiPrefixes are the first two characters a FIGI can never start with, so that it can't be mistaken for an ISIN
+var figiPrefixes = map[string]bool{"BS": true, "BM": true, "GG": true, "GB": true, "GH": true, "KY": true, "VG": true}
+
+//validateFIGI checks that code is a FIGI: two consonants other than one of figiPrefixes, then G,
+//then eight consonants or digits, then a check digit. The check digit is calculated as for an ISIN,
+//but without first expanding each letter into two digits.
+func validateFIGI(code string) error {
+	invalid := requestError{fmt.Sprintf("Invalid FIGI %q, must be 12 upper case consonants and digits with a correct check digit", code)}
+	if len(code) != 12 || figiPrefixes[code[:2]] || code[2] != 'G' {
+		return invalid
+	}
+
+	sum := 0
+	for i, c := range code[:11] {
+		var value int
+		switch {
+		case c >= '0' && c <= '9' && i > 2:
+			value = int(c - '0')
+		case c >= 'B' && c <= 'Z' && !strings.ContainsRune("EIOU", c):
+			value = int(c-'A') + 10
+		default:
+			return invalid
+		}
+		if i%2 == 1 {
+			value *= 2
+		}
+		sum += value/10 + value%10
+	}
+	if int(code[11]-'0') != (10-sum%10)%10 {
+		return invalid
+	}
+	return nil
+}
+
+//validateISIN checks that code is an ISIN with a correct check digit. The ISIN is converted to digits, with A-Z as 10-35,
+//and the Luhn mod 10 check applied to them.
+//The model doesn't yet have an ISIN, so this is not called by validate.
+func validateISIN(code string) error {
+	invalid := requestError{fmt.Sprintf("Invalid ISIN %q, must be 12 letters and digits with a correct check digit", code)}
+	if len(code) != 12 {
+		return invalid
+	}
+
+	digits := []int{}
+	for i, c := range code {
+		switch {
+		case c >= '0' && c <= '9':
+			if i < 2 {
+				return invalid
+			}
+			digits = append(digits, int(c-'0'))
+		case c >= 'A' && c <= 'Z' && i < 11:
+			value := int(c-'A') + 10
+			digits = append(digits, value/10, value%10)
+		default:
+			return invalid
+		}
+	}
+
+	sum := 0
+	for i := range digits {
+		digit := digits[len(digits)-1-i]
+		if i%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	if sum%10 != 0 {
+		return invalid
+	}
+	return nil
+}
+
+//sedolWeights are the weights of the characters of a SEDOL, including its check digit, in the weighted mod 10 check
+var sedolWeights = []int{1, 3, 1, 7, 3, 9, 1}
+
+//validateSEDOL checks that code is a SEDOL with a correct check digit. Letters, other than the vowels which SEDOLs never use,
+//count as 10-35 in the weighted sum.
+//The model doesn't yet have a SEDOL, so this is not called by validate.
+func validateSEDOL(code string) error {
+	invalid := requestError{fmt.Sprintf("Invalid SEDOL %q, must be 7 letters and digits with a correct check digit", code)}
+	if len(code) != len(sedolWeights) {
+		return invalid
+	}
+
+	sum := 0
+	for i, c := range code {
+		switch {
+		case c >= '0' && c <= '9':
+			sum += int(c-'0') * sedolWeights[i]
+		case c >= 'B' && c <= 'Z' && i < 6 && !strings.ContainsRune("EIOU", c):
+			sum += (int(c-'A') + 10) * sedolWeights[i]
+		default:
+			return invalid
+		}
+	}
+	if sum%10 != 0 {
+		return invalid
+	}
+	return nil
+}
+
+func validatePage(skip int, limit int) error {
+	if skip < 0 {
+		return requestError{fmt.Sprintf("Invalid skip %d, must not be negative", skip)}
+	}
+	if limit <= 0 {
+		return requestError{fmt.Sprintf("Invalid limit %d, must be greater than 0", limit)}
+	}
+	return nil
+}
+
+//validateStatus returns a requestError if status isn't one of statuses
+func validateStatus(status string) error {
+	for _, known := range statuses {
+		if status == known {
+			return nil
+		}
+	}
+	return requestError{fmt.Sprintf("Invalid status %q, expected one of %v", status, statuses)}
+}
+
+//validateType returns a requestError if typeLabel isn't one of typeLabels
+func validateType(typeLabel string) error {
+	for _, known := range typeLabels {
+		if typeLabel == known {
+			return nil
+		}
+	}
+	return requestError{fmt.Sprintf("Unknown type label %q, expected one of %v", typeLabel, typeLabels)}
+}
+
+//validateMIC returns a requestError if mic isn't in the format of an ISO 10383 market identifier code, e.g. XLON
+func validateMIC(mic string) error {
+	if !micPattern.MatchString(mic) {
+		return requestError{fmt.Sprintf("Invalid micCode %q, must be an ISO 10383 market identifier code", mic)}
+	}
+	return nil
+}
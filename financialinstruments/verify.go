@@ -0,0 +1,194 @@
+package financialinstruments
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/jmcvetta/neoism"
+)
+
+//Discrepancy is a difference Verify found between what is stored for a financial instrument and what Read returns for it
+type Discrepancy struct {
+	// Field is the JSON name of the field of the financial instrument that differs, e.g. figiCode
+	Field string `json:"field"`
+	// Stored is what is stored, as a property of the node, its identifiers or the Things it is related to
+	Stored interface{} `json:"stored"`
+	// Projected is what Read returns
+	Projected interface{} `json:"projected"`
+}
+
+//verifiedProperties are the JSON names of the fields of a financial instrument that are stored as properties of its node
+var verifiedProperties = []string{"prefLabel", "aliases", "currency", "micCode", "sector", "countryOfRisk", "isTest", "source", "trustLevel", "issueDate", "status", "primaryIdentifierType"}
+
+//identifierFields are the JSON names of the alternative identifiers fields each identifier type is read into
+var identifierFields = map[string]string{
+	uppIdentifierLabel:     "uuids",
+	factsetIdentifierLabel: "factsetIdentifier",
+	figiIdentifierLabel:    "figiCode",
+	wsodIdentifierLabel:    "wsodIdentifier",
+}
+
+//Verify compares the node of the financial instrument with the given uuid, the identifiers that identify it and the Things it
+//is ISSUED_BY and TAGGED_WITH, with what Read returns for it, returning any differences, e.g. two FIGIIdentifiers when Read can
+//only return one of them. A property on the node named after an alternative identifiers field, e.g. figiCode, must match what Read
+//returns for that field too. Everything is read in the same transaction, bypassing the cache, so it is the graph that is verified.
+//It returns false if there is no such financial instrument.
+func (s service) Verify(uuid string) ([]Discrepancy, bool, error) {
+	propsResults := []struct {
+		Props map[string]interface{} `json:"props"`
+	}{}
+	propsQuery := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument {uuid:{uuid}})
+				RETURN properties(fi) as props`,
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		Result: &propsResults,
+	}
+
+	identifierResults := []struct {
+		Labels []string `json:"labels"`
+		Value  string   `json:"value"`
+	}{}
+	identifiersQuery := &neoism.CypherQuery{
+		Statement: `MATCH (i:Identifier)-[:IDENTIFIES]->(fi:FinancialInstrument {uuid:{uuid}})
+				RETURN labels(i) as labels, i.value as value`,
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		Result: &identifierResults,
+	}
+
+	relationshipResults := []struct {
+		Type string `json:"type"`
+		UUID string `json:"uuid"`
+	}{}
+	relationshipsQuery := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument {uuid:{uuid}})-[r:ISSUED_BY|TAGGED_WITH]->(t:Thing)
+				RETURN type(r) as type, t.uuid as uuid`,
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		Result: &relationshipResults,
+	}
+
+	results := []financialInstrumentRow{}
+	readQuery := &neoism.CypherQuery{
+		Statement: `MATCH (fi:FinancialInstrument {uuid:{uuid}})` + s.financialInstrumentProjection(),
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		Result: &results,
+	}
+
+	if err := s.cypherBatch([]*neoism.CypherQuery{propsQuery, identifiersQuery, relationshipsQuery, readQuery}); err != nil {
+		return nil, false, err
+	}
+	if len(propsResults) == 0 || len(results) == 0 {
+		return nil, false, nil
+	}
+
+	props := propsResults[0].Props
+	fi := normalise(results[0].decode())
+	projected, err := asProperties(fi)
+	if err != nil {
+		return nil, false, err
+	}
+
+	discrepancies := []Discrepancy{}
+	for _, name := range verifiedProperties {
+		if !reflect.DeepEqual(props[name], projected[name]) {
+			discrepancies = append(discrepancies, Discrepancy{name, props[name], projected[name]})
+		}
+	}
+
+	stored := map[string][]string{}
+	for _, result := range identifierResults {
+		if identifierType := s.identifierType(result.Labels); identifierType != "" {
+			stored[identifierType] = append(stored[identifierType], result.Value)
+		}
+	}
+	for _, identifierType := range identifierTypes {
+		field := identifierFields[identifierType]
+		storedValues := sortedValues(stored[identifierType])
+		projectedValues := sortedValues(identifierValues(fi, identifierType))
+		if !reflect.DeepEqual(storedValues, projectedValues) {
+			discrepancies = append(discrepancies, Discrepancy{field, storedValues, projectedValues})
+		}
+		if property, ok := props[field]; ok {
+			propertyValues, err := stringsColumn(property, field)
+			if value, isString := property.(string); isString {
+				propertyValues, err = []string{value}, nil
+			}
+			if err != nil || !reflect.DeepEqual(sortedValues(propertyValues), projectedValues) {
+				discrepancies = append(discrepancies, Discrepancy{field, property, projectedValues})
+			}
+		}
+	}
+
+	related := map[string][]string{}
+	for _, result := range relationshipResults {
+		related[result.Type] = append(related[result.Type], result.UUID)
+	}
+	issuedBy := []string{}
+	if fi.IssuedBy != "" {
+		issuedBy = append(issuedBy, fi.IssuedBy)
+	}
+	for _, relationship := range []struct {
+		field     string
+		stored    []string
+		projected []string
+	}{
+		{"issuedBy", related["ISSUED_BY"], issuedBy},
+		{"tags", related["TAGGED_WITH"], fi.Tags},
+	} {
+		storedValues := sortedValues(relationship.stored)
+		projectedValues := sortedValues(relationship.projected)
+		if !reflect.DeepEqual(storedValues, projectedValues) {
+			discrepancies = append(discrepancies, Discrepancy{relationship.field, storedValues, projectedValues})
+		}
+	}
+	return discrepancies, true, nil
+}
+
+//asProperties returns fi as the properties its JSON encodes to, so they can be compared with those read from Neo4j
+func asProperties(fi financialInstrument) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(fi)
+	if err != nil {
+		return nil, err
+	}
+	properties := map[string]interface{}{}
+	if err := json.Unmarshal(encoded, &properties); err != nil {
+		return nil, err
+	}
+	return properties, nil
+}
+
+//sortedValues returns a sorted copy of values, which is empty rather than nil so that no values compare equal however they were read
+func sortedValues(values []string) []string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+//stringsColumn decodes a list of strings read from Neo4j, such as a property that is an array
+func stringsColumn(value interface{}, column string) ([]string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Unexpected %T for %s in financial instrument row", value, column)
+	}
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("Unexpected %T in %s in financial instrument row", v, column)
+		}
+		strs = append(strs, str)
+	}
+	return strs, nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/Financial-Times/financial-instruments-rw-neo4j/financialinstruments"
 	"github.com/Financial-Times/go-fthealth/v1a"
 	"github.com/Financial-Times/neo-utils-go/neoutils"
+	"github.com/Financial-Times/up-rw-app-api-go/rwapi"
 	log "github.com/Sirupsen/logrus"
 	"github.com/jawher/mow.cli"
 )
@@ -63,13 +64,15 @@ func main() {
 		if err != nil {
 			log.Errorf("Could not connect to neo4j, error=[%s]\n", err)
 		}
-		financialInstrumentsDriver := financialinstruments.NewCypherFinancialInstrumentService(db)
-		financialInstrumentsDriver.Initialise()
+		financialInstrumentsDriver := financialinstruments.NewCypherFinancialInstrumentService(db, db)
+		if err := financialInstrumentsDriver.Initialise(); err != nil {
+			log.Errorf("Could not initialise the financial instruments service, error=[%s]\n", err)
+		}
 
 		baseftrwapp.OutputMetricsIfRequired(*graphiteTCPAddress, *graphitePrefix, *logMetrics)
 
 		services := map[string]baseftrwapp.Service{
-			"financial-instruments": financialInstrumentsDriver,
+			"financial-instruments": httpService{financialInstrumentsDriver},
 		}
 
 		var checks []v1a.Check
@@ -105,3 +108,43 @@ func makeCheck(service baseftrwapp.Service, cr neoutils.CypherRunner) v1a.Check
 		},
 	}
 }
+
+//rwService is what baseftrwapp serves of the financial instruments service
+type rwService interface {
+	baseftrwapp.Service
+	baseftrwapp.ServiceWithIDs
+}
+
+//httpService serves the financial instruments service through baseftrwapp, which only maps errors with InvalidRequestDetails
+//to 400 and rwapi.ConstraintOrTransactionError to 409, so the service's classified errors are returned as those where they fit
+type httpService struct {
+	rwService
+}
+
+func (hs httpService) Write(thing interface{}, transID string) error {
+	return httpError(hs.rwService.Write(thing, transID))
+}
+
+func (hs httpService) Delete(uuid string, transID string) (bool, error) {
+	found, err := hs.rwService.Delete(uuid, transID)
+	return found, httpError(err)
+}
+
+//readOnlyRequest is a financialinstruments.ReadOnlyError returned as an invalid request, as baseftrwapp can't return a 405
+type readOnlyRequest struct {
+	financialinstruments.ReadOnlyError
+}
+
+func (ror readOnlyRequest) InvalidRequestDetails() string {
+	return ror.Error()
+}
+
+func httpError(err error) error {
+	switch err := err.(type) {
+	case financialinstruments.ConflictError:
+		return rwapi.ConstraintOrTransactionError{Message: err.Error()}
+	case financialinstruments.ReadOnlyError:
+		return readOnlyRequest{err}
+	}
+	return err
+}